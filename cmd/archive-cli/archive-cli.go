@@ -1,7 +1,517 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Heng-Bian/archive-proxy/pkg/archive"
+)
+
+const usage = `archive-cli peeks into local or remote archives (zip, tar, rar, 7z, ar, deb, cpio, rpm, iso)
+without downloading them entirely.
+
+Usage:
+  archive-cli <command> [flags] <archive> [args]
+
+<archive> is a http(s) URL supporting Range requests or a local file path.
+With -server (or ARCHIVE_SERVER) the archive URL is accessed through a
+running archive-server instead, for archives that are not reachable directly.
+
+Commands:
+  ls [pattern...]       list entries, optionally filtered by glob patterns
+  tree                  print entries as a directory tree
+  info                  print a summary of the archive
+  cat <entry>           write the content of an entry to stdout or -o
+  extract [pattern...]  extract entries into the directory given by -o
+  pack [pattern...]     pack entries into the zip file given by -o
+  test                  decompress every entry and report corrupt ones
+
+Run 'archive-cli <command> -help' for the flags of a command.
+`
+
+type command struct {
+	name string
+	args string
+	run  func(src source, opts *options, args []string) error
+}
+
+var commands = []command{
+	{"ls", "[pattern...]", runLs},
+	{"tree", "", runTree},
+	{"info", "", runInfo},
+	{"cat", "<entry>", runCat},
+	{"extract", "[pattern...]", runExtract},
+	{"pack", "[pattern...]", runPack},
+	{"test", "", runTest},
+}
+
+// options are the archive location and the flags of a command.
+type options struct {
+	location string
+	server   string
+	charset  string
+	format   string
+	json     bool
+	quiet    bool
+	long     bool
+	index    int
+	output   string
+	resume   bool
+	exclude  stringList
+}
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
 
 func main() {
-	fmt.Println("hello,world!")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		fmt.Fprint(os.Stdout, usage)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := execute(cmd, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "archive-cli %s: %s\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "archive-cli: unknown command %q\n\n%s", name, usage)
+	os.Exit(2)
+}
+
+func execute(cmd command, arguments []string) error {
+	opts := &options{index: -1}
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: archive-cli %s [flags] <archive> %s\n\nFlags:\n", cmd.name, cmd.args)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.server, "server", os.Getenv("ARCHIVE_SERVER"), "URL of an archive-server to access the archive through [ARCHIVE_SERVER]")
+	fs.StringVar(&opts.charset, "charset", "", "charset of entry names, eg. GBK, Shift_JIS (zip and tar only), detected by default")
+	fs.StringVar(&opts.format, "format", "", "archive format (zip, tar, rar, 7z, ar, deb, cpio, rpm, iso), autodetect by default, oci for the merged layers of a container image tarball")
+	fs.BoolVar(&opts.json, "json", false, "print the result as JSON")
+	fs.BoolVar(&opts.quiet, "q", false, "do not show progress bars")
+	fs.Var(&opts.exclude, "exclude", "glob pattern of entries to skip, can be given multiple times")
+	switch cmd.name {
+	case "ls":
+		fs.BoolVar(&opts.long, "l", false, "print size and modification time")
+	case "cat":
+		fs.IntVar(&opts.index, "index", -1, "select the entry by its index in the list instead of its name")
+		fs.StringVar(&opts.output, "o", "", "file to write instead of stdout")
+		fs.BoolVar(&opts.resume, "resume", false, "continue an interrupted download of the -o file")
+	case "extract":
+		fs.StringVar(&opts.output, "o", ".", "directory to extract into")
+		fs.BoolVar(&opts.resume, "resume", false, "continue partially extracted files instead of overwriting them")
+	case "pack":
+		fs.StringVar(&opts.output, "o", "", "zip file to write, \"-\" means stdout")
+	}
+	args, err := parseInterspersed(fs, arguments)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	opts.location = args[0]
+	return cmd.run(newSource(ctx, opts, opts.location), opts, args[1:])
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// entries lists the entries of the archive that match the patterns and do
+// not match the excluded ones.
+func entries(src source, opts *options, patterns []string) ([]archive.Entry, string, error) {
+	all, format, err := src.list()
+	if err != nil {
+		return nil, format, err
+	}
+	selected := all[:0]
+	for _, entry := range all {
+		if selectEntry(opts, entry.Name, patterns) {
+			selected = append(selected, entry)
+		}
+	}
+	return selected, format, nil
+}
+
+func selectEntry(opts *options, name string, patterns []string) bool {
+	if len(patterns) > 0 && !matchAny(patterns, name) {
+		return false
+	}
+	return !matchAny(opts.exclude, name)
+}
+
+// matchAny reports whether name or one of its parent directories matches
+// one of the glob patterns.
+func matchAny(patterns []string, name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		for p := name; p != "" && p != "."; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+			if !strings.Contains(p, "/") {
+				break
+			}
+		}
+	}
+	return false
+}
+
+func runLs(src source, opts *options, patterns []string) error {
+	list, _, err := entries(src, opts, patterns)
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(list)
+	}
+	if !opts.long {
+		for _, entry := range list {
+			fmt.Println(entry.Name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, entry := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t %s\n", entry.Mode, formatSize(entry.Size), formatTime(entry.ModTime), entry.Name)
+	}
+	return w.Flush()
+}
+
+func runTree(src source, opts *options, patterns []string) error {
+	list, _, err := entries(src, opts, patterns)
+	if err != nil {
+		return err
+	}
+	root := archive.BuildTree(list)
+	if opts.json {
+		return printJSON(root)
+	}
+	fmt.Println(".")
+	printTree(root, "")
+	return nil
+}
+
+func printTree(node *archive.TreeNode, indent string) {
+	for i, child := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+		name := child.Name
+		if child.IsDir {
+			name = name + "/"
+		}
+		fmt.Println(indent + branch + name)
+		printTree(child, indent+next)
+	}
+}
+
+// summary is printed by the info command.
+type summary struct {
+	Location    string
+	FileType    string
+	ArchiveSize int64
+	Entries     int
+	Files       int
+	Dirs        int
+	// TotalSize is the uncompressed size of all files, -1 if unknown
+	TotalSize int64
+}
+
+func runInfo(src source, opts *options, patterns []string) error {
+	list, format, err := src.list()
+	if err != nil {
+		return err
+	}
+	s := summary{Location: opts.location, FileType: format, ArchiveSize: src.size()}
+	for _, entry := range list {
+		if !selectEntry(opts, entry.Name, patterns) {
+			continue
+		}
+		s.Entries++
+		if entry.IsDir {
+			s.Dirs++
+			continue
+		}
+		s.Files++
+		if entry.Size < 0 || s.TotalSize < 0 {
+			s.TotalSize = -1
+		} else {
+			s.TotalSize += entry.Size
+		}
+	}
+	if opts.json {
+		return printJSON(s)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Location:\t%s\n", s.Location)
+	fmt.Fprintf(w, "Format:\t%s\n", s.FileType)
+	fmt.Fprintf(w, "Archive size:\t%s\n", formatSize(s.ArchiveSize))
+	fmt.Fprintf(w, "Entries:\t%d (%d files, %d directories)\n", s.Entries, s.Files, s.Dirs)
+	fmt.Fprintf(w, "Uncompressed size:\t%s\n", formatSize(s.TotalSize))
+	return w.Flush()
+}
+
+func runCat(src source, opts *options, args []string) error {
+	if opts.index < 0 && len(args) != 1 {
+		return errors.New("exactly one entry name or -index must be given")
+	}
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if opts.output == "" {
+		r, err := src.open(name, opts.index, 0)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	}
+	return download(opts.output, name, -1, opts, func(offset int64) (io.ReadCloser, error) {
+		return src.open(name, opts.index, offset)
+	})
+}
+
+func runExtract(src source, opts *options, patterns []string) error {
+	extracted := make([]string, 0, 10)
+	err := src.walk(func(entry *archive.Entry, open openFunc) error {
+		if !selectEntry(opts, entry.Name, patterns) {
+			return nil
+		}
+		target, err := extractPath(opts.output, entry.Name)
+		if err != nil {
+			return err
+		}
+		if err := extractEntry(target, entry, opts, open); err != nil {
+			return err
+		}
+		extracted = append(extracted, target)
+		if !opts.json {
+			fmt.Println(target)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		return printJSON(extracted)
+	}
+	return nil
+}
+
+// extractPath returns the path to extract the named entry to, refusing
+// names that would escape dir.
+func extractPath(dir string, name string) (string, error) {
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	if clean == "/" {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(clean))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry %q is outside of the target directory", name)
+	}
+	return target, nil
+}
+
+func extractEntry(target string, entry *archive.Entry, opts *options, open openFunc) error {
+	if entry.IsDir {
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := download(target, entry.Name, entry.Size, opts, open); err != nil {
+		return fmt.Errorf("fail to extract %s,err:%s", entry.Name, err)
+	}
+	if perm := entry.Mode.Perm(); perm != 0 {
+		os.Chmod(target, perm)
+	}
+	if !entry.ModTime.IsZero() {
+		os.Chtimes(target, entry.ModTime, entry.ModTime)
+	}
+	return nil
+}
+
+// download writes the content returned by open to target with a progress
+// bar. With -resume an existing target is continued from its current size.
+func download(target string, name string, size int64, opts *options, open openFunc) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	var offset int64
+	if opts.resume {
+		if info, err := os.Stat(target); err == nil {
+			offset = info.Size()
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+	}
+	if size >= 0 && offset == size {
+		return nil
+	}
+	r, err := open(offset)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	file, err := os.OpenFile(target, flags, 0644)
+	if err != nil {
+		return err
+	}
+	bar := newProgress(file, name, offset, size, opts.quiet || opts.json)
+	_, err = io.Copy(bar, r)
+	bar.Done()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func runPack(src source, opts *options, patterns []string) error {
+	if opts.output == "" {
+		return errors.New("the output zip file must be given by -o")
+	}
+	list, _, err := entries(src, opts, patterns)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(list))
+	for _, entry := range list {
+		names = append(names, entry.Name)
+	}
+	var w io.Writer = os.Stdout
+	if opts.output != "-" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		bar := newProgress(file, opts.output, 0, -1, opts.quiet || opts.json)
+		defer bar.Done()
+		w = bar
+	}
+	if err := src.pack(w, names); err != nil {
+		return err
+	}
+	if opts.json && opts.output != "-" {
+		return printJSON(names)
+	}
+	return nil
+}
+
+// errCorrupt makes archive-cli exit with status 1 after a failed test.
+var errCorrupt = errors.New("the archive is corrupt")
+
+func runTest(src source, opts *options, args []string) error {
+	res, format, err := src.test()
+	if err != nil {
+		return err
+	}
+	if opts.json {
+		if err := printJSON(struct {
+			FileType string
+			*archive.TestResult
+		}{format, res}); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, entry := range res.Entries {
+			if entry.OK {
+				fmt.Fprintf(w, "OK\t%s\t%s\n", formatSize(entry.Read), entry.Name)
+			} else {
+				fmt.Fprintf(w, "FAILED\t%s\t%s: %s\n", formatSize(entry.Read), entry.Name, entry.Error)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if res.Error != "" {
+			fmt.Printf("error: %s\n", res.Error)
+		}
+		fmt.Printf("%d entries, %d passed, %d failed, %s decompressed\n", len(res.Entries), res.Passed, res.Failed, formatSize(res.Bytes))
+	}
+	if !res.OK {
+		return errCorrupt
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(v)
+}
+
+func formatSize(size int64) string {
+	if size < 0 {
+		return "-"
+	}
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progress is a writer that counts the bytes written through it and draws
+// a progress bar on stderr when stderr is a terminal.
+type progress struct {
+	w     io.Writer
+	label string
+	// total is the expected size, -1 if unknown
+	total int64
+	n     int64
+	start time.Time
+	drawn time.Time
+	show  bool
+}
+
+func newProgress(w io.Writer, label string, done int64, total int64, quiet bool) *progress {
+	return &progress{
+		w:     w,
+		label: label,
+		total: total,
+		n:     done,
+		start: time.Now(),
+		show:  !quiet && isTerminal(os.Stderr),
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	if p.show && time.Since(p.drawn) > 200*time.Millisecond {
+		p.draw()
+	}
+	return n, err
+}
+
+// Done draws the final state of the bar and ends its line.
+func (p *progress) Done() {
+	if p.show {
+		p.draw()
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *progress) draw() {
+	p.drawn = time.Now()
+	rate := "-"
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = formatSize(int64(float64(p.n)/elapsed)) + "/s"
+	}
+	label := p.label
+	if len(label) > 30 {
+		label = "..." + label[len(label)-27:]
+	}
+	if p.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%-30s %10s %12s", label, formatSize(p.n), rate)
+		return
+	}
+	const width = 30
+	filled := int(p.n * width / p.total)
+	if filled > width {
+		filled = width
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	fmt.Fprintf(os.Stderr, "\r%-30s [%s] %3d%% %10s %12s", label, bar, p.n*100/p.total, formatSize(p.n), rate)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Heng-Bian/archive-proxy/pkg/archive"
+	"github.com/Heng-Bian/archive-proxy/pkg/client"
+	"github.com/Heng-Bian/httpreader"
+)
+
+// openFunc returns the content of an entry, skipping the first offset bytes.
+type openFunc func(offset int64) (io.ReadCloser, error)
+
+// source gives access to an archive, either directly by Range requests or
+// through a running archive-server.
+type source interface {
+	// list returns the entries of the archive and its format
+	list() ([]archive.Entry, string, error)
+	// open returns the content of the entry selected by name, or by index
+	// if index is not negative, skipping the first offset bytes
+	open(name string, index int, offset int64) (io.ReadCloser, error)
+	// walk calls fn for every entry of the archive in order
+	walk(fn func(entry *archive.Entry, open openFunc) error) error
+	// pack writes a zip of the named entries to w
+	pack(w io.Writer, names []string) error
+	// test decompresses every entry and reports the broken ones
+	test() (*archive.TestResult, string, error)
+	// size returns the size of the archive, -1 if unknown
+	size() int64
+}
+
+func newSource(ctx context.Context, opts *options, location string) source {
+	if opts.server != "" {
+		return &remoteSource{ctx: ctx, opts: opts, location: location, client: client.New(opts.server)}
+	}
+	return &localSource{opts: opts, location: location}
+}
+
+// localSource accesses the archive directly.
+type localSource struct {
+	opts     *options
+	location string
+	length   int64
+}
+
+// reader returns a reader of the archive and its format.
+func (s *localSource) reader() (*httpreader.Reader, string, error) {
+	reader, err := archive.LocationToReader(s.location, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	s.length = reader.Length
+	format := s.opts.format
+	if format == "" {
+		format, err = archive.DetectFormat(reader)
+		if err != nil {
+			reader.Close()
+			return nil, "", fmt.Errorf("fail to detect file type,err:%s", err)
+		}
+		if format == "" {
+			reader.Close()
+			return nil, "", errors.New("unknown archive format, use -format to specify it")
+		}
+	}
+	return reader, format, nil
+}
+
+func (s *localSource) list() ([]archive.Entry, string, error) {
+	reader, format, err := s.reader()
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+	entries, err := archive.ListEntries(format, reader, s.opts.charset)
+	return entries, format, err
+}
+
+func (s *localSource) open(name string, index int, offset int64) (io.ReadCloser, error) {
+	reader, format, err := s.reader()
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if index >= 0 {
+		r, err = archive.OpenByIndex(format, reader, index)
+	} else {
+		r, err = archive.OpenByName(format, reader, name, s.opts.charset)
+	}
+	if err == nil {
+		_, err = io.CopyN(io.Discard, r, offset)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return readCloser{r, reader}, nil
+}
+
+func (s *localSource) walk(fn func(entry *archive.Entry, open openFunc) error) error {
+	reader, format, err := s.reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return archive.Walk(format, reader, s.opts.charset, func(entry *archive.Entry, r io.Reader) error {
+		return fn(entry, func(offset int64) (io.ReadCloser, error) {
+			if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+				return nil, err
+			}
+			return io.NopCloser(r), nil
+		})
+	})
+}
+
+func (s *localSource) pack(w io.Writer, names []string) error {
+	reader, format, err := s.reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return archive.ToZip(format, w, reader, archive.NamesSelector(names), s.opts.charset, archive.DefaultCompression)
+}
+
+func (s *localSource) test() (*archive.TestResult, string, error) {
+	reader, format, err := s.reader()
+	if err != nil {
+		return nil, "", err
+	}
+	defer reader.Close()
+	res, err := archive.Test(format, reader, s.opts.charset)
+	return res, format, err
+}
+
+func (s *localSource) size() int64 {
+	return s.length
+}
+
+// remoteSource accesses the archive through an archive-server.
+type remoteSource struct {
+	ctx      context.Context
+	opts     *options
+	location string
+	client   *client.Client
+	length   int64
+}
+
+func (s *remoteSource) list() ([]archive.Entry, string, error) {
+	res, err := s.client.List(s.ctx, s.location, &client.ListOptions{Options: *s.clientOptions()})
+	if err != nil {
+		return nil, "", err
+	}
+	entries := make([]archive.Entry, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		entries = append(entries, archive.Entry{
+			Name:     entry.Name,
+			Size:     entry.Size,
+			Mode:     entry.FileMode(),
+			ModTime:  entry.ModTime,
+			IsDir:    entry.IsDir,
+			Uid:      entry.Uid,
+			Gid:      entry.Gid,
+			Linkname: entry.Linkname,
+			CRC32:    entry.CRC32,
+			ID:       entry.ID,
+		})
+	}
+	return entries, res.FileType, nil
+}
+
+func (s *remoteSource) open(name string, index int, offset int64) (io.ReadCloser, error) {
+	opts := &client.StreamOptions{Options: *s.clientOptions(), Offset: offset}
+	if index >= 0 {
+		return s.client.StreamIndex(s.ctx, s.location, index, opts)
+	}
+	return s.client.Stream(s.ctx, s.location, name, opts)
+}
+
+func (s *remoteSource) walk(fn func(entry *archive.Entry, open openFunc) error) error {
+	entries, _, err := s.list()
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		name := entries[i].Name
+		err := fn(&entries[i], func(offset int64) (io.ReadCloser, error) {
+			return s.open(name, -1, offset)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *remoteSource) pack(w io.Writer, names []string) error {
+	body, err := s.client.Pack(s.ctx, s.location, &client.PackRequest{Options: *s.clientOptions(), Names: names})
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(w, body)
+	return err
+}
+
+func (s *remoteSource) test() (*archive.TestResult, string, error) {
+	res, err := s.client.Test(s.ctx, s.location, s.clientOptions())
+	if err != nil {
+		return nil, "", err
+	}
+	entries := make([]archive.EntryTest, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		entries = append(entries, archive.EntryTest(entry))
+	}
+	return &archive.TestResult{
+		OK:      res.OK,
+		Entries: entries,
+		Passed:  res.Passed,
+		Failed:  res.Failed,
+		Bytes:   res.Bytes,
+		Error:   res.Error,
+	}, res.FileType, nil
+}
+
+func (s *remoteSource) size() int64 {
+	if s.length == 0 {
+		s.length = -1
+		if res, err := s.client.Info(s.ctx, s.location, s.clientOptions()); err == nil {
+			s.length = res.Upstream.Size
+		}
+	}
+	return s.length
+}
+
+func (s *remoteSource) clientOptions() *client.Options {
+	return &client.Options{Charset: s.opts.charset, Format: s.opts.format}
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
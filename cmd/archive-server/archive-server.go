@@ -7,7 +7,10 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/Heng-Bian/archive-proxy/internal/archiveproxy"
 	"github.com/Heng-Bian/archive-proxy/web"
@@ -21,40 +24,82 @@ var (
 	referrers          = flag.String("referrers", "", "comma separated list of allowed referring hosts")
 	includeReferer     = flag.Bool("includeReferer", true, "include referer header in remote requests")
 	passRequestHeaders = flag.String("passRequestHeaders", "", "comma separatetd list of request headers to pass to remote server")
+	configFile         = flag.String("config", "", "path to a YAML or TOML config file, reloaded on SIGHUP")
 )
 
+// current holds the *archiveproxy.Proxy built from the latest config.
+var current atomic.Value
+
 func main() {
 	parse("ARCHIVE")
 	flag.Parse()
 	log.SetFlags(log.Llongfile | log.LUTC)
-	proxy := archiveproxy.NewProxy(http.DefaultClient)
-	if *allowHosts != "" {
-		proxy.AllowHosts = strings.Split(*allowHosts, ",")
-	}
-	if *denyHosts != "" {
-		proxy.DenyHosts = strings.Split(*denyHosts, ",")
-	}
-	if *referrers != "" {
-		proxy.Referrers = strings.Split(*referrers, ",")
-	}
-	proxy.IncludeReferer = *includeReferer
-	if *passRequestHeaders != "" {
-		proxy.PassRequestHeaders = strings.Split(*passRequestHeaders, ",")
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
 	}
-	addr := *ip + ":" + *port
+	// the limiter outlives the reloads, so that the requests served by a
+	// previous Proxy still count against the limit
+	limiter := archiveproxy.NewLimiter(cfg.Limits.MaxConcurrent)
+	current.Store(cfg.newProxy(limiter))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go reloadOnSignal(signals, *configFile, cfg, limiter)
+	addr := cfg.listenAddr()
 	server := &http.Server{
 		Addr: addr,
 	}
 	// Serve the React app from the dist subdirectory
 	distFS, _ := fs.Sub(web.EmbedFS, "dist")
 	http.Handle("/", http.FileServer(http.FS(distFS)))
-	http.Handle("/healthz", http.HandlerFunc(proxy.ServeHealthCheck))
-	http.Handle("/list", http.HandlerFunc(proxy.ServeArchive))
-	http.Handle("/pack", http.HandlerFunc(proxy.ServeArchive))
-	http.Handle("/stream", http.HandlerFunc(proxy.ServeArchive))
+	http.Handle("/healthz", handle((*archiveproxy.Proxy).ServeHealthCheck))
+	http.Handle("/openapi.json", handle((*archiveproxy.Proxy).ServeOpenAPI))
+	http.Handle("/list", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/pack", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/convert", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/search", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/hash", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/test", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/diff", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/info", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/preview", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/preview/", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/stream", handle((*archiveproxy.Proxy).ServeArchive))
+	http.Handle("/stream/", handle((*archiveproxy.Proxy).ServeArchive))
 	server.ListenAndServe()
 }
 
+// handle serves every request with the Proxy that is current when the
+// request arrives, so a reload never affects in-flight requests.
+func handle(serve func(*archiveproxy.Proxy, http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serve(current.Load().(*archiveproxy.Proxy), w, r)
+	})
+}
+
+// reloadOnSignal reloads the config file at path on every signal, resizing
+// limiter to the new limit. An invalid config is logged and the previous one
+// stays in effect.
+func reloadOnSignal(signals <-chan os.Signal, path string, cfg *config, limiter *archiveproxy.Limiter) {
+	for range signals {
+		if path == "" {
+			log.Println("SIGHUP received but no config file is given, nothing to reload")
+			continue
+		}
+		next, err := loadConfig(path)
+		if err != nil {
+			log.Printf("fail to reload config,err:%s", err)
+			continue
+		}
+		if next.listenAddr() != cfg.listenAddr() {
+			log.Printf("listen address change to %s requires a restart, still listening on %s", next.listenAddr(), cfg.listenAddr())
+		}
+		limiter.SetMax(next.Limits.MaxConcurrent)
+		current.Store(next.newProxy(limiter))
+		log.Printf("config %s reloaded", path)
+	}
+}
+
 func parse(p string) {
 	update(p, flag.CommandLine)
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+
+	"github.com/Heng-Bian/archive-proxy/internal/archiveproxy"
+)
+
+// reload sends one SIGHUP to reloadOnSignal and waits for it to be handled.
+func reload(path string, cfg *config, limiter *archiveproxy.Limiter) {
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGHUP
+	close(signals)
+	reloadOnSignal(signals, path, cfg, limiter)
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	arrived := make(chan struct{})
+	gate := make(chan struct{})
+	var requests int32
+	// only the first request waits for the gate
+	upstream, _ := newUpstream(t, func(*http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(arrived)
+			<-gate
+		}
+	})
+	target := "/list?url=" + url.QueryEscape(upstream.URL+"/a.zip")
+	dir := t.TempDir()
+	path := writeFile(t, dir, "config.yaml", "limits:\n  maxConcurrent: 1\n")
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := archiveproxy.NewLimiter(cfg.Limits.MaxConcurrent)
+	current.Store(cfg.newProxy(limiter))
+	server := httptest.NewServer(handle((*archiveproxy.Proxy).ServeArchive))
+	defer server.Close()
+	get := func() int {
+		resp, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// a request holds the only slot while the config is reloaded
+	done := make(chan int)
+	go func() { done <- get() }()
+	<-arrived
+	before := current.Load()
+	writeFile(t, dir, "config.yaml", "limits:\n  maxConcurrent: 1\n  maxArchiveSize: 1048576\n")
+	reload(path, cfg, limiter)
+	if current.Load() == before {
+		t.Fatal("config not reloaded")
+	}
+	if status := get(); status != http.StatusServiceUnavailable {
+		t.Errorf("request over the limit after a reload: got %d", status)
+	}
+	close(gate)
+	if status := <-done; status != http.StatusOK {
+		t.Errorf("request served during the reload: got %d", status)
+	}
+	if status := get(); status != http.StatusOK {
+		t.Errorf("request after the slot is released: got %d", status)
+	}
+
+	// an invalid config keeps the previous one
+	before = current.Load()
+	writeFile(t, dir, "config.yaml", "limits:\n  maxConcurrent: -1\n")
+	reload(path, cfg, limiter)
+	if current.Load() != before {
+		t.Error("invalid config reloaded")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Heng-Bian/archive-proxy/internal/archiveproxy"
+	"gopkg.in/yaml.v3"
+)
+
+// config holds every setting of the archive-server. It can be loaded from a
+// YAML or TOML file; flags and ARCHIVE_* environment variables that are
+// explicitly set take precedence over the file.
+type config struct {
+	Port               string   `yaml:"port" toml:"port"`
+	IP                 string   `yaml:"ip" toml:"ip"`
+	AllowHosts         []string `yaml:"allowHosts" toml:"allowHosts"`
+	DenyHosts          []string `yaml:"denyHosts" toml:"denyHosts"`
+	Referrers          []string `yaml:"referrers" toml:"referrers"`
+	IncludeReferer     bool     `yaml:"includeReferer" toml:"includeReferer"`
+	PassRequestHeaders []string `yaml:"passRequestHeaders" toml:"passRequestHeaders"`
+
+	Limits      limitsConfig       `yaml:"limits" toml:"limits"`
+	Auth        authConfig         `yaml:"auth" toml:"auth"`
+	Credentials []credentialConfig `yaml:"credentials" toml:"credentials"`
+}
+
+// limitsConfig bounds the work of the server, zero values mean no limit.
+type limitsConfig struct {
+	// MaxArchiveSize is the size in bytes of the largest archive read
+	MaxArchiveSize int64 `yaml:"maxArchiveSize" toml:"maxArchiveSize"`
+	// MaxConcurrent is the number of archive requests served at once
+	MaxConcurrent int `yaml:"maxConcurrent" toml:"maxConcurrent"`
+	// UpstreamTimeout is how long to wait for the response headers of a
+	// remote host, a duration like "30s"
+	UpstreamTimeout string `yaml:"upstreamTimeout" toml:"upstreamTimeout"`
+}
+
+// authConfig requires clients to send a bearer token or the basic
+// credentials of a user. Nothing is required if both are empty.
+type authConfig struct {
+	Tokens []string          `yaml:"tokens" toml:"tokens"`
+	Users  map[string]string `yaml:"users" toml:"users"`
+}
+
+// credentialConfig authenticates the requests to the remote hosts it
+// matches, with a bearer token or basic credentials and extra headers.
+type credentialConfig struct {
+	Hosts    []string          `yaml:"hosts" toml:"hosts"`
+	Token    string            `yaml:"token" toml:"token"`
+	Username string            `yaml:"username" toml:"username"`
+	Password string            `yaml:"password" toml:"password"`
+	Headers  map[string]string `yaml:"headers" toml:"headers"`
+}
+
+// loadConfig builds the config from the flag defaults, the config file at
+// path (if any) and the explicitly set flags, in that order.
+func loadConfig(path string) (*config, error) {
+	c := new(config)
+	c.applyFlags(flag.VisitAll)
+	if path != "" {
+		if err := c.decodeFile(path); err != nil {
+			return nil, err
+		}
+	}
+	c.applyFlags(flag.Visit)
+	if err := c.validate(); err != nil {
+		if path != "" {
+			return nil, fmt.Errorf("invalid config %s:%s", path, err)
+		}
+		return nil, fmt.Errorf("invalid config:%s", err)
+	}
+	return c, nil
+}
+
+func (c *config) applyFlags(visit func(func(*flag.Flag))) {
+	visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			c.Port = *port
+		case "ip":
+			c.IP = *ip
+		case "allowHosts":
+			c.AllowHosts = splitList(*allowHosts)
+		case "denyHosts":
+			c.DenyHosts = splitList(*denyHosts)
+		case "referrers":
+			c.Referrers = splitList(*referrers)
+		case "includeReferer":
+			c.IncludeReferer = *includeReferer
+		case "passRequestHeaders":
+			c.PassRequestHeaders = splitList(*passRequestHeaders)
+		}
+	})
+}
+
+func (c *config) decodeFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("fail to read config,err:%s", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// an empty file is a valid config
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return fmt.Errorf("invalid config %s:%s", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("invalid config %s:%s", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			return fmt.Errorf("invalid config %s:unknown keys %s", path, strings.Join(keys, ","))
+		}
+	default:
+		return fmt.Errorf("unsupported config file %s, the extension must be .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// validate reports every invalid setting at once so that a broken config
+// can be fixed in a single pass.
+func (c *config) validate() error {
+	var problems []string
+	if n, err := strconv.Atoi(c.Port); err != nil || n < 1 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("port: %q is not a valid port number", c.Port))
+	}
+	if c.IP == "" {
+		problems = append(problems, "ip: must not be empty")
+	} else if net.ParseIP(c.IP) == nil && strings.ContainsAny(c.IP, ":/ ") {
+		problems = append(problems, fmt.Sprintf("ip: %q is neither an IP address nor a host name", c.IP))
+	}
+	problems = append(problems, validateHosts("allowHosts", c.AllowHosts)...)
+	problems = append(problems, validateHosts("denyHosts", c.DenyHosts)...)
+	problems = append(problems, validateHosts("referrers", c.Referrers)...)
+	authenticated := len(c.Auth.Tokens) > 0 || len(c.Auth.Users) > 0
+	for i, header := range c.PassRequestHeaders {
+		if !validHeaderName(header) {
+			problems = append(problems, fmt.Sprintf("passRequestHeaders[%d]: %q is not a valid header name", i, header))
+		} else if authenticated && strings.EqualFold(header, "Authorization") {
+			problems = append(problems, fmt.Sprintf("passRequestHeaders[%d]: Authorization must not be passed when auth is enabled", i))
+		}
+	}
+	problems = append(problems, c.Limits.validate()...)
+	problems = append(problems, c.Auth.validate()...)
+	for i, credential := range c.Credentials {
+		problems = append(problems, credential.validate(fmt.Sprintf("credentials[%d]", i))...)
+	}
+	if len(problems) > 0 {
+		return errors.New("\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (l *limitsConfig) validate() []string {
+	var problems []string
+	if l.MaxArchiveSize < 0 {
+		problems = append(problems, "limits.maxArchiveSize: must not be negative")
+	}
+	if l.MaxConcurrent < 0 {
+		problems = append(problems, "limits.maxConcurrent: must not be negative")
+	}
+	if l.UpstreamTimeout != "" {
+		if d, err := time.ParseDuration(l.UpstreamTimeout); err != nil || d < 0 {
+			problems = append(problems, fmt.Sprintf("limits.upstreamTimeout: %q is not a valid duration", l.UpstreamTimeout))
+		}
+	}
+	return problems
+}
+
+func (a *authConfig) validate() []string {
+	var problems []string
+	for i, token := range a.Tokens {
+		if token == "" || strings.ContainsAny(token, " \t\r\n") {
+			problems = append(problems, fmt.Sprintf("auth.tokens[%d]: must not be empty or contain spaces", i))
+		}
+	}
+	users := make([]string, 0, len(a.Users))
+	for user := range a.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if user == "" || strings.Contains(user, ":") {
+			problems = append(problems, fmt.Sprintf("auth.users: %q is not a valid user name", user))
+		} else if a.Users[user] == "" {
+			problems = append(problems, fmt.Sprintf("auth.users.%s: password must not be empty", user))
+		}
+	}
+	return problems
+}
+
+func (c *credentialConfig) validate(key string) []string {
+	var problems []string
+	if len(c.Hosts) == 0 {
+		problems = append(problems, key+".hosts: must not be empty")
+	}
+	problems = append(problems, validateHosts(key+".hosts", c.Hosts)...)
+	switch {
+	case c.Token != "" && (c.Username != "" || c.Password != ""):
+		problems = append(problems, key+": token and username/password are exclusive")
+	case c.Username == "" && c.Password != "":
+		problems = append(problems, key+".username: must not be empty with a password")
+	case c.Token == "" && c.Username == "" && len(c.Headers) == 0:
+		problems = append(problems, key+": needs a token, a username or headers")
+	}
+	names := make([]string, 0, len(c.Headers))
+	for name := range c.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !validHeaderName(name) {
+			problems = append(problems, fmt.Sprintf("%s.headers: %q is not a valid header name", key, name))
+		}
+	}
+	return problems
+}
+
+// validateHosts checks the entries of a host list, which may be host names,
+// "*." wildcards, IP addresses or CIDR ranges.
+func validateHosts(key string, hosts []string) []string {
+	var problems []string
+	for i, host := range hosts {
+		switch {
+		case host == "":
+			problems = append(problems, fmt.Sprintf("%s[%d]: must not be empty", key, i))
+		case strings.Contains(host, "://"):
+			problems = append(problems, fmt.Sprintf("%s[%d]: %q must be a bare host name without scheme or port", key, i, host))
+		case strings.Contains(host, "/"):
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %q is not a valid CIDR", key, i, host))
+			}
+		case strings.HasPrefix(host, "*."):
+			if len(host) == 2 || strings.ContainsAny(host[2:], "*: ") {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %q is not a valid wildcard host", key, i, host))
+			}
+		case net.ParseIP(host) != nil:
+		case strings.ContainsAny(host, "*: "):
+			problems = append(problems, fmt.Sprintf("%s[%d]: %q must be a bare host name without scheme or port", key, i, host))
+		}
+	}
+	return problems
+}
+
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > 127 || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// listenAddr returns the address the server listens on.
+func (c *config) listenAddr() string {
+	return net.JoinHostPort(c.IP, c.Port)
+}
+
+// newProxy creates a Proxy configured by c, limited by limiter which is
+// shared with the Proxy of the previous configs.
+func (c *config) newProxy(limiter *archiveproxy.Limiter) *archiveproxy.Proxy {
+	proxy := archiveproxy.NewProxy(http.DefaultClient)
+	proxy.AllowHosts = c.AllowHosts
+	proxy.DenyHosts = c.DenyHosts
+	proxy.Referrers = c.Referrers
+	proxy.IncludeReferer = c.IncludeReferer
+	proxy.PassRequestHeaders = c.PassRequestHeaders
+	proxy.MaxArchiveSize = c.Limits.MaxArchiveSize
+	proxy.Limiter = limiter
+	if c.Limits.UpstreamTimeout != "" {
+		timeout, _ := time.ParseDuration(c.Limits.UpstreamTimeout)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = timeout
+		proxy.Client = &http.Client{Transport: transport}
+	}
+	proxy.AuthTokens = c.Auth.Tokens
+	proxy.AuthUsers = c.Auth.Users
+	for _, credential := range c.Credentials {
+		proxy.Credentials = append(proxy.Credentials, archiveproxy.Credential{
+			Hosts:    credential.Hosts,
+			Token:    credential.Token,
+			Username: credential.Username,
+			Password: credential.Password,
+			Headers:  credential.Headers,
+		})
+	}
+	return proxy
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Heng-Bian/archive-proxy/internal/archiveproxy"
+)
+
+// get requests /list of target from a proxy built from the config at path.
+func get(t *testing.T, path, target string, header http.Header) (int, string) {
+	t.Helper()
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(cfg.newProxy(archiveproxy.NewLimiter(cfg.Limits.MaxConcurrent)).ServeArchive))
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/list?url="+url.QueryEscape(target), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestConfigDenyHosts(t *testing.T) {
+	upstream, _ := newUpstream(t, nil)
+	u, _ := url.Parse(upstream.URL)
+	path := writeFile(t, t.TempDir(), "config.yaml", "denyHosts:\n  - localhost\n")
+	status, body := get(t, path, "http://localhost:"+u.Port()+"/a.zip", nil)
+	if status != http.StatusInternalServerError || !strings.Contains(body, "denied host") {
+		t.Errorf("denied host: got %d %q", status, body)
+	}
+	status, body = get(t, path, upstream.URL+"/a.zip", nil)
+	if status != http.StatusOK || !strings.Contains(body, "a.txt") {
+		t.Errorf("other host: got %d %q", status, body)
+	}
+}
+
+func TestConfigPolicies(t *testing.T) {
+	upstream, authorization := newUpstream(t, nil)
+	u, _ := url.Parse(upstream.URL)
+	path := writeFile(t, t.TempDir(), "config.toml", `
+[limits]
+maxConcurrent = 4
+upstreamTimeout = "10s"
+
+[auth]
+tokens = ["secret"]
+
+[[credentials]]
+hosts = ["`+u.Hostname()+`"]
+token = "upstream"
+`)
+	status, _ := get(t, path, upstream.URL+"/a.zip", nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("without token: got %d", status)
+	}
+	status, body := get(t, path, upstream.URL+"/a.zip", http.Header{"Authorization": {"Bearer secret"}})
+	if status != http.StatusOK || !strings.Contains(body, "a.txt") {
+		t.Errorf("with token: got %d %q", status, body)
+	}
+	if *authorization != "Bearer upstream" {
+		t.Errorf("upstream credential: got %q", *authorization)
+	}
+
+	path = writeFile(t, t.TempDir(), "config.yaml", "limits:\n  maxArchiveSize: 10\n")
+	status, _ = get(t, path, upstream.URL+"/a.zip", nil)
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("archive over the size limit: got %d", status)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "config.yaml", `
+denyHosts: ["http://example.com"]
+passRequestHeaders: [Authorization]
+limits:
+  maxConcurrent: -1
+  upstreamTimeout: soon
+auth:
+  users:
+    alice: ""
+credentials:
+  - hosts: []
+    token: t
+    username: u
+`)
+	_, err := loadConfig(path)
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, key := range []string{"denyHosts[0]", "passRequestHeaders[0]", "limits.maxConcurrent", "limits.upstreamTimeout", "auth.users.alice", "credentials[0].hosts", "credentials[0]: token"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("missing problem %s in %s", key, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes content to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newUpstream serves a zip holding a.txt, recording the Authorization header
+// of the last request. before, if not nil, is called before every request is
+// served.
+func newUpstream(t *testing.T, before func(*http.Request)) (*httptest.Server, *string) {
+	t.Helper()
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("a.txt")
+	if err == nil {
+		_, err = io.WriteString(w, "hello")
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "a.zip"), buf.Bytes(), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authorization string
+	files := http.FileServer(http.Dir(dir))
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if before != nil {
+			before(r)
+		}
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &authorization
+}
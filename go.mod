@@ -6,16 +6,22 @@ require (
 	github.com/gabriel-vasile/mimetype v1.2.0
 	github.com/nwaples/rardecode/v2 v2.0.0-beta.2
 	github.com/ulikunitz/xz v0.5.10
-	golang.org/x/text v0.3.7
+	golang.org/x/text v0.11.0
 )
 
 require (
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/saracen/go7z-fixtures v0.0.0-20190623165746-aa6b8fba1d2f // indirect
 	github.com/saracen/solidblock v0.0.0-20190426153529-45df20abab6f // indirect
-	golang.org/x/tools v0.1.12 // indirect
 )
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Heng-Bian/httpreader v1.1.0
+	github.com/klauspost/compress v1.16.7
+	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
 	github.com/saracen/go7z v0.0.0-20191010121135-9c09b6bd7fda
+	golang.org/x/image v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
+	lukechampine.com/blake3 v1.1.7
 )
@@ -1,20 +1,29 @@
 package archiveproxy
 
 import (
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/Heng-Bian/archive-proxy/pkg/archive"
+	"github.com/Heng-Bian/httpreader"
 	"github.com/ulikunitz/xz"
 )
 
@@ -23,22 +32,207 @@ const (
 	targetUrl  = "url"
 	charset    = "charset"
 	fileIndex  = "index"
+	fileID     = "id"
 	fileFormat = "format"
+	offset     = "offset"
+	//output archive format of /pack and /convert
+	outputFormat     = "output"
+	compressionLevel = "level"
+	//options of /pack
+	strictPack   = "strict"
+	manifestName = "manifest"
+)
+
+// parameter name of /search
+const (
+	searchPattern = "pattern"
+	searchRegex   = "regex"
+	ignoreCase    = "ignoreCase"
+	includeGlob   = "include"
+	excludeGlob   = "exclude"
+	contextLines  = "context"
+	maxMatches    = "maxMatches"
+	maxBytes      = "maxBytes"
+	maxEntryBytes = "maxEntryBytes"
+)
+
+// parameter name of /list
+const (
+	listPrefix = "prefix"
+	listDepth  = "depth"
+	listSort   = "sort"
+	listOrder  = "order"
+	listLimit  = "limit"
+	listCursor = "cursor"
+	listNDJSON = "ndjson"
+	listView   = "view"
+)
+
+// parameter name of /preview, which also takes maxBytes
+const previewThumbnail = "thumbnail"
+
+// the largest maxBytes and thumbnail of /preview
+const (
+	maxPreviewBytes     = 1 << 20
+	maxPreviewThumbnail = 1024
+)
+
+// parameter name of /info reading the metadata of a document in a zip
+// container
+const infoDocument = "document"
+
+// treeView is the view parameter of /list returning a nested tree.
+const treeView = "tree"
+
+// headers, or trailers when streaming, of the JSON lines of /list
+const (
+	listTotalHeader   = "X-List-Total"
+	listCursorHeader  = "X-List-Next-Cursor"
+	listCharsetHeader = "X-List-Charset"
+)
+
+// parameter name of /diff
+const (
+	otherArchive   = "other"
+	otherFormat    = "otherFormat"
+	otherCharset   = "otherCharset"
+	compareContent = "content"
+	unifiedDiff    = "unified"
+	maxDiffSize    = "maxDiffSize"
+)
+
+// hashAlgorithm is the parameter name of the digests of /hash
+const hashAlgorithm = "algorithm"
+
+// defaultMaxMatches is the number of matches after which /search stops
+// unless maxMatches is given.
+const defaultMaxMatches = 1000
+
+// trailers reporting the outcome of /search
+const (
+	searchMatchesTrailer   = "X-Search-Matches"
+	searchEntriesTrailer   = "X-Search-Entries"
+	searchBytesTrailer     = "X-Search-Bytes"
+	searchTruncatedTrailer = "X-Search-Truncated"
+	searchErrorTrailer     = "X-Search-Error"
+)
+
+// defaultManifest is the name of the manifest entry of /pack if the
+// manifest parameter is only "true".
+const defaultManifest = "MANIFEST.json"
+
+// trailers reporting the outcome of /pack
+const (
+	packStatusTrailer  = "X-Pack-Status"
+	packEntriesTrailer = "X-Pack-Entries"
+	packMissingTrailer = "X-Pack-Missing"
+	packErrorTrailer   = "X-Pack-Error"
 )
 
 var (
 	errReferrer   = errors.New("request does not contain an allowed referrer")
 	errDeniedHost = errors.New("request contains a denied host")
 	errNotAllowed = errors.New("requested URL is not allowed")
+	errTooLarge   = errors.New("archive exceeds the size limit")
+
+	errUnauthorized     = errors.New("missing or invalid credentials")
+	errTooManyRequests  = errors.New("too many concurrent requests")
+	errMethodNotAllowed = errors.New("method not allowed")
 )
 
+// paramError is an invalid parameter of a request, reported with 400.
+type paramError struct {
+	error
+}
+
 type ArchiveStruct struct {
 	FileType string
 	Files    []string
+	// Charset is the charset the names were decoded with, the given one or
+	// the detected one
+	Charset string `json:",omitempty"`
+	// Entries describe Files when /list is given listing parameters
+	Entries []archive.Entry `json:",omitempty"`
+	// Total is the number of entries of all pages
+	Total int `json:",omitempty"`
+	// NextCursor is the cursor parameter of the next page, empty on the
+	// last page
+	NextCursor string `json:",omitempty"`
 }
 
 var empty ArchiveStruct
 
+// HashStruct is the manifest returned by /hash.
+type HashStruct struct {
+	FileType   string
+	Algorithms []string
+	Entries    []archive.EntryHash
+	// Failed is the number of entries not matching their stored checksum
+	Failed int
+}
+
+// PreviewStruct is the response of /preview.
+type PreviewStruct struct {
+	FileType string
+	*archive.EntryPreview
+}
+
+// TreeStruct is the response of /list?view=tree.
+type TreeStruct struct {
+	FileType string
+	Charset  string `json:",omitempty"`
+	Tree     *archive.TreeNode
+}
+
+// DiffStruct is the report returned by /diff.
+type DiffStruct struct {
+	FileType      string
+	OtherFileType string
+	archive.DiffResult
+}
+
+// TestStruct is the report returned by /test.
+type TestStruct struct {
+	FileType string
+	archive.TestResult
+}
+
+// UpstreamStruct describes the archive as served by the upstream.
+type UpstreamStruct struct {
+	// Size is the size of the archive, -1 if unknown
+	Size         int64
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+	ContentType  string `json:",omitempty"`
+	// AcceptRanges tells whether the upstream serves byte ranges, which the
+	// proxy requires to read the archive
+	AcceptRanges bool
+}
+
+// InfoStruct is the summary returned by /info, the archive is only
+// described if the upstream accepts ranges.
+type InfoStruct struct {
+	FileType string
+	Upstream UpstreamStruct
+	*archive.ArchiveInfo
+	Document *archive.DocumentInfo `json:",omitempty"`
+	// DocumentError is why the metadata of the document could not be read,
+	// the archive is still described
+	DocumentError string `json:",omitempty"`
+}
+
+// MissingStruct is the body of a strict /pack naming the requested entries
+// that are not in the archive.
+type MissingStruct struct {
+	Error   string
+	Missing []string
+}
+
+// openAPI is the OpenAPI 3 document of the HTTP API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
 type Proxy struct {
 	// client used to fetch remote URLs
 	Client *http.Client
@@ -66,6 +260,61 @@ type Proxy struct {
 	// PassRequestHeaders identifies HTTP headers to pass from inbound
 	// requests to the proxied server.
 	PassRequestHeaders []string
+
+	// MaxArchiveSize is the size in bytes of the largest archive that is
+	// read. Zero means no limit.
+	MaxArchiveSize int64
+
+	// Limiter bounds the number of archive requests served at once, the
+	// others are answered with 503. Nil means no limit.
+	Limiter *Limiter
+
+	// AuthTokens and AuthUsers, when given, require requests to the archive
+	// proxy to carry one of the bearer tokens or the basic credentials of
+	// one of the users, a map of user names to passwords.
+	AuthTokens []string
+	AuthUsers  map[string]string
+
+	// Credentials are added to the remote requests of the hosts they match.
+	Credentials []Credential
+}
+
+// Limiter bounds the number of archive requests served at once. It can be
+// shared by several Proxy, like the ones built on every reload of a config,
+// so that the requests still served by one count against the limit of the
+// others.
+type Limiter struct {
+	mu     sync.Mutex
+	max    int
+	served int
+}
+
+// NewLimiter returns a Limiter serving max requests at once, zero means no
+// limit.
+func NewLimiter(max int) *Limiter {
+	return &Limiter{max: max}
+}
+
+// SetMax changes the number of requests served at once. The requests being
+// served keep their slot, new ones are refused until fewer than max are
+// served.
+func (l *Limiter) SetMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+}
+
+// Credential authenticates the requests to remote hosts.
+type Credential struct {
+	// Hosts are matched like AllowHosts
+	Hosts []string
+	// Token is sent as bearer token
+	Token string
+	// Username and Password are sent as basic credentials
+	Username string
+	Password string
+	// Headers are sent as they are
+	Headers map[string]string
 }
 
 func NewProxy(client *http.Client) *Proxy {
@@ -74,97 +323,141 @@ func NewProxy(client *http.Client) *Proxy {
 	return proxy
 }
 
+// ServeHTTP serves the API like archive-server, without the web UI.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		p.ServeHealthCheck(w, r)
+	case "/openapi.json":
+		p.ServeOpenAPI(w, r)
+	default:
+		p.ServeArchive(w, r)
+	}
+}
+
 func (p *Proxy) ServeHealthCheck(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprint(w, "OK")
 }
 
+func (p *Proxy) ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
 func (p *Proxy) ServeArchive(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		if len(p.AuthUsers) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="archive-proxy"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, errUnauthorized)
+		return
+	}
+	if !p.Limiter.acquire() {
+		writeError(w, errTooManyRequests)
+		return
+	}
+	defer p.Limiter.release()
 	err := p.allowed(r)
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "fail to proxy,err:%s", err)
+		writeError(w, fmt.Errorf("fail to proxy,err:%s", err))
 		return
 	}
 	targetUrl := r.URL.Query().Get(targetUrl)
 	fileFormat := r.URL.Query().Get(fileFormat)
 	charset := r.URL.Query().Get(charset)
 	index := r.URL.Query().Get(fileIndex)
-	if targetUrl == "" {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "url must not empty!")
+	id := r.URL.Query().Get(fileID)
+	skip := r.URL.Query().Get(offset)
+	output := r.URL.Query().Get(outputFormat)
+	level := r.URL.Query().Get(compressionLevel)
+	if strings.HasPrefix(r.URL.Path, "/info") {
+		// the upstream is described even if the archive cannot be read
+		p.serveInfo(w, r, targetUrl, fileFormat, charset)
 		return
 	}
-	reader, err := archive.UrlToReader(targetUrl, p.Client)
+	reader, fileFormat, err := p.open(r, targetUrl, fileFormat)
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprintf(w, "fail to crete reader from given url,err:%s", err)
+		writeRes(w, empty, err)
 		return
 	}
 	defer reader.Close()
-	if p.IncludeReferer {
-		// pass along the referer header from the original request
-		copyHeader(reader.Header, r.Header, "referer")
-	}
-	if len(p.PassRequestHeaders) != 0 {
-		copyHeader(reader.Header, r.Header, p.PassRequestHeaders...)
-	}
-	if fileFormat == "" {
-		mimeType, err := archive.DetectMimeTypeThenSeek(reader)
-		if err != nil {
-			w.WriteHeader(500)
-			fmt.Fprintf(w, "fail to detect file type,err:%s", err)
-			return
-		}
-		fileFormat = archive.MineTypeTransform(mimeType)
-	}
 
-	if strings.HasPrefix(r.URL.Path, "/list") {
+	if strings.HasPrefix(r.URL.Path, "/list") && r.URL.Query().Get(listView) == treeView {
+		p.serveTree(w, r, reader, fileFormat, charset)
+	} else if strings.HasPrefix(r.URL.Path, "/list") && isListing(r.URL.Query()) {
+		p.serveListing(w, r, reader, fileFormat, charset)
+	} else if strings.HasPrefix(r.URL.Path, "/list") {
 		//list archive
 		var res ArchiveStruct
 		res.FileType = fileFormat
-		switch fileFormat {
-		case archive.ZIP_TYPE:
-			files, err := archive.ListZipFiles(reader, charset)
-			res.Files = files
-			writeRes(w, res, err)
-		case archive.TAR_TYPE:
-			files, err := archive.ListTarFiles(reader, charset)
-			res.Files = files
-			writeRes(w, res, err)
-		case archive.RAR_TYPE:
-			files, err := archive.ListRarFiles(reader)
-			res.Files = files
-			writeRes(w, res, err)
-		case archive.SEVEN_Z_TYPE:
-			files, err := archive.List7zFiles(reader)
-			res.Files = files
-			writeRes(w, res, err)
-		default:
+		if !isOneOf(fileFormat, archiveFormats) {
 			writeRes(w, res, errors.New("do not support "+res.FileType))
+			return
+		}
+		entries, detected, err := archive.ListEntriesCharset(fileFormat, reader, charset)
+		res.Files = make([]string, 0, len(entries))
+		for _, entry := range entries {
+			res.Files = append(res.Files, entry.Name)
 		}
+		res.Charset = detected
+		writeRes(w, res, err)
 
 	} else if strings.HasPrefix(r.URL.Path, "/pack") {
 		if r.Method != "POST" {
-			writeRes(w, empty, errors.New("mehod not allowed"))
+			w.Header().Set("Allow", http.MethodPost)
+			writeRes(w, empty, errMethodNotAllowed)
 		} else {
-			var names []string
-			err := json.NewDecoder(r.Body).Decode(&names)
-			if err != nil {
-				writeRes(w, empty, errors.New("mehod not allowed"))
-				return
-			}
-			switch fileFormat {
-			case archive.ZIP_TYPE:
-				archive.ZipToZip(w, reader, names, charset)
-			case archive.TAR_TYPE:
-				archive.TarToZip(w, reader, names)
-			case archive.SEVEN_Z_TYPE:
-				archive.SevenZToZip(w, reader, names)
-			case archive.RAR_TYPE:
-				archive.RarToZip(w, reader, names)
-			default:
-				writeRes(w, empty, errors.New("only support zip,tar,7z and rar"))
-			}
+			p.servePack(w, r, reader, fileFormat, charset, output, level)
+		}
+	} else if strings.HasPrefix(r.URL.Path, "/diff") {
+		p.serveDiff(w, r, &archive.ArchiveSource{Format: fileFormat, Reader: reader, Charset: charset})
+	} else if strings.HasPrefix(r.URL.Path, "/test") {
+		if !isOneOf(fileFormat, archiveFormats) {
+			writeRes(w, empty, errUnsupportedFormat)
+			return
+		}
+		res, err := archive.Test(fileFormat, reader, charset)
+		if err != nil {
+			writeRes(w, empty, err)
+			return
+		}
+		writeJSON(w, TestStruct{FileType: fileFormat, TestResult: *res})
+	} else if strings.HasPrefix(r.URL.Path, "/hash") {
+		p.serveHash(w, r, reader, fileFormat, charset)
+	} else if strings.HasPrefix(r.URL.Path, "/search") {
+		p.serveSearch(w, r, reader, fileFormat, charset)
+	} else if strings.HasPrefix(r.URL.Path, "/preview") {
+		p.servePreview(w, r, reader, fileFormat, charset)
+	} else if strings.HasPrefix(r.URL.Path, "/convert") {
+		if output == "" {
+			output = archive.ZIP_TYPE
+		}
+		compressionLevel, err := parseLevel(level)
+		if err != nil {
+			writeRes(w, empty, err)
+			return
+		}
+		if !isOneOf(output, archive.ListSupportedOutputFormat()) {
+			writeRes(w, empty, paramError{errors.New("do not support output format " + output)})
+			return
+		}
+		if !isOneOf(fileFormat, archiveFormats) {
+			writeRes(w, empty, errUnsupportedFormat)
+			return
+		}
+		if err := archive.CheckOutput(fileFormat, reader, output); err != nil {
+			writeRes(w, empty, err)
+			return
+		}
+		w.Header().Set("Content-Type", archive.OutputContentType(output))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": convertedName(reader.URL.Path, output),
+		}))
+		err = archive.Convert(fileFormat, w, reader, charset, output, compressionLevel)
+		if err != nil {
+			p.logf("fail to convert %s,err:%s", targetUrl, err)
 		}
 	} else if strings.HasPrefix(r.URL.Path, "/stream") {
 		//return stream
@@ -173,79 +466,195 @@ func (p *Proxy) ServeArchive(w http.ResponseWriter, r *http.Request) {
 		fileName := strings.TrimPrefix(r.URL.Path, "/stream/")
 
 		// file name is empty
-		if fileName == r.URL.Path {
+		if fileName == r.URL.Path && id == "" {
 			value, err := strconv.Atoi(index)
 			if err != nil {
-				writeRes(w, empty, err)
+				writeRes(w, empty, paramError{fmt.Errorf("invalid index,err:%s", err)})
 				return
 			}
 			fileIndex = value
 			isUseFileName = false
-		} else {
+		} else if fileName != r.URL.Path {
 			isUseFileName = true
 		}
+		var entry io.Reader
 		switch fileFormat {
-		case archive.ZIP_TYPE:
-			if isUseFileName {
-				r, err := archive.UnzipByFileName(reader, fileName, charset)
-				writeStream(w, r, err)
+		case archive.GZIP_TYPE:
+			entry, err = gzip.NewReader(reader)
+		case archive.XZ_TYPE:
+			entry, err = xz.NewReader(reader)
+		case archive.BZIP2_TYPE:
+			entry = bzip2.NewReader(reader)
+		default:
+			if isUseFileName && strings.HasSuffix(fileName, "/") {
+				err = archive.ErrIsDir
+			} else if isUseFileName {
+				entry, err = archive.OpenByName(fileFormat, reader, fileName, charset)
+			} else if id != "" {
+				entry, err = archive.OpenByID(fileFormat, reader, id)
 			} else {
-				r, err := archive.UnzipByFileIndex(reader, fileIndex)
-				writeStream(w, r, err)
+				entry, err = archive.OpenByIndex(fileFormat, reader, fileIndex)
 			}
-		case archive.TAR_TYPE:
-			if isUseFileName {
-				r, err := archive.UnTarByFileName(reader, fileName, charset)
-				writeStream(w, r, err)
-			} else {
-				r, err := archive.UnTarByFileIndex(reader, fileIndex)
-				writeStream(w, r, err)
+		}
+		if isUseFileName && err == archive.ErrFileNotFound && isOneOf(fileFormat, archiveFormats) {
+			// a directory without an entry of its own, named without the
+			// trailing "/"
+			err = archive.ErrIsDir
+		}
+		if err == archive.ErrIsDir {
+			// stream the subtree as an archive
+			dir := strings.TrimSuffix(fileName, "/") + "/"
+			err = nil
+			if !isUseFileName {
+				dir, err = dirName(fileFormat, reader, charset, id, fileIndex)
 			}
-		case archive.RAR_TYPE:
-			if isUseFileName {
-				r, err := archive.UnRarByFileName(reader, fileName)
-				writeStream(w, r, err)
-			} else {
-				r, err := archive.UnRarByFileIndex(reader, fileIndex)
-				writeStream(w, r, err)
+			if err == nil {
+				p.serveDir(w, r, reader, fileFormat, charset, dir, output, level)
+				return
 			}
-		case archive.SEVEN_Z_TYPE:
-			if isUseFileName {
-				r, err := archive.Un7zByFileName(reader, fileName)
-				writeStream(w, r, err)
-			} else {
-				r, err := archive.Un7zByFileIndex(reader, fileIndex)
-				writeStream(w, r, err)
+		}
+		// skip the bytes a client already has to resume a download
+		if err == nil && skip != "" {
+			var n int64
+			n, err = strconv.ParseInt(skip, 10, 64)
+			if err == nil {
+				_, err = io.CopyN(io.Discard, entry, n)
 			}
-		case archive.GZIP_TYPE:
-			r, err := gzip.NewReader(reader)
-			writeStream(w, r, err)
-		case archive.XZ_TYPE:
-			r, err := xz.NewReader(reader)
-			writeStream(w, r, err)
-		case archive.BZIP2_TYPE:
-			r := bzip2.NewReader(reader)
-			writeStream(w, r, nil)
 		}
+		writeStream(w, entry, err)
 
 	} else {
 		w.WriteHeader(404)
 	}
 }
 
+// serveInfo describes the upstream of the archive at targetUrl and, if it
+// accepts ranges, the archive from its headers.
+func (p *Proxy) serveInfo(w http.ResponseWriter, r *http.Request, targetUrl, fileFormat, charset string) {
+	if targetUrl == "" {
+		writeRes(w, empty, paramError{errors.New("url must not empty!")})
+		return
+	}
+	upstream, err := p.probe(r, targetUrl)
+	if err != nil {
+		writeRes(w, empty, fmt.Errorf("fail to request given url,err:%s", err))
+		return
+	}
+	res := InfoStruct{Upstream: *upstream}
+	if !upstream.AcceptRanges {
+		writeJSON(w, res)
+		return
+	}
+	reader, fileFormat, err := p.open(r, targetUrl, fileFormat)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	defer reader.Close()
+	res.FileType = fileFormat
+	res.ArchiveInfo, err = archive.Info(fileFormat, reader, charset)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if fileFormat == archive.ZIP_TYPE && !res.MultiVolume && isTrue(r.URL.Query().Get(infoDocument)) {
+		res.Document, err = archive.Document(reader)
+		if err != nil {
+			res.DocumentError = err.Error()
+		}
+	}
+	writeJSON(w, res)
+}
+
+// probe requests the first byte of targetUrl to learn whether the upstream
+// accepts ranges, with the headers passed along by open.
+func (p *Proxy) probe(r *http.Request, targetUrl string) (*UpstreamStruct, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, targetUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.IncludeReferer {
+		copyHeader(req.Header, r.Header, "referer")
+	}
+	if len(p.PassRequestHeaders) != 0 {
+		copyHeader(req.Header, r.Header, p.PassRequestHeaders...)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := p.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response (status %d)", resp.StatusCode)
+	}
+	upstream := &UpstreamStruct{
+		Size:         resp.ContentLength,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		upstream.AcceptRanges = true
+		upstream.Size = -1
+		contentRange := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				upstream.Size = size
+			}
+		}
+	}
+	return upstream, nil
+}
+
+// open returns a reader of the archive at targetUrl and its format, which
+// is detected if fileFormat is empty.
+func (p *Proxy) open(r *http.Request, targetUrl string, fileFormat string) (*httpreader.Reader, string, error) {
+	if targetUrl == "" {
+		return nil, "", paramError{errors.New("url must not empty!")}
+	}
+	reader, err := archive.UrlToReader(targetUrl, p.httpClient())
+	if err != nil {
+		return nil, "", fmt.Errorf("fail to crete reader from given url,err:%s", err)
+	}
+	if p.MaxArchiveSize > 0 && reader.Length > p.MaxArchiveSize {
+		reader.Close()
+		return nil, "", fmt.Errorf("%w,size:%d,limit:%d", errTooLarge, reader.Length, p.MaxArchiveSize)
+	}
+	if p.IncludeReferer {
+		// pass along the referer header from the original request
+		copyHeader(reader.Header, r.Header, "referer")
+	}
+	if len(p.PassRequestHeaders) != 0 {
+		copyHeader(reader.Header, r.Header, p.PassRequestHeaders...)
+	}
+	if fileFormat == "" {
+		fileFormat, err = archive.DetectFormat(reader)
+		if err != nil {
+			reader.Close()
+			return nil, "", fmt.Errorf("fail to detect file type,err:%s", err)
+		}
+	}
+	return reader, fileFormat, nil
+}
+
 // allowed determines whether the specified request contains an allowed
 // referrer and host.  It returns an error if the request is not
 // allowed.
 func (p *Proxy) allowed(requst *http.Request) error {
-	targetUrl := requst.URL.Query().Get(targetUrl)
+	return p.allowedURL(requst, requst.URL.Query().Get(targetUrl))
+}
+
+// allowedURL is allowed for the archive URL targetUrl of requst.
+func (p *Proxy) allowedURL(requst *http.Request, targetUrl string) error {
 	u, err := url.Parse(targetUrl)
 	if err != nil {
-		return errors.New("invalid target url:" + targetUrl)
+		return paramError{errors.New("invalid target url:" + targetUrl)}
 	}
 	if len(p.AllowHosts) > 0 && !hostMatches(p.AllowHosts, u) {
 		return errNotAllowed
 	}
-	if len(p.DenyHosts) > 0 && hostMatches(p.AllowHosts, u) {
+	if len(p.DenyHosts) > 0 && hostMatches(p.DenyHosts, u) {
 		return errDeniedHost
 	}
 	if len(p.Referrers) > 0 && !referrerMatches(p.Referrers, requst) {
@@ -254,30 +663,858 @@ func (p *Proxy) allowed(requst *http.Request) error {
 	return nil
 }
 
+// authorized reports whether r carries one of the bearer tokens or basic
+// credentials of the proxy, if any is configured.
+func (p *Proxy) authorized(r *http.Request) bool {
+	if len(p.AuthTokens) == 0 && len(p.AuthUsers) == 0 {
+		return true
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		expected, found := p.AuthUsers[user]
+		return found && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimSpace(auth[7:]))
+	for _, expected := range p.AuthTokens {
+		if subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire takes one of the slots of l, it reports false if none is free.
+func (l *Limiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.max > 0 && l.served >= l.max {
+		return false
+	}
+	l.served++
+	return true
+}
+
+func (l *Limiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.served--
+}
+
+// httpClient returns the client of the remote requests, adding the
+// Credentials of their hosts.
+func (p *Proxy) httpClient() *http.Client {
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(p.Credentials) == 0 {
+		return client
+	}
+	withCredentials := *client
+	withCredentials.Transport = &credentialTransport{base: client.Transport, credentials: p.Credentials}
+	return &withCredentials
+}
+
+// credentialTransport adds the first matching Credential to each request,
+// so a redirect to another host does not receive it.
+type credentialTransport struct {
+	base        http.RoundTripper
+	credentials []Credential
+}
+
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for _, credential := range t.credentials {
+		if !hostMatches(credential.Hosts, req.URL) {
+			continue
+		}
+		req = req.Clone(req.Context())
+		if credential.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+credential.Token)
+		} else if credential.Username != "" {
+			req.SetBasicAuth(credential.Username, credential.Password)
+		}
+		for name, value := range credential.Headers {
+			req.Header.Set(name, value)
+		}
+		break
+	}
+	return base.RoundTrip(req)
+}
+
 func writeRes(w http.ResponseWriter, res ArchiveStruct, err error) {
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprint(w, err.Error())
+		writeError(w, err)
 		return
 	}
+	writeJSON(w, res)
+}
+
+func writeJSON(w http.ResponseWriter, res interface{}) {
 	jsonBytes, err := json.MarshalIndent(res, "", "\t")
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprint(w, err.Error())
+		writeError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonBytes)
 }
 
 func writeStream(w http.ResponseWriter, r io.Reader, err error) {
 	if err != nil {
-		w.WriteHeader(500)
-		fmt.Fprint(w, err.Error())
+		writeError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/octet-stream")
 	io.Copy(w, r)
 }
 
+// writeError writes the message of err as plain text with its status.
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(statusOf(err))
+	fmt.Fprint(w, err.Error())
+}
+
+func (p *Proxy) logf(format string, v ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// parseLevel parses the compression level parameter, an empty level means
+// the default compression of the output format.
+func parseLevel(level string) (int, error) {
+	if level == "" {
+		return archive.DefaultCompression, nil
+	}
+	n, err := strconv.Atoi(level)
+	if err != nil || n < archive.NoCompression || n > archive.BestCompression {
+		return 0, paramError{errors.New("level must be between 0 and 9")}
+	}
+	return n, nil
+}
+
+// servePack streams the entries selected by the body of r as an archive.
+// The outcome, known only after the body is sent, is reported in trailers.
+func (p *Proxy) servePack(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset, output, level string) {
+	var body json.RawMessage
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeRes(w, empty, paramError{fmt.Errorf("invalid selection,err:%s", err)})
+		return
+	}
+	sel, err := parseSelection(body)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if output == "" {
+		output = archive.ZIP_TYPE
+	}
+	if !isOneOf(output, archive.ListSupportedOutputFormat()) {
+		writeRes(w, empty, paramError{errors.New("do not support output format " + output)})
+		return
+	}
+	compressionLevel, err := parseLevel(level)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errUnsupportedFormat)
+		return
+	}
+	if err := archive.CheckOutput(fileFormat, reader, output); err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if isTrue(r.URL.Query().Get(strictPack)) {
+		// check the names before anything is sent
+		entries, err := archive.ListEntries(fileFormat, reader, charset)
+		if err == nil {
+			_, err = reader.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			writeRes(w, empty, err)
+			return
+		}
+		if missing := sel.Missing(entries); len(missing) != 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(MissingStruct{Error: archive.ErrFileNotFound.Error(), Missing: missing})
+			return
+		}
+	}
+	manifest := r.URL.Query().Get(manifestName)
+	if isTrue(manifest) {
+		manifest = defaultManifest
+	}
+	p.writePack(w, r, reader, fileFormat, charset, sel, output, compressionLevel, manifest)
+}
+
+// writePack streams the entries chosen by sel as an archive in the output
+// format and reports the outcome in the X-Pack trailers.
+func (p *Proxy) writePack(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string, sel *archive.Selector, output string, compressionLevel int, manifest string) {
+	w.Header().Set("Content-Type", archive.OutputContentType(output))
+	w.Header().Set("Trailer", strings.Join([]string{packStatusTrailer, packEntriesTrailer, packMissingTrailer, packErrorTrailer}, ", "))
+	res, err := archive.Pack(fileFormat, w, reader, sel, charset, output, compressionLevel, manifest)
+	if res == nil {
+		res = &archive.PackResult{}
+	}
+	status := "complete"
+	if err != nil {
+		status = "failed"
+		p.logf("fail to pack %s,err:%s", r.URL.Query().Get(targetUrl), err)
+		// header values must not contain line breaks
+		w.Header().Set(packErrorTrailer, strings.Join(strings.Fields(err.Error()), " "))
+	} else if len(res.Missing) != 0 {
+		status = "incomplete"
+	}
+	w.Header().Set(packStatusTrailer, status)
+	w.Header().Set(packEntriesTrailer, strconv.Itoa(res.Packed))
+	w.Header().Set(packMissingTrailer, strconv.Itoa(len(res.Missing)))
+}
+
+// serveDir streams the entries under the directory dir as an archive in the
+// output format, named after the directory and holding the directory itself.
+// Directories without an entry of their own are found by their prefix.
+func (p *Proxy) serveDir(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset, dir, output, level string) {
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errUnsupportedFormat)
+		return
+	}
+	if r.URL.Query().Get(offset) != "" {
+		writeRes(w, empty, paramError{errors.New("offset is not supported for directories")})
+		return
+	}
+	if output == "" {
+		output = archive.ZIP_TYPE
+	}
+	if !isOneOf(output, archive.ListSupportedOutputFormat()) {
+		writeRes(w, empty, paramError{errors.New("do not support output format " + output)})
+		return
+	}
+	compressionLevel, err := parseLevel(level)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if err := archive.CheckOutput(fileFormat, reader, output); err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	// check that the directory exists before anything is sent
+	_, err = reader.Seek(0, io.SeekStart)
+	var entries []archive.Entry
+	if err == nil {
+		entries, err = archive.ListEntries(fileFormat, reader, charset)
+	}
+	if err == nil {
+		_, err = reader.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	found := false
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name, dir) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeRes(w, empty, archive.ErrFileNotFound)
+		return
+	}
+	name := path.Base(strings.TrimSuffix(dir, "/"))
+	parent := strings.TrimSuffix(dir, name+"/")
+	sel, err := (&archive.Selection{Prefixes: []string{dir}, StripPrefix: parent}).Compile()
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name + "." + output,
+	}))
+	p.writePack(w, r, reader, fileFormat, charset, sel, output, compressionLevel, "")
+}
+
+// dirName returns the name of the directory entry selected by id or index,
+// which was found to be a directory.
+func dirName(fileFormat string, reader *httpreader.Reader, charset string, id string, index int) (string, error) {
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	entries, err := archive.ListEntries(fileFormat, reader, charset)
+	if err != nil {
+		return "", err
+	}
+	for i, entry := range entries {
+		if id != "" && entry.ID == id || id == "" && i == index {
+			return entry.Name, nil
+		}
+	}
+	return "", archive.ErrFileNotFound
+}
+
+// servePreview previews the entry selected by its name in the path or by
+// its id.
+func (p *Proxy) servePreview(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string) {
+	query := r.URL.Query()
+	var selection archive.Selection
+	if name := strings.TrimPrefix(r.URL.Path, "/preview/"); name != r.URL.Path {
+		selection.Names = []string{name}
+	} else if id := query.Get(fileID); id != "" {
+		selection.IDs = []string{id}
+	} else {
+		writeRes(w, empty, paramError{errors.New("entry name or id must not empty")})
+		return
+	}
+	sel, err := selection.Compile()
+	if err != nil {
+		writeRes(w, empty, paramError{err})
+		return
+	}
+	opts := &archive.PreviewOptions{}
+	for _, param := range []struct {
+		name  string
+		max   int64
+		value *int64
+	}{
+		{maxBytes, maxPreviewBytes, &opts.MaxTextBytes},
+		{previewThumbnail, maxPreviewThumbnail, nil},
+	} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || n > param.max {
+			writeRes(w, empty, paramError{fmt.Errorf("%s must be between 1 and %d", param.name, param.max)})
+			return
+		}
+		if param.value != nil {
+			*param.value = n
+		} else {
+			opts.ThumbnailSize = int(n)
+		}
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errUnsupportedFormat)
+		return
+	}
+	preview, err := archive.Preview(fileFormat, reader, charset, sel, opts)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	writeJSON(w, PreviewStruct{FileType: fileFormat, EntryPreview: preview})
+}
+
+// serveSearch streams the lines of the archive entries matching a pattern
+// as JSON lines. The totals are reported in trailers.
+func (p *Proxy) serveSearch(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string) {
+	query := r.URL.Query()
+	opts, err := parseSearchOptions(query)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	sel, err := (&archive.Selection{Include: query[includeGlob], Exclude: query[excludeGlob]}).Compile()
+	if err != nil {
+		writeRes(w, empty, paramError{err})
+		return
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errUnsupportedFormat)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", strings.Join([]string{searchMatchesTrailer, searchEntriesTrailer, searchBytesTrailer, searchTruncatedTrailer, searchErrorTrailer}, ", "))
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	res, err := archive.Search(fileFormat, reader, charset, sel, opts, func(match *archive.Match) error {
+		if err := r.Context().Err(); err != nil {
+			// the client is gone
+			return err
+		}
+		if err := encoder.Encode(match); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		p.logf("fail to search %s,err:%s", query.Get(targetUrl), err)
+		w.Header().Set(searchErrorTrailer, strings.Join(strings.Fields(err.Error()), " "))
+	}
+	w.Header().Set(searchMatchesTrailer, strconv.Itoa(res.Matches))
+	w.Header().Set(searchEntriesTrailer, strconv.Itoa(res.Entries))
+	w.Header().Set(searchBytesTrailer, strconv.FormatInt(res.Bytes, 10))
+	w.Header().Set(searchTruncatedTrailer, strconv.FormatBool(res.Truncated))
+}
+
+// serveHash computes the digests of the entries selected by the include and
+// exclude parameters, or by the body of a POST like /pack.
+func (p *Proxy) serveHash(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string) {
+	query := r.URL.Query()
+	var sel *archive.Selector
+	var err error
+	if r.Method == "POST" {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeRes(w, empty, paramError{fmt.Errorf("invalid selection,err:%s", err)})
+			return
+		}
+		sel, err = parseSelection(body)
+	} else {
+		sel, err = (&archive.Selection{IDs: query[fileID], Include: query[includeGlob], Exclude: query[excludeGlob]}).Compile()
+		if err != nil {
+			err = paramError{err}
+		}
+	}
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	algorithms := splitParam(query[hashAlgorithm])
+	if len(algorithms) == 0 {
+		algorithms = []string{archive.SHA256}
+	}
+	for _, algorithm := range algorithms {
+		if !isOneOf(algorithm, archive.ListSupportedHash()) {
+			writeRes(w, empty, paramError{errors.New("do not support hash " + algorithm)})
+			return
+		}
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errUnsupportedFormat)
+		return
+	}
+	entries, err := archive.Hash(fileFormat, reader, charset, sel, algorithms)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	res := HashStruct{FileType: fileFormat, Algorithms: algorithms, Entries: entries}
+	for _, entry := range entries {
+		if entry.Verified != nil && !*entry.Verified {
+			res.Failed++
+		}
+	}
+	writeJSON(w, res)
+}
+
+// serveDiff compares base with the archive given by the other parameter.
+func (p *Proxy) serveDiff(w http.ResponseWriter, r *http.Request, base *archive.ArchiveSource) {
+	query := r.URL.Query()
+	otherUrl := query.Get(otherArchive)
+	if otherUrl == "" {
+		writeRes(w, empty, paramError{errors.New("other must not empty!")})
+		return
+	}
+	if err := p.allowedURL(r, otherUrl); err != nil {
+		writeRes(w, empty, fmt.Errorf("fail to proxy,err:%s", err))
+		return
+	}
+	otherReader, otherFormat, err := p.open(r, otherUrl, query.Get(otherFormat))
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	defer otherReader.Close()
+	other := &archive.ArchiveSource{Format: otherFormat, Reader: otherReader, Charset: query.Get(otherCharset)}
+	if other.Charset == "" {
+		other.Charset = base.Charset
+	}
+	for _, format := range []string{base.Format, other.Format} {
+		if !isOneOf(format, archiveFormats) {
+			writeRes(w, empty, errUnsupportedFormat)
+			return
+		}
+	}
+	opts := &archive.DiffOptions{
+		Content: isTrue(query.Get(compareContent)),
+		Unified: isTrue(query.Get(unifiedDiff)),
+	}
+	if v := query.Get(maxDiffSize); v != "" {
+		opts.MaxDiffSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || opts.MaxDiffSize < 0 {
+			writeRes(w, empty, paramError{errors.New(maxDiffSize + " must be a positive integer")})
+			return
+		}
+	}
+	res, err := archive.Diff(base, other, opts)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	writeJSON(w, DiffStruct{FileType: base.Format, OtherFileType: other.Format, DiffResult: *res})
+}
+
+// listParams are the parameters of a directory scoped or paginated /list.
+var listParams = []string{listPrefix, listDepth, listSort, listOrder, offset, listLimit, listCursor, listNDJSON}
+
+// isListing reports whether query asks for more than the plain list of
+// names of /list.
+func isListing(query url.Values) bool {
+	for _, param := range listParams {
+		if _, ok := query[param]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// serveListing lists one directory level or one page of the archive with
+// the metadata of the entries, as JSON or as JSON lines.
+func (p *Proxy) serveListing(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string) {
+	query := r.URL.Query()
+	opts, err := parseListOptions(query)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errors.New("do not support "+fileFormat))
+		return
+	}
+	if isTrue(query.Get(listNDJSON)) && opts.Sort == "" {
+		p.streamListing(w, r, reader, fileFormat, charset, opts)
+		return
+	}
+	entries, detected, err := archive.ListEntriesCharset(fileFormat, reader, charset)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	page, total := archive.ListPage(entries, opts)
+	res := ArchiveStruct{FileType: fileFormat, Files: make([]string, 0, len(page)), Charset: detected, Entries: page, Total: total}
+	for _, entry := range page {
+		res.Files = append(res.Files, entry.Name)
+	}
+	if next := opts.Offset + len(page); opts.Limit > 0 && next < total {
+		res.NextCursor = encodeCursor(next)
+	}
+	if !isTrue(query.Get(listNDJSON)) {
+		writeJSON(w, res)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(listTotalHeader, strconv.Itoa(total))
+	w.Header().Set(listCharsetHeader, detected)
+	if res.NextCursor != "" {
+		w.Header().Set(listCursorHeader, res.NextCursor)
+	}
+	encoder := json.NewEncoder(w)
+	for i := range page {
+		if err := encoder.Encode(&page[i]); err != nil {
+			return
+		}
+	}
+}
+
+// streamListing writes the entries as JSON lines while the archive is read,
+// in the order of the archive. The walk stops after the page, so the total
+// is only known, and sent as trailer, on the last page.
+func (p *Proxy) streamListing(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string, opts *archive.ListOptions) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Trailer", strings.Join([]string{listTotalHeader, listCursorHeader, listCharsetHeader}, ", "))
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	filter := archive.NewListFilter(opts)
+	listed, more := 0, false
+	detected, err := archive.WalkCharset(fileFormat, reader, charset, func(entry *archive.Entry, _ io.Reader) error {
+		for _, e := range filter.Filter(entry) {
+			if opts.Limit > 0 && listed == opts.Offset+opts.Limit {
+				more = true
+				return archive.ErrStopWalk
+			}
+			listed++
+			if listed <= opts.Offset {
+				continue
+			}
+			if err := encoder.Encode(&e); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return r.Context().Err()
+	})
+	if err != nil {
+		p.logf("fail to list %s,err:%s", r.URL.Query().Get(targetUrl), err)
+		return
+	}
+	if more {
+		w.Header().Set(listCursorHeader, encodeCursor(listed))
+	} else {
+		w.Header().Set(listTotalHeader, strconv.Itoa(listed))
+	}
+	w.Header().Set(listCharsetHeader, detected)
+}
+
+// serveTree writes the entries as a nested directory tree, or the subtree
+// of the prefix parameter, down to the depth parameter.
+func (p *Proxy) serveTree(w http.ResponseWriter, r *http.Request, reader *httpreader.Reader, fileFormat, charset string) {
+	query := r.URL.Query()
+	depth := 0
+	if v := query.Get(listDepth); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeRes(w, empty, paramError{errors.New(listDepth + " must be a positive integer")})
+			return
+		}
+		depth = n
+	}
+	if !isOneOf(fileFormat, archiveFormats) {
+		writeRes(w, empty, errors.New("do not support "+fileFormat))
+		return
+	}
+	entries, detected, err := archive.ListEntriesCharset(fileFormat, reader, charset)
+	if err != nil {
+		writeRes(w, empty, err)
+		return
+	}
+	root := archive.BuildTree(entries).Find(query.Get(listPrefix))
+	if root == nil {
+		writeRes(w, empty, archive.ErrFileNotFound)
+		return
+	}
+	if depth > 0 {
+		root.Prune(depth)
+	}
+	writeJSON(w, TreeStruct{FileType: fileFormat, Charset: detected, Tree: root})
+}
+
+// parseListOptions parses the listing parameters of /list.
+func parseListOptions(query url.Values) (*archive.ListOptions, error) {
+	opts := &archive.ListOptions{
+		Prefix: query.Get(listPrefix),
+		Sort:   query.Get(listSort),
+	}
+	switch query.Get(listOrder) {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return nil, paramError{errors.New("order must be asc or desc")}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{listDepth, &opts.Depth},
+		{offset, &opts.Offset},
+		{listLimit, &opts.Limit},
+	} {
+		if v := query.Get(param.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, paramError{errors.New(param.name + " must be a positive integer")}
+			}
+			*param.value = n
+		}
+	}
+	if cursor := query.Get(listCursor); cursor != "" {
+		n, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		opts.Offset = n
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, paramError{err}
+	}
+	return opts, nil
+}
+
+// encodeCursor returns the opaque cursor of the page starting at offset.
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), "offset:") {
+		n, err := strconv.Atoi(strings.TrimPrefix(string(b), "offset:"))
+		if err == nil && n >= 0 {
+			return n, nil
+		}
+	}
+	return 0, paramError{errors.New("invalid cursor " + cursor)}
+}
+
+// splitParam splits the comma separated values of a repeatable parameter.
+func splitParam(values []string) []string {
+	var res []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				res = append(res, v)
+			}
+		}
+	}
+	return res
+}
+
+// parseSearchOptions parses the pattern and limits of /search.
+func parseSearchOptions(query url.Values) (*archive.SearchOptions, error) {
+	pattern := query.Get(searchPattern)
+	if pattern == "" {
+		return nil, paramError{errors.New("pattern must not empty")}
+	}
+	if !isTrue(query.Get(searchRegex)) {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if isTrue(query.Get(ignoreCase)) {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, paramError{fmt.Errorf("invalid pattern,err:%s", err)}
+	}
+	opts := &archive.SearchOptions{Pattern: re, MaxMatches: defaultMaxMatches}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{maxBytes, &opts.MaxBytes},
+		{maxEntryBytes, &opts.MaxEntryBytes},
+	} {
+		if v := query.Get(param.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, paramError{errors.New(param.name + " must be a positive integer")}
+			}
+			*param.value = n
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{contextLines, &opts.Context},
+		{maxMatches, &opts.MaxMatches},
+	} {
+		if v := query.Get(param.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, paramError{errors.New(param.name + " must be a positive integer")}
+			}
+			*param.value = n
+		}
+	}
+	return opts, nil
+}
+
+// isTrue reports whether a boolean parameter is set.
+func isTrue(value string) bool {
+	b, _ := strconv.ParseBool(value)
+	return b
+}
+
+// parseSelection decodes the body of /pack, either an array of entry names or
+// an archive.Selection object.
+func parseSelection(body json.RawMessage) (*archive.Selector, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var names []string
+		if err := json.Unmarshal(body, &names); err != nil {
+			return nil, paramError{fmt.Errorf("invalid entry names,err:%s", err)}
+		}
+		return archive.NamesSelector(names), nil
+	}
+	var selection archive.Selection
+	if err := json.Unmarshal(body, &selection); err != nil {
+		return nil, paramError{fmt.Errorf("invalid selection,err:%s", err)}
+	}
+	sel, err := selection.Compile()
+	if err != nil {
+		return nil, paramError{err}
+	}
+	return sel, nil
+}
+
+// convertedName replaces the archive extension of the last element of
+// urlPath with the output format.
+func convertedName(urlPath string, output string) string {
+	name := path.Base(urlPath)
+	if name == "." || name == "/" {
+		name = "archive"
+	}
+	for _, ext := range []string{".tar.gz", ".tar.xz", ".tar.zst", ".tar.bz2", ".tgz", ".zip", ".tar", ".rar", ".7z"} {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+	return name + "." + output
+}
+
+// archiveFormats are the formats of archives whose entries can be listed
+// and read.
+var archiveFormats = []string{
+	archive.ZIP_TYPE, archive.TAR_TYPE, archive.SEVEN_Z_TYPE, archive.RAR_TYPE,
+	archive.AR_TYPE, archive.DEB_TYPE, archive.CPIO_TYPE, archive.RPM_TYPE,
+	archive.ISO_TYPE, archive.OCI_TYPE,
+}
+
+// errUnsupportedFormat is returned for archives not in archiveFormats.
+var errUnsupportedFormat = errors.New("only support " + strings.Join(archiveFormats[:len(archiveFormats)-1], ",") + " and " + archiveFormats[len(archiveFormats)-1])
+
+func isOneOf(s string, list []string) bool {
+	for _, item := range list {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// statusOf returns the HTTP status code reported for err.
+func statusOf(err error) int {
+	if errors.Is(err, archive.ErrFileNotFound) || errors.Is(err, archive.ErrOutOfBoundary) {
+		return http.StatusNotFound
+	}
+	var invalid paramError
+	if errors.Is(err, archive.ErrIsDir) || errors.Is(err, archive.ErrUnknownSize) || errors.As(err, &invalid) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, errMethodNotAllowed) {
+		return http.StatusMethodNotAllowed
+	}
+	if errors.Is(err, errUnauthorized) {
+		return http.StatusUnauthorized
+	}
+	if errors.Is(err, errTooManyRequests) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, errTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
 // hostMatches returns whether the host in u matches one of hosts.
 func hostMatches(hosts []string, u *url.URL) bool {
 	for _, host := range hosts {
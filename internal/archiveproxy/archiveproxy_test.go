@@ -0,0 +1,179 @@
+package archiveproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestInfoDocumentError(t *testing.T) {
+	files := serveZips(t, map[string]map[string]string{
+		"broken.nupkg": {"broken.nuspec": "<package><metadata>", "lib/a.dll": "a"},
+	})
+	server := httptest.NewServer(&Proxy{})
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/info?document=true&url=" + url.QueryEscape(files+"/broken.nupkg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		FileType      string
+		Entries       int
+		Document      json.RawMessage
+		DocumentError string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || res.FileType != "zip" || res.Entries != 2 || res.Document != nil || res.DocumentError == "" {
+		t.Errorf("got status %d and %+v", resp.StatusCode, res)
+	}
+}
+
+func TestStreamDirectory(t *testing.T) {
+	files := serveZips(t, map[string]map[string]string{
+		"a.zip": {"docs/a.txt": "a", "docs/sub/b.txt": "b", "c.txt": "c", "empty/": ""},
+	})
+	archiveURL := url.QueryEscape(files + "/a.zip")
+	server := httptest.NewServer(&Proxy{})
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/list?limit=0&url=" + archiveURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listing ArchiveStruct
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emptyID string
+	for _, entry := range listing.Entries {
+		if entry.Name == "empty/" {
+			emptyID = entry.ID
+		}
+	}
+
+	for _, test := range []struct {
+		query    string
+		status   int
+		filename string
+		// names are the entries of the archive streamed
+		names []string
+	}{
+		{query: "/stream/docs/", status: 200, filename: "docs.zip", names: []string{"docs/a.txt", "docs/sub/b.txt"}},
+		{query: "/stream/docs", status: 200, filename: "docs.zip", names: []string{"docs/a.txt", "docs/sub/b.txt"}},
+		{query: "/stream/docs/sub/?output=tar", status: 200, filename: "sub.tar", names: []string{"sub/b.txt"}},
+		{query: "/stream?id=" + emptyID, status: 200, filename: "empty.zip", names: []string{"empty/"}},
+		{query: "/stream/missing/", status: 404},
+		{query: "/stream/docs/?offset=1", status: 400},
+		{query: "/stream/docs/?output=rar", status: 400},
+	} {
+		sep := "?"
+		if strings.Contains(test.query, "?") {
+			sep = "&"
+		}
+		resp, err := http.Get(server.URL + test.query + sep + "url=" + archiveURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.query, resp.StatusCode, test.status, data)
+			continue
+		}
+		if test.status != 200 {
+			continue
+		}
+		_, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		if params["filename"] != test.filename || resp.Trailer.Get(packStatusTrailer) != "complete" {
+			t.Errorf("%s: got file %q and status %q", test.query, params["filename"], resp.Trailer.Get(packStatusTrailer))
+		}
+		var names []string
+		if strings.HasSuffix(test.filename, ".tar") {
+			tr := tar.NewReader(bytes.NewReader(data))
+			for {
+				header, err := tr.Next()
+				if err != nil {
+					break
+				}
+				names = append(names, header.Name)
+			}
+		} else if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			for _, file := range zr.File {
+				names = append(names, file.Name)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: got entries %q, want %q", test.query, names, test.names)
+		}
+	}
+}
+
+func TestPackStatus(t *testing.T) {
+	files := serveZips(t, map[string]map[string]string{"a.zip": {"a.txt": "a", "b.txt": "b"}})
+	archiveURL := url.QueryEscape(files + "/a.zip")
+	server := httptest.NewServer(&Proxy{})
+	defer server.Close()
+
+	for _, test := range []struct {
+		query string
+		body  string
+		// status is the X-Pack-Status trailer, or the body of a 404
+		status string
+		// names are the entries of the output
+		names []string
+	}{
+		{body: `["a.txt","b.txt"]`, status: "complete 2 0", names: []string{"a.txt", "b.txt"}},
+		{body: `["a.txt","missing.txt"]`, status: "incomplete 1 1", names: []string{"a.txt"}},
+		{query: "manifest=true", body: `["a.txt","missing.txt"]`, status: "incomplete 1 1", names: []string{"MANIFEST.json", "a.txt"}},
+		{query: "strict=true", body: `["a.txt","missing.txt"]`, status: `{"Error":"file not found in archive","Missing":["missing.txt"]}`},
+	} {
+		resp, err := http.Post(server.URL+"/pack?url="+archiveURL+"&"+test.query, "application/json", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			if got := strings.TrimSpace(string(data)); got != test.status {
+				t.Errorf("%s %s: got %s, want %s", test.query, test.body, got, test.status)
+			}
+			continue
+		}
+		status := strings.Join([]string{resp.Trailer.Get(packStatusTrailer), resp.Trailer.Get(packEntriesTrailer), resp.Trailer.Get(packMissingTrailer)}, " ")
+		if status != test.status {
+			t.Errorf("%s %s: got status %q, want %q", test.query, test.body, status, test.status)
+		}
+		var names []string
+		if zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			for _, file := range zr.File {
+				names = append(names, file.Name)
+			}
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s %s: got entries %q, want %q", test.query, test.body, names, test.names)
+		}
+	}
+}
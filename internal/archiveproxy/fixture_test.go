@@ -0,0 +1,41 @@
+package archiveproxy
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serveZips serves, from a test server, a zip of the given files under
+// every archive name and returns the URL of the server.
+func serveZips(t *testing.T, archives map[string]map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, files := range archives {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for file, content := range files {
+			w, err := zw.Create(file)
+			if err == nil {
+				_, err = io.WriteString(w, content)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(server.Close)
+	return server.URL
+}
@@ -0,0 +1,307 @@
+package archiveproxy
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// apiDoc is the part of the OpenAPI document checked by TestOpenAPI.
+type apiDoc struct {
+	Paths      map[string]map[string]apiOperation
+	Components struct {
+		Parameters map[string]apiParameter
+		Responses  map[string]apiResponse
+		Schemas    map[string]struct {
+			Required []string
+		}
+	}
+}
+
+type apiOperation struct {
+	OperationID string `json:"operationId"`
+	Parameters  []apiParameter
+	Responses   map[string]apiResponse
+}
+
+type apiParameter struct {
+	Ref      string `json:"$ref"`
+	Name     string
+	In       string
+	Required bool
+}
+
+type apiResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema struct {
+			Ref string `json:"$ref"`
+		}
+	}
+}
+
+// apiCall is a request of an operation and the status it must get. The
+// url parameter is added unless the query has one.
+type apiCall struct {
+	query  string
+	entry  string
+	body   string
+	status int
+}
+
+// ref returns the name of a component referenced by ref.
+func ref(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func TestOpenAPI(t *testing.T) {
+	var doc apiDoc
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatalf("invalid openapi.json:%s", err)
+	}
+	files := serveZips(t, map[string]map[string]string{
+		"a.zip": {"a.txt": "hello\nworld\n", "dir/b.txt": "b\n"},
+		"b.zip": {"a.txt": "hello\n", "c.txt": "c\n"},
+	})
+	archiveURL := files + "/a.zip"
+	server := httptest.NewServer(&Proxy{})
+	defer server.Close()
+
+	// the id of a.txt for the operations selecting by id
+	resp, err := http.Get(server.URL + "/list?limit=0&url=" + url.QueryEscape(archiveURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listing ArchiveStruct
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if err != nil || len(listing.Entries) == 0 {
+		t.Fatalf("fail to list the fixture,err:%v", err)
+	}
+	var id string
+	for _, entry := range listing.Entries {
+		if entry.Name == "a.txt" {
+			id = entry.ID
+		}
+	}
+
+	other := "other=" + url.QueryEscape(files+"/b.zip")
+	calls := map[string][]apiCall{
+		"list": {
+			{status: 200},
+			{query: "limit=1&sort=name", status: 200},
+			{query: "view=tree", status: 200},
+			{query: "ndjson=true", status: 200},
+			{query: "limit=x", status: 400},
+			{query: "url=", status: 400},
+		},
+		"streamByIndex": {
+			{query: "index=0", status: 200},
+			{query: "id=" + id, status: 200},
+			{query: "index=9", status: 404},
+			{query: "index=x", status: 400},
+		},
+		"streamByName": {
+			{entry: "a.txt", status: 200},
+			{entry: "dir/", status: 200},
+			{entry: "missing.txt", status: 404},
+			{entry: "dir/", query: "offset=1", status: 400},
+		},
+		"pack": {
+			{body: `["a.txt"]`, status: 200},
+			{body: `{"Include":["dir/*"]}`, query: "output=tar.gz", status: 200},
+			{body: `["missing.txt"]`, query: "strict=true", status: 404},
+			{body: `{"Include":["["]}`, status: 400},
+			{body: `["a.txt"]`, query: "output=rar", status: 400},
+		},
+		"convert": {
+			{query: "output=tar", status: 200},
+			{query: "level=10", status: 400},
+		},
+		"search": {
+			{query: "pattern=world", status: 200},
+			{status: 400},
+		},
+		"hash": {
+			{status: 200},
+			{query: "algorithm=md4", status: 400},
+		},
+		"hashSelected": {
+			{body: `{"Names":["a.txt"]}`, status: 200},
+			{body: `{"Regex":["("]}`, status: 400},
+		},
+		"diff": {
+			{query: other, status: 200},
+			{query: other + "&content=true&unified=true", status: 200},
+			{status: 400},
+		},
+		"test": {
+			{status: 200},
+			{query: "url=", status: 400},
+		},
+		"info": {
+			{status: 200},
+			{query: "document=true", status: 200},
+			{query: "url=", status: 400},
+		},
+		"previewByID": {
+			{query: "id=" + id, status: 200},
+			{query: "id=o999999", status: 404},
+			{status: 400},
+		},
+		"previewByName": {
+			{entry: "a.txt", status: 200},
+			{entry: "missing.txt", status: 404},
+			{entry: "a.txt", query: "maxBytes=0", status: 400},
+		},
+		"healthCheck": {{status: 200}},
+		"openAPI":     {{status: 200}},
+	}
+
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			method = strings.ToUpper(method)
+			opCalls, ok := calls[op.OperationID]
+			if !ok {
+				t.Errorf("%s %s: operation %q is not tested", method, path, op.OperationID)
+				continue
+			}
+			delete(calls, op.OperationID)
+			takesURL := false
+			for _, param := range op.Parameters {
+				if param.Ref != "" {
+					param = doc.Components.Parameters[ref(param.Ref)]
+				}
+				takesURL = takesURL || param.Name == targetUrl
+			}
+			for _, call := range opCalls {
+				query := call.query
+				if takesURL && !strings.Contains(query, "url=") {
+					query = strings.TrimPrefix(query+"&url="+url.QueryEscape(archiveURL), "&")
+				}
+				target := server.URL + strings.Replace(path, "{entry}", call.entry, 1) + "?" + query
+				name := method + " " + target
+				var body io.Reader
+				if call.body != "" {
+					body = strings.NewReader(call.body)
+				}
+				req, err := http.NewRequest(method, target, body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Errorf("%s: fail to read the body,err:%s", name, err)
+					continue
+				}
+				if resp.StatusCode != call.status {
+					t.Errorf("%s: got status %d, want %d: %s", name, resp.StatusCode, call.status, data)
+					continue
+				}
+				checkResponse(t, &doc, op, name, resp, data)
+			}
+		}
+	}
+	for operationID := range calls {
+		t.Errorf("operation %q is not documented", operationID)
+	}
+}
+
+// checkResponse checks that the status, the content type and the shape of
+// the body of resp are documented for op.
+func checkResponse(t *testing.T, doc *apiDoc, op apiOperation, name string, resp *http.Response, data []byte) {
+	t.Helper()
+	documented, ok := op.Responses[strconv.Itoa(resp.StatusCode)]
+	if !ok {
+		t.Errorf("%s: status %d is not documented", name, resp.StatusCode)
+		return
+	}
+	if documented.Ref != "" {
+		documented = doc.Components.Responses[ref(documented.Ref)]
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	content, ok := documented.Content[mediaType]
+	if !ok {
+		t.Errorf("%s: content type %q of status %d is not documented", name, mediaType, resp.StatusCode)
+		return
+	}
+	switch {
+	case mediaType == "text/plain" && resp.StatusCode >= 400:
+		if len(strings.TrimSpace(string(data))) == 0 {
+			t.Errorf("%s: empty error message", name)
+		}
+	case mediaType == "application/json":
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("%s: invalid JSON,err:%s", name, err)
+			return
+		}
+		if content.Schema.Ref == "" {
+			return
+		}
+		for _, field := range doc.Components.Schemas[ref(content.Schema.Ref)].Required {
+			if _, ok := body[field]; !ok {
+				t.Errorf("%s: required field %s is missing", name, field)
+			}
+		}
+	case mediaType == "application/x-ndjson":
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line != "" && !json.Valid([]byte(line)) {
+				t.Errorf("%s: invalid JSON line %q", name, line)
+			}
+		}
+	}
+}
+
+// TestOpenAPILimits checks the documented statuses of the auth and size
+// limits on every operation reading an archive.
+func TestOpenAPILimits(t *testing.T) {
+	var doc apiDoc
+	if err := json.Unmarshal(openAPI, &doc); err != nil {
+		t.Fatalf("invalid openapi.json:%s", err)
+	}
+	files := serveZips(t, map[string]map[string]string{"a.zip": {"a.txt": "hello\n"}})
+	archiveURL := url.QueryEscape(files + "/a.zip")
+	for _, limit := range []struct {
+		status int
+		proxy  *Proxy
+	}{
+		{http.StatusUnauthorized, &Proxy{AuthTokens: []string{"secret"}}},
+		{http.StatusRequestEntityTooLarge, &Proxy{MaxArchiveSize: 1}},
+	} {
+		server := httptest.NewServer(limit.proxy)
+		for path, methods := range doc.Paths {
+			for method, op := range methods {
+				if _, ok := op.Responses[strconv.Itoa(limit.status)]; !ok {
+					continue
+				}
+				target := server.URL + strings.Replace(path, "{entry}", "a.txt", 1) + "?pattern=a&id=x&index=0&other=" + archiveURL + "&url=" + archiveURL
+				req, _ := http.NewRequest(strings.ToUpper(method), target, strings.NewReader(`["a.txt"]`))
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				name := strings.ToUpper(method) + " " + path
+				if resp.StatusCode != limit.status {
+					t.Errorf("%s: got status %d, want %d: %s", name, resp.StatusCode, limit.status, data)
+					continue
+				}
+				checkResponse(t, &doc, op, name, resp, data)
+			}
+		}
+		server.Close()
+	}
+}
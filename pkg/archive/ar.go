@@ -0,0 +1,265 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+	// arMaxNameTable bounds the table of the long names of GNU ar
+	arMaxNameTable = 16 << 20
+)
+
+// arReader reads the members of an ar archive, as written by GNU and BSD
+// ar. The symbol tables of static libraries are skipped.
+type arReader struct {
+	r *countingReader
+	// remaining is the unread size of the content of the current member
+	remaining int64
+	// pad is the padding after it, members start at even offsets
+	pad int64
+	// names is the table of the long names of GNU ar
+	names []byte
+}
+
+func newArReader(r *httpreader.Reader) (*arReader, error) {
+	off, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != arMagic {
+		return nil, errors.New("not an ar archive")
+	}
+	return &arReader{r: &countingReader{r: r, n: off + int64(len(magic))}}, nil
+}
+
+func (a *arReader) next() (*Entry, error) {
+	for {
+		if err := a.r.skip(a.remaining + a.pad); err != nil {
+			return nil, err
+		}
+		a.remaining, a.pad = 0, 0
+		off := a.r.n
+		var header [arHeaderSize]byte
+		if _, err := io.ReadFull(a.r, header[:]); err != nil {
+			return nil, err
+		}
+		if string(header[58:]) != "`\n" {
+			return nil, fmt.Errorf("invalid ar header at %d", off)
+		}
+		name := strings.TrimRight(string(header[:16]), " ")
+		mtime, _ := strconv.ParseInt(strings.TrimSpace(string(header[16:28])), 10, 64)
+		uid, _ := strconv.Atoi(strings.TrimSpace(string(header[28:34])))
+		gid, _ := strconv.Atoi(strings.TrimSpace(string(header[34:40])))
+		mode, _ := strconv.ParseInt(strings.TrimSpace(string(header[40:48])), 8, 64)
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of ar member at %d", off)
+		}
+		a.remaining, a.pad = size, size%2
+		switch {
+		case name == "//":
+			// the long names of GNU ar
+			if size > arMaxNameTable {
+				return nil, errors.New("ar name table too large")
+			}
+			a.names = make([]byte, size)
+			if _, err := io.ReadFull(a.r, a.names); err != nil {
+				return nil, err
+			}
+			a.remaining = 0
+			continue
+		case name == "/" || name == "/SYM64/":
+			// the symbol table of GNU ar
+			continue
+		case strings.HasPrefix(name, "#1/"):
+			// BSD ar stores long names before the content
+			n, err := strconv.ParseInt(name[3:], 10, 64)
+			if err != nil || n < 0 || n > size {
+				return nil, fmt.Errorf("invalid name of ar member at %d", off)
+			}
+			long := make([]byte, n)
+			if _, err := io.ReadFull(a.r, long); err != nil {
+				return nil, err
+			}
+			a.remaining -= n
+			size -= n
+			name = string(bytes.TrimRight(long, "\x00"))
+		case strings.HasPrefix(name, "/") && a.names == nil:
+			// an offset in the table of the long names of GNU ar, which is
+			// unknown when a member is opened by its offset
+		case strings.HasPrefix(name, "/"):
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n < 0 || n >= len(a.names) {
+				return nil, fmt.Errorf("invalid name of ar member at %d", off)
+			}
+			long := a.names[n:]
+			if end := bytes.IndexByte(long, '\n'); end >= 0 {
+				long = long[:end]
+			}
+			name = strings.TrimSuffix(string(long), "/")
+		default:
+			// GNU ar ends names with "/" to allow spaces
+			name = strings.TrimSuffix(name, "/")
+		}
+		if strings.HasPrefix(name, "__.SYMDEF") {
+			// the symbol table of BSD ar
+			continue
+		}
+		if mode == 0 {
+			mode = 0644
+		}
+		return &Entry{
+			Name:    name,
+			Size:    size,
+			Mode:    unixMode(mode),
+			ModTime: time.Unix(mtime, 0),
+			Uid:     uid,
+			Gid:     gid,
+			ID:      entryID(offsetID, off),
+		}, nil
+	}
+}
+
+func (a *arReader) Read(p []byte) (int, error) {
+	if a.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > a.remaining {
+		p = p[:a.remaining]
+	}
+	n, err := a.r.Read(p)
+	a.remaining -= int64(n)
+	if err == io.EOF && a.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// debReader reads the files of a Debian package, those of its data.tar
+// member, preceded by those of its control.tar member under DEBIAN/ as
+// dpkg-deb lays them out to build a package. Names are given by
+// packageName. The files of an uncompressed member are identified by the
+// offsets of their headers in the package, the others by their indexes.
+type debReader struct {
+	ar *arReader
+	// tar reads the current member, nil between members
+	tar          *tar.Reader
+	decompressor io.ReadCloser
+	// prefix is prepended to the names of the current member
+	prefix string
+	// header is the offset of the next header of an uncompressed member, -1
+	// for a compressed one or once it is unknown
+	header int64
+	index  int64
+}
+
+// debControlDir holds the files of the control member of a deb.
+const debControlDir = "DEBIAN/"
+
+// debCompressions maps the extensions of the members of a deb to the
+// compressions of newDecompressor.
+var debCompressions = map[string]string{
+	"":      "",
+	".gz":   "gzip",
+	".xz":   "xz",
+	".zst":  "zstd",
+	".bz2":  "bzip2",
+	".lzma": "lzma",
+}
+
+func newDebReader(r *httpreader.Reader) (*debReader, error) {
+	ar, err := newArReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &debReader{ar: ar}, nil
+}
+
+func (d *debReader) next() (*Entry, error) {
+	for {
+		if d.tar == nil {
+			if err := d.nextMember(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		header, err := d.tar.Next()
+		if err == io.EOF {
+			d.decompressor.Close()
+			d.tar = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := entryID(indexID, d.index)
+		if d.header >= 0 {
+			id = entryID(offsetID, d.header)
+			d.header = tarHeaderAfter(header, d.ar.r.n)
+		}
+		name := packageName(header.Name)
+		if name == "" && d.prefix == "" {
+			continue
+		}
+		entry := tarEntry(header, d.prefix+name, id)
+		if header.Typeflag == tar.TypeLink {
+			// the target of a hard link is the name of another file
+			entry.Linkname = d.prefix + packageName(header.Linkname)
+		}
+		d.index++
+		return entry, nil
+	}
+}
+
+// nextMember opens the next control or data member of the ar archive.
+func (d *debReader) nextMember() error {
+	for {
+		member, err := d.ar.next()
+		if err != nil {
+			return err
+		}
+		var base string
+		switch {
+		case strings.HasPrefix(member.Name, "control.tar"):
+			base, d.prefix = "control.tar", debControlDir
+		case strings.HasPrefix(member.Name, "data.tar"):
+			base, d.prefix = "data.tar", ""
+		default:
+			// debian-binary and signatures
+			continue
+		}
+		compressor, ok := debCompressions[member.Name[len(base):]]
+		if !ok {
+			return errors.New("do not support deb member " + member.Name)
+		}
+		d.header = -1
+		if compressor == "" {
+			d.header = d.ar.r.n
+		}
+		d.decompressor, err = newDecompressor(compressor, d.ar)
+		if err != nil {
+			return fmt.Errorf("fail to decompress %s,err:%s", member.Name, err)
+		}
+		d.tar = tar.NewReader(d.decompressor)
+		return nil
+	}
+}
+
+func (d *debReader) Read(p []byte) (int, error) {
+	if d.tar == nil {
+		return 0, io.EOF
+	}
+	return d.tar.Read(p)
+}
@@ -0,0 +1,376 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+// ErrStopWalk can be returned by a WalkFunc to stop walking the archive
+// without Walk reporting an error.
+var ErrStopWalk = errors.New("stop walking the archive")
+
+// Entry describes a single item of an archive. Name is the same name
+// reported by the List functions, directories end with "/".
+type Entry struct {
+	Name    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+	IsDir   bool
+	// Uid and Gid of the owner, stored by tar, ar, cpio and the Rock Ridge
+	// extensions of iso
+	Uid int `json:",omitempty"`
+	Gid int `json:",omitempty"`
+	// Linkname is the target of a symbolic link. Formats that store the
+	// target as content of the entry leave it empty.
+	Linkname string `json:",omitempty"`
+	// CRC32 is the checksum of the content stored by zip
+	CRC32 uint32 `json:",omitempty"`
+	// ID identifies the entry independently of the charset of its name, see
+	// OpenByID. Directories synthesized by listings have none.
+	ID string `json:",omitempty"`
+}
+
+// MarshalJSON writes Mode as a Unix st_mode, the file type bits and the
+// permissions, eg. 33188 (0100644) for a regular file readable by all.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		Mode uint32
+	}{entry(e), stMode(e.Mode)})
+}
+
+// UnmarshalJSON reads the Unix st_mode written by MarshalJSON.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type entry Entry
+	v := struct {
+		*entry
+		Mode uint32
+	}{entry: (*entry)(e)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	e.Mode = unixMode(int64(v.Mode))
+	return nil
+}
+
+// WalkFunc is called by Walk for every entry of an archive in order. r reads
+// the content of the entry and is only valid until WalkFunc returns.
+type WalkFunc func(entry *Entry, r io.Reader) error
+
+// Walk calls fn for every entry of the archive in the given format.
+func Walk(format string, r *httpreader.Reader, charset string, fn WalkFunc) error {
+	_, err := WalkCharset(format, r, charset, fn)
+	return err
+}
+
+// WalkCharset is Walk also returning the charset the names of zip and tar
+// entries were decoded with. An empty charset is detected from the names,
+// see DetectCharset. The names of rar, 7z and iso are always UTF-8, those of
+// ar, deb, cpio, rpm and oci are not decoded.
+func WalkCharset(format string, r *httpreader.Reader, charset string, fn WalkFunc) (string, error) {
+	var err error
+	switch format {
+	case ZIP_TYPE:
+		charset, err = walkZip(r, charset, fn)
+	case TAR_TYPE:
+		charset, err = walkTar(r, charset, fn)
+	case RAR_TYPE:
+		charset, err = UTF8, WalkRar(r, fn)
+	case SEVEN_Z_TYPE:
+		charset, err = UTF8, Walk7z(r, fn)
+	case AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE, OCI_TYPE:
+		charset, err = UTF8, walkPackage(format, r, fn)
+	case ISO_TYPE:
+		charset, err = UTF8, walkIso(r, fn)
+	default:
+		return "", errors.New("do not support " + format)
+	}
+	if err == ErrStopWalk {
+		return charset, nil
+	}
+	return charset, err
+}
+
+// ListEntries returns the entries of the archive in the given format.
+func ListEntries(format string, r *httpreader.Reader, charset string) ([]Entry, error) {
+	entries, _, err := ListEntriesCharset(format, r, charset)
+	return entries, err
+}
+
+// ListEntriesCharset is ListEntries also returning the charset of the names
+// like WalkCharset.
+func ListEntriesCharset(format string, r *httpreader.Reader, charset string) ([]Entry, string, error) {
+	entries := make([]Entry, 0, 10)
+	charset, err := WalkCharset(format, r, charset, func(entry *Entry, _ io.Reader) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	return entries, charset, err
+}
+
+// List returns the entry names of the archive in the given format.
+func List(format string, r *httpreader.Reader, charset string) ([]string, error) {
+	switch format {
+	case ZIP_TYPE:
+		return ListZipFiles(r, charset)
+	case TAR_TYPE:
+		return ListTarFiles(r, charset)
+	case RAR_TYPE:
+		return ListRarFiles(r)
+	case SEVEN_Z_TYPE:
+		return List7zFiles(r)
+	case AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE, ISO_TYPE, OCI_TYPE:
+		entries, err := ListEntries(format, r, charset)
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name)
+		}
+		return names, err
+	}
+	return nil, errors.New("do not support " + format)
+}
+
+// OpenByName returns the content of the named entry.
+func OpenByName(format string, r *httpreader.Reader, name string, charset string) (io.Reader, error) {
+	switch format {
+	case ZIP_TYPE:
+		return UnzipByFileName(r, name, charset)
+	case TAR_TYPE:
+		return UnTarByFileName(r, name, charset)
+	case RAR_TYPE:
+		return UnRarByFileName(r, name)
+	case SEVEN_Z_TYPE:
+		return Un7zByFileName(r, name)
+	case AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE, OCI_TYPE:
+		return openPackage(format, r, func(entry *Entry, _ int) bool {
+			return entry.Name == name || entry.IsDir && entry.Name == name+"/"
+		}, ErrFileNotFound)
+	case ISO_TYPE:
+		return openIsoByName(r, name)
+	}
+	return nil, errors.New("do not support " + format)
+}
+
+// OpenByIndex returns the content of the entry at index. Indexes count every
+// entry in the order of ListEntries, directories included.
+func OpenByIndex(format string, r *httpreader.Reader, index int) (io.Reader, error) {
+	if index < 0 {
+		return nil, ErrOutOfBoundary
+	}
+	switch format {
+	case ZIP_TYPE:
+		return UnzipByFileIndex(r, index)
+	case TAR_TYPE:
+		return UnTarByFileIndex(r, index)
+	case RAR_TYPE:
+		return UnRarByFileIndex(r, index)
+	case SEVEN_Z_TYPE:
+		return Un7zByFileIndex(r, index)
+	case AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE, OCI_TYPE:
+		return openPackage(format, r, func(_ *Entry, i int) bool {
+			return i == index
+		}, ErrOutOfBoundary)
+	case ISO_TYPE:
+		return openIsoByIndex(r, index)
+	}
+	return nil, errors.New("do not support " + format)
+}
+
+// the kinds of entry IDs: the offset of the header of the entry, or its
+// index for formats and entries whose headers cannot be reached directly.
+// rar uses indexes because rardecode tells no offset of its headers, 7z
+// because go7z tells none either. The entries of deb, rpm and oci have
+// offsets in uncompressed members, payloads and layers, and indexes in a
+// compressed stream.
+const (
+	offsetID = 'o'
+	indexID  = 'i'
+)
+
+func entryID(kind byte, n int64) string {
+	return string(kind) + strconv.FormatInt(n, 10)
+}
+
+// OpenByID returns the content of the entry with the given ID, as reported
+// by Walk and ListEntries.
+func OpenByID(format string, r *httpreader.Reader, id string) (io.Reader, error) {
+	if len(id) < 2 {
+		return nil, ErrFileNotFound
+	}
+	n, err := strconv.ParseInt(id[1:], 10, 64)
+	if err != nil || n < 0 {
+		return nil, ErrFileNotFound
+	}
+	switch {
+	case id[0] == indexID:
+		reader, err := OpenByIndex(format, r, int(n))
+		if err == ErrOutOfBoundary {
+			return nil, ErrFileNotFound
+		}
+		return reader, err
+	case id[0] == offsetID && format == ZIP_TYPE:
+		return unzipByOffset(r, n)
+	case id[0] == offsetID && format == TAR_TYPE:
+		return unTarByOffset(r, n)
+	case id[0] == offsetID && (format == AR_TYPE || format == CPIO_TYPE || format == RPM_TYPE):
+		return openPackageByOffset(format, r, n)
+	case id[0] == offsetID && (format == DEB_TYPE || format == OCI_TYPE):
+		return openTarAt(r, n)
+	case id[0] == offsetID && format == ISO_TYPE:
+		return openIsoByOffset(r, n)
+	}
+	return nil, ErrFileNotFound
+}
+
+// ToZip writes the entries chosen by sel to w as a zip, keeping the
+// modification time and mode of the entries. level is a compression level of
+// NewArchiveWriter; already compressed content is stored as is. Entries of
+// a zip are copied without recompression unless a level is given.
+func ToZip(format string, w io.Writer, r *httpreader.Reader, sel *Selector, charset string, level int) error {
+	_, err := Pack(format, w, r, sel, charset, ZIP_TYPE, level, "")
+	return err
+}
+
+// PackResult reports what Pack wrote.
+type PackResult struct {
+	// Packed is the number of entries written
+	Packed int
+	// Missing are the selected names and IDs not found in the archive
+	Missing []string
+	// Failed is the entry that could not be packed, the last one in the
+	// output, cut or left out
+	Failed string `json:",omitempty"`
+	// Error is the reason packing stopped
+	Error string `json:",omitempty"`
+}
+
+// Pack writes the entries chosen by sel to w as an archive in the
+// output format at the given compression level, keeping mode, modification
+// time, owner and symbolic links where both formats store them. Hard links
+// stay hard links in tar output when their target is packed before them.
+// Packing stops at the first entry that cannot be read or written. If
+// manifest is not empty, an entry of that name listing the result as JSON is
+// appended, also when packing stopped early.
+func Pack(format string, w io.Writer, r *httpreader.Reader, sel *Selector, charset string, output string, level int, manifest string) (*PackResult, error) {
+	if err := CheckOutput(format, r, output); err != nil {
+		return nil, err
+	}
+	switch format {
+	case ZIP_TYPE:
+		if output == ZIP_TYPE {
+			return zipToZip(w, r, sel, charset, level, manifest)
+		}
+	case TAR_TYPE, SEVEN_Z_TYPE, RAR_TYPE, AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE, ISO_TYPE, OCI_TYPE:
+	default:
+		return nil, errors.New("do not support " + format)
+	}
+	return pack(format, w, r, sel, charset, output, level, manifest)
+}
+
+func pack(format string, w io.Writer, r *httpreader.Reader, sel *Selector, charset string, output string, level int, manifest string) (*PackResult, error) {
+	aw, err := NewArchiveWriter(w, output, level)
+	if err != nil {
+		return nil, err
+	}
+	res := &PackResult{}
+	var found []Entry
+	err = Walk(format, r, charset, func(entry *Entry, er io.Reader) error {
+		if !sel.MatchEntry(entry) {
+			return nil
+		}
+		found = append(found, *entry)
+		renamed := *entry
+		renamed.Name = sel.Rename(entry.Name)
+		if renamed.Name == "" {
+			return nil
+		}
+		if isHardLink(entry) {
+			// the name of the target in the output, if it is selected too
+			renamed.Linkname = sel.Rename(entry.Linkname)
+		}
+		if err := aw.WriteEntry(&renamed, er); err != nil {
+			res.Failed = entry.Name
+			return err
+		}
+		res.Packed++
+		return nil
+	})
+	return finishPack(aw, res, sel.Missing(found), manifest, err)
+}
+
+// finishPack appends the manifest if requested and closes aw. err is the
+// error that stopped packing, if any.
+func finishPack(aw ArchiveWriter, res *PackResult, missing []string, manifest string, err error) (*PackResult, error) {
+	res.Missing = missing
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if manifest != "" {
+		content, _ := json.MarshalIndent(res, "", "\t")
+		entry := &Entry{
+			Name:    manifest,
+			Size:    int64(len(content)),
+			Mode:    0644,
+			ModTime: time.Now(),
+		}
+		if manifestErr := aw.WriteEntry(entry, bytes.NewReader(content)); err == nil {
+			err = manifestErr
+		}
+	}
+	if closeErr := aw.Close(); err == nil {
+		err = closeErr
+	}
+	return res, err
+}
+
+// Convert writes every entry of the archive to w as an archive in the output
+// format. Entries are streamed one by one without buffering, see CheckOutput
+// for the archives that cannot be written as tar.
+func Convert(format string, w io.Writer, r *httpreader.Reader, charset string, output string, level int) error {
+	if err := CheckOutput(format, r, output); err != nil {
+		return err
+	}
+	aw, err := NewArchiveWriter(w, output, level)
+	if err != nil {
+		return err
+	}
+	err = Walk(format, r, charset, aw.WriteEntry)
+	if closeErr := aw.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// lazyReader opens the underlying reader on the first Read, so walking an
+// archive does not fetch the content of entries nobody reads.
+type lazyReader struct {
+	open func() (io.ReadCloser, error)
+	r    io.ReadCloser
+	err  error
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.r == nil && l.err == nil {
+		l.r, l.err = l.open()
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.r.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	if l.r != nil {
+		return l.r.Close()
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package archive
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestOpenByID opens every listed entry by its ID, for each format and kind
+// of ID.
+func TestOpenByID(t *testing.T) {
+	files := []fixture{
+		fileFixture("usr/", ""),
+		fileFixture("usr/a.txt", "hello\n"),
+		fileFixture("usr/b.txt", "odd"),
+	}
+	deb := arFixture(t,
+		fileFixture("debian-binary", "2.0\n"),
+		fileFixture("control.tar.gz", string(gzipFixture(t, tarFixture(t, fileFixture("./control", "Package: a\n"))))),
+		fileFixture("data.tar", string(tarFixture(t, append([]fixture{fileFixture("./", "")}, files...)...))),
+	)
+	oci := tarFixture(t,
+		fileFixture("manifest.json", `[{"Layers":["lower.tar","upper.tar"]}]`),
+		fileFixture("lower.tar", string(tarFixture(t, files...))),
+		fileFixture("upper.tar", string(gzipFixture(t, tarFixture(t, fileFixture("etc/", ""), fileFixture("etc/c.conf", "c\n"))))),
+	)
+	contents := map[string]string{
+		"usr/a.txt":      "hello\n",
+		"usr/b.txt":      "odd",
+		"a.o":            "hello\n",
+		"b.o":            "odd",
+		"DEBIAN/control": "Package: a\n",
+		"etc/c.conf":     "c\n",
+	}
+	for _, test := range []struct {
+		name   string
+		format string
+		data   []byte
+		// the kinds of the IDs of the entries by name, in order
+		ids [][2]string
+	}{
+		{"zip", ZIP_TYPE, zipFixture(t, files...), [][2]string{{"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+		{"tar", TAR_TYPE, tarFixture(t, files...), [][2]string{{"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+		{"ar", AR_TYPE, arFixture(t, fileFixture("a.o", "hello\n"), fileFixture("b.o", "odd")), [][2]string{{"a.o", "o"}, {"b.o", "o"}}},
+		{"cpio", CPIO_TYPE, cpioFixture(t, files...), [][2]string{{"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+		{"deb", DEB_TYPE, deb, [][2]string{{"DEBIAN/control", "i"}, {"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+		{"rpm", RPM_TYPE, rpmFixture(t, "", files...), [][2]string{{"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+		{"rpm gzip", RPM_TYPE, rpmFixture(t, "gzip", files...), [][2]string{{"usr/", "i"}, {"usr/a.txt", "i"}, {"usr/b.txt", "i"}}},
+		{"oci", OCI_TYPE, oci, [][2]string{{"etc/", "i"}, {"etc/c.conf", "i"}, {"usr/", "o"}, {"usr/a.txt", "o"}, {"usr/b.txt", "o"}}},
+	} {
+		r := openFixture(t, "a."+test.format, test.data)
+		entries, err := ListEntries(test.format, r, "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(entries) != len(test.ids) {
+			t.Fatalf("%s: got %d entries, want %d", test.name, len(entries), len(test.ids))
+		}
+		for i, entry := range entries {
+			if entry.Name != test.ids[i][0] || !strings.HasPrefix(entry.ID, test.ids[i][1]) {
+				t.Errorf("%s: got %s of ID %s, want %s of kind %s", test.name, entry.Name, entry.ID, test.ids[i][0], test.ids[i][1])
+				continue
+			}
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			content, err := OpenByID(test.format, r, entry.ID)
+			if entry.IsDir {
+				if err != ErrIsDir {
+					t.Errorf("%s: open directory %s by ID %s,err:%v", test.name, entry.Name, entry.ID, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: open %s by ID %s,err:%s", test.name, entry.Name, entry.ID, err)
+				continue
+			}
+			if got, _ := io.ReadAll(content); string(got) != contents[entry.Name] {
+				t.Errorf("%s: %s of ID %s holds %q, want %q", test.name, entry.Name, entry.ID, got, contents[entry.Name])
+			}
+		}
+		for _, id := range []string{"i99", "o1", "x0"} {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenByID(test.format, r, id); err != ErrFileNotFound {
+				t.Errorf("%s: open unknown ID %s,err:%v", test.name, id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+const (
+	// UTF8 is the charset reported when every entry name is valid UTF-8.
+	UTF8 = "UTF-8"
+	// IBM437 is the charset of zip entry names without the UTF-8 flag
+	// according to the zip specification, used when no other charset is
+	// detected.
+	IBM437 = "IBM437"
+)
+
+// zipUnicodePathExtra is the id of the Info-ZIP Unicode Path extra field,
+// holding the UTF-8 name of an entry whose header name is not UTF-8.
+const zipUnicodePathExtra = 0x7075
+
+// DetectCharset returns the most likely charset of names, which are not
+// valid UTF-8, by the statistics of all of them together. It returns
+// fallback if no charset decodes them all.
+func DetectCharset(names []string, fallback string) string {
+	if len(names) == 0 {
+		return fallback
+	}
+	text := []byte(strings.Join(names, "\n"))
+	results, err := chardet.NewTextDetector().DetectAll(text)
+	if err != nil {
+		return fallback
+	}
+	for _, result := range results {
+		charset := ianaCharset(result.Charset)
+		if strings.HasPrefix(charset, "UTF-") || strings.HasPrefix(charset, "ISO-2022") {
+			continue
+		}
+		if decodesAll(names, charset) {
+			return charset
+		}
+	}
+	return fallback
+}
+
+// ianaCharset returns the IANA name of a charset reported by chardet.
+func ianaCharset(charset string) string {
+	if charset == "GB-18030" {
+		return "GB18030"
+	}
+	return charset
+}
+
+// decodesAll tells whether all names are decoded by charset to printable
+// characters.
+func decodesAll(names []string, charset string) bool {
+	encoding, err := ianaindex.IANA.Encoding(charset)
+	if err != nil || encoding == nil {
+		return false
+	}
+	decoder := encoding.NewDecoder()
+	for _, name := range names {
+		decoded, err := decoder.String(name)
+		if err != nil {
+			return false
+		}
+		for _, c := range decoded {
+			if c == utf8.RuneError || !unicode.IsPrint(c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nameDecoder decodes the entry names of a zip or tar archive with charset.
+// Without a charset, names that are valid UTF-8 are kept as is and the
+// others are decoded with the charset detected from all of them.
+type nameDecoder struct {
+	charset string
+	auto    bool
+	// detect returns the charset of the names that are not valid UTF-8, it
+	// is called the first time such a name is decoded
+	detect   func() string
+	detected bool
+}
+
+func newNameDecoder(charset string, detect func() string) *nameDecoder {
+	return &nameDecoder{charset: charset, auto: charset == "", detect: detect}
+}
+
+func (d *nameDecoder) decode(name string) string {
+	if d.auto {
+		if utf8.ValidString(name) {
+			return name
+		}
+		if !d.detected {
+			d.charset = d.detect()
+			d.detected = true
+		}
+	}
+	if d.charset == "" {
+		return name
+	}
+	decoded, err := DecodeString(name, d.charset)
+	if err != nil {
+		return name
+	}
+	return decoded
+}
+
+// Charset returns the charset the names were decoded with: the given one,
+// the detected one, UTF-8 if all names were valid UTF-8, or empty if none
+// was detected.
+func (d *nameDecoder) Charset() string {
+	if d.auto && !d.detected {
+		return UTF8
+	}
+	return d.charset
+}
+
+// decodeNames decodes names, all known in advance, like a nameDecoder.
+func decodeNames(names []string, charset string, fallback string) ([]string, string) {
+	decoder := newNameDecoder(charset, func() string {
+		return DetectCharset(invalidNames(names), fallback)
+	})
+	decoded := make([]string, len(names))
+	for i, name := range names {
+		decoded[i] = decoder.decode(name)
+	}
+	return decoded, decoder.Charset()
+}
+
+func invalidNames(names []string) []string {
+	var invalid []string
+	for _, name := range names {
+		if !utf8.ValidString(name) {
+			invalid = append(invalid, name)
+		}
+	}
+	return invalid
+}
+
+// zipNames returns the names of the zip entries and the charset they were
+// decoded with. Names flagged as UTF-8 or carrying an Info-ZIP Unicode Path
+// are kept, the others are decoded with charset, detected if empty and
+// IBM437 if detection fails.
+func zipNames(files []*zip.File, charset string) ([]string, string) {
+	names := make([]string, len(files))
+	// the indexes and the names of the entries to decode
+	var legacy []int
+	var legacyNames []string
+	for i, file := range files {
+		if name, ok := zipUnicodeName(file); ok {
+			names[i] = name
+			continue
+		}
+		legacy = append(legacy, i)
+		legacyNames = append(legacyNames, file.Name)
+	}
+	if len(legacy) == 0 {
+		if charset == "" {
+			charset = UTF8
+		}
+		return names, charset
+	}
+	decoded, charset := decodeNames(legacyNames, charset, IBM437)
+	for i, name := range decoded {
+		names[legacy[i]] = name
+	}
+	return names, charset
+}
+
+// zipUnicodeName returns the UTF-8 name of file if the name is flagged as
+// UTF-8 or if the file has an Info-ZIP Unicode Path matching its name.
+func zipUnicodeName(file *zip.File) (string, bool) {
+	if file.Flags&zipUTF8Flag != 0 && utf8.ValidString(file.Name) {
+		return file.Name, true
+	}
+	extra := file.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		// version, crc32 of the header name, utf-8 name
+		if tag != zipUnicodePathExtra || len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(field[1:]) != crc32.ChecksumIEEE([]byte(file.Name)) {
+			// the name was changed by a tool unaware of the field
+			continue
+		}
+		if name := string(field[5:]); utf8.ValidString(name) {
+			return name, true
+		}
+	}
+	return "", false
+}
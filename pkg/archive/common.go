@@ -5,7 +5,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/Heng-Bian/httpreader"
 	"github.com/gabriel-vasile/mimetype"
@@ -17,6 +19,14 @@ var (
 
 	ErrFileNotFound  = errors.New("file not found in archive")
 	ErrOutOfBoundary = errors.New("file index out of archive boundary")
+	// ErrIsDir is returned when the content of a directory entry is opened
+	ErrIsDir = errors.New("entry is a directory")
+	// ErrUDFNotSupported is returned by DetectFormat for UDF images without
+	// an ISO 9660 hierarchy
+	ErrUDFNotSupported = errors.New("UDF not supported, only ISO 9660 images and UDF bridge images with an ISO 9660 hierarchy are")
+	// ErrUnknownSize is returned when a file whose size the archive does
+	// not record is written as tar, which needs the size before the content
+	ErrUnknownSize = errors.New("tar output needs the size of the files, which the archive does not record")
 )
 
 const (
@@ -30,6 +40,12 @@ const (
 	XZ_MIME_TYPE    = "application/x-xz"
 	DEFALUT_MIME    = "application/octet-stream"
 
+	AR_MIME_TYPE   = "application/x-archive"
+	DEB_MIME_TYPE  = "application/vnd.debian.binary-package"
+	CPIO_MIME_TYPE = "application/x-cpio"
+	RPM_MIME_TYPE  = "application/x-rpm"
+	ISO_MIME_TYPE  = "application/x-iso9660-image"
+
 	RAR_TYPE     = "rar"
 	ZIP_TYPE     = "zip"
 	TAR_TYPE     = "tar"
@@ -38,14 +54,29 @@ const (
 	GZIP_TYPE  = "gzip"
 	BZIP2_TYPE = "bzip2"
 	XZ_TYPE    = "xz"
+
+	AR_TYPE   = "ar"
+	DEB_TYPE  = "deb"
+	CPIO_TYPE = "cpio"
+	RPM_TYPE  = "rpm"
+	ISO_TYPE  = "iso"
+	// OCI_TYPE reads a tarball of docker save or an OCI image layout as
+	// the merged filesystem of its layers, it is never detected
+	OCI_TYPE = "oci"
 )
 
 func ListSupprotedFileFormat() []string {
-	supprot := make([]string, 0, 7)
+	supprot := make([]string, 0, 13)
 	supprot = append(supprot, RAR_TYPE)
 	supprot = append(supprot, ZIP_TYPE)
 	supprot = append(supprot, TAR_TYPE)
 	supprot = append(supprot, SEVEN_Z_TYPE)
+	supprot = append(supprot, AR_TYPE)
+	supprot = append(supprot, DEB_TYPE)
+	supprot = append(supprot, CPIO_TYPE)
+	supprot = append(supprot, RPM_TYPE)
+	supprot = append(supprot, ISO_TYPE)
+	supprot = append(supprot, OCI_TYPE)
 
 	supprot = append(supprot, GZIP_TYPE)
 	supprot = append(supprot, BZIP2_TYPE)
@@ -69,6 +100,16 @@ func MineTypeTransform(mimeType string) string {
 		return BZIP2_TYPE
 	case XZ_MIME_TYPE:
 		return XZ_TYPE
+	case AR_MIME_TYPE:
+		return AR_TYPE
+	case DEB_MIME_TYPE:
+		return DEB_TYPE
+	case CPIO_MIME_TYPE:
+		return CPIO_TYPE
+	case RPM_MIME_TYPE:
+		return RPM_TYPE
+	case ISO_MIME_TYPE:
+		return ISO_TYPE
 	case DEFALUT_MIME:
 		return ""
 	}
@@ -76,17 +117,56 @@ func MineTypeTransform(mimeType string) string {
 }
 
 func DetectMimeTypeThenSeek(r io.Reader) (string, error) {
-	mime, err := mimetype.DetectReader(r)
+	mime, err := detectMimeThenSeek(r)
 	if err != nil {
 		return "", err
 	}
+	return mime.String(), nil
+}
+
+func detectMimeThenSeek(r io.Reader) (*mimetype.MIME, error) {
+	mime, err := mimetype.DetectReader(r)
+	if err != nil {
+		return nil, err
+	}
 	seeker, ok := r.(io.Seeker)
 	if ok {
 		seeker.Seek(0, io.SeekStart)
 	} else {
-		return "", errors.New("fail to seek after detecting mime type")
+		return nil, errors.New("fail to seek after detecting mime type")
 	}
-	return mime.String(), nil
+	return mime, nil
+}
+
+// DetectFormat detects the archive format of r, then seeks back to the start.
+// An empty format means r is not a supported archive. Containers based on an
+// archive format, eg. docx, odt, epub and jar which are zip files, are
+// detected as that format.
+func DetectFormat(r io.Reader) (string, error) {
+	mime, err := detectMimeThenSeek(r)
+	if err != nil {
+		return "", err
+	}
+	detected := mime.String()
+	for ; mime != nil; mime = mime.Parent() {
+		if format := MineTypeTransform(mime.String()); format != "" {
+			return format, nil
+		}
+	}
+	// mimetype does not read as far as the volume descriptors of ISO 9660,
+	// whose system area is usually empty
+	if readerAt, ok := r.(io.ReaderAt); ok && detected == DEFALUT_MIME {
+		if isUdf(readerAt) {
+			return "", ErrUDFNotSupported
+		}
+		if isIso(readerAt) {
+			if _, err := r.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+				return "", err
+			}
+			return ISO_TYPE, nil
+		}
+	}
+	return "", nil
 }
 
 func UrlToReader(httpUrl string, client *http.Client) (*httpreader.Reader, error) {
@@ -106,6 +186,30 @@ func UrlToReader(httpUrl string, client *http.Client) (*httpreader.Reader, error
 	return reader, err
 }
 
+// FileToReader returns a Reader of a local file. The file is served by
+// http.NewFileTransport, so it is accessed by Range requests exactly like a
+// remote archive.
+func FileToReader(path string) (*httpreader.Reader, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: http.NewFileTransport(http.Dir(filepath.Dir(abs))),
+	}
+	url := &url.URL{Scheme: "file", Path: "/" + filepath.Base(abs)}
+	return httpreader.NewReader(url, httpreader.WithClient(client))
+}
+
+// LocationToReader returns a Reader of location, which is either a http(s)
+// URL or a local file path.
+func LocationToReader(location string, client *http.Client) (*httpreader.Reader, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return UrlToReader(location, client)
+	}
+	return FileToReader(location)
+}
+
 func DecodeString(src string, name string) (string, error) {
 	encoding, err := ianaindex.IANA.Encoding(name)
 	if err != nil {
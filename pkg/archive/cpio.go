@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	// the magic numbers of the portable ASCII formats of cpio
+	cpioNewc = "070701"
+	cpioCRC  = "070702"
+	cpioODC  = "070707"
+
+	cpioNewcHeaderSize = 110
+	cpioODCHeaderSize  = 76
+	cpioTrailer        = "TRAILER!!!"
+	// cpioMaxName bounds the length of an entry name
+	cpioMaxName = 64 << 10
+)
+
+// cpioReader reads the entries of a cpio archive in the newc, crc and odc
+// formats.
+type cpioReader struct {
+	r *countingReader
+	// offsets tells that r reads the archive itself, whose entries are
+	// identified by the offsets of their headers rather than their indexes
+	offsets bool
+	// base is the offset of the archive in r, to which newc aligns
+	base  int64
+	index int64
+	// remaining is the unread size of the content of the current entry
+	remaining int64
+	// pad is the padding after it, newc aligns headers to 4 bytes
+	pad int64
+}
+
+func newCpioReader(r *httpreader.Reader, offsets bool) (*cpioReader, error) {
+	off, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return &cpioReader{r: &countingReader{r: r, n: off}, offsets: offsets}, nil
+}
+
+func (c *cpioReader) next() (*Entry, error) {
+	if err := c.r.skip(c.remaining + c.pad); err != nil {
+		return nil, err
+	}
+	c.remaining, c.pad = 0, 0
+	off := c.r.n
+	magic := make([]byte, 6)
+	if _, err := io.ReadFull(c.r, magic); err != nil {
+		if err == io.EOF {
+			// archives ending without a trailer
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	var fields []int64
+	var err error
+	switch string(magic) {
+	case cpioNewc, cpioCRC:
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor,
+		// rdevmajor, rdevminor, namesize and check in 8 hex digits
+		fields, err = c.readFields(cpioNewcHeaderSize-len(magic), 8, 16)
+		if err == nil {
+			fields = []int64{fields[1], fields[2], fields[3], fields[5], fields[11], fields[6]}
+		}
+	case cpioODC:
+		// dev, ino, mode, uid, gid, nlink and rdev in 6 octal digits, mtime
+		// in 11, namesize in 6 and filesize in 11
+		var header [cpioODCHeaderSize - 6]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return nil, err
+		}
+		fields = make([]int64, 0, 6)
+		for _, field := range [][2]int{{12, 18}, {18, 24}, {24, 30}, {42, 53}, {53, 59}, {59, 70}} {
+			n, parseErr := strconv.ParseInt(string(header[field[0]:field[1]]), 8, 64)
+			if parseErr != nil {
+				err = parseErr
+				break
+			}
+			fields = append(fields, n)
+		}
+	default:
+		return nil, fmt.Errorf("invalid cpio header at %d", off)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpio header at %d,err:%s", off, err)
+	}
+	mode, uid, gid, mtime, nameSize, size := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5]
+	if nameSize <= 0 || nameSize > cpioMaxName || size < 0 {
+		return nil, fmt.Errorf("invalid cpio header at %d", off)
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, err
+	}
+	newc := string(magic) != cpioODC
+	if newc {
+		if err := c.r.skip(cpioPad(c.r.n - c.base)); err != nil {
+			return nil, err
+		}
+		c.pad = cpioPad(c.r.n - c.base + size)
+	}
+	c.remaining = size
+	entry := &Entry{
+		Name:    string(bytes.TrimRight(name, "\x00")),
+		Size:    size,
+		Mode:    unixMode(mode),
+		ModTime: time.Unix(mtime, 0),
+		IsDir:   mode&unixTypeMask == unixDir,
+		Uid:     int(uid),
+		Gid:     int(gid),
+	}
+	if entry.Name == cpioTrailer {
+		return nil, io.EOF
+	}
+	if entry.IsDir && !strings.HasSuffix(entry.Name, "/") {
+		entry.Name += "/"
+	}
+	if c.offsets {
+		entry.ID = entryID(offsetID, off)
+	} else {
+		entry.ID = entryID(indexID, c.index)
+	}
+	c.index++
+	return entry, nil
+}
+
+// readFields reads a header of size bytes made of numbers of width digits
+// in the given base.
+func (c *cpioReader) readFields(size int, width int, base int) ([]int64, error) {
+	header := make([]byte, size)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return nil, err
+	}
+	fields := make([]int64, 0, size/width)
+	for i := 0; i+width <= size; i += width {
+		n, err := strconv.ParseInt(string(header[i:i+width]), base, 64)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, n)
+	}
+	return fields, nil
+}
+
+// cpioPad is the padding of newc aligning off to 4 bytes.
+func cpioPad(off int64) int64 {
+	return (4 - off%4) % 4
+}
+
+func (c *cpioReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,336 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+// the Status of an EntryDiff
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// the ways a modification is detected, the By of an EntryDiff
+const (
+	bySize   = "size"
+	byCRC32  = "crc32"
+	bySHA256 = "sha256"
+	// byType is a symbolic link replaced by another type of entry or the
+	// other way round
+	byType = "type"
+	// byTarget is a symbolic link to another target
+	byTarget = "target"
+)
+
+// defaultMaxDiffSize is the size up to which entries get a unified diff.
+const defaultMaxDiffSize = 64 << 10
+
+// ArchiveSource is an archive opened for reading.
+type ArchiveSource struct {
+	Format  string
+	Reader  *httpreader.Reader
+	Charset string
+}
+
+// DiffOptions configures Diff.
+type DiffOptions struct {
+	// Content hashes the entries whose sizes are equal and whose CRC32 is
+	// not stored by both archives, instead of counting them as unverified
+	Content bool
+	// Unified adds a unified diff to modified text entries not larger than
+	// MaxDiffSize, 64KB if 0
+	Unified     bool
+	MaxDiffSize int64
+}
+
+// EntryDiff is an entry added, removed or modified in the other archive.
+type EntryDiff struct {
+	Name   string
+	Status string
+	// Size in the base and the other archive, -1 if absent or unknown
+	OldSize int64
+	NewSize int64
+	// By tells how a modification was detected: size, crc32, sha256, type
+	// or target
+	By string `json:",omitempty"`
+	// Diff is the unified diff of a modified text entry
+	Diff string `json:",omitempty"`
+}
+
+// DiffResult lists the differences of two archives by entry name.
+type DiffResult struct {
+	Changes   []EntryDiff
+	Added     int
+	Removed   int
+	Modified  int
+	Unchanged int
+	// Unverified is the number of entries of equal size counted as
+	// unchanged without comparing their content
+	Unverified int
+}
+
+// Diff compares the entries of the base archive with the ones of other,
+// which may be of another format. Directories are only added or removed, a
+// leading "./" of the names is ignored. Symbolic links are compared by
+// target, whether the format stores it in the header, like tar, or as
+// content, like zip.
+func Diff(base *ArchiveSource, other *ArchiveSource, opts *DiffOptions) (*DiffResult, error) {
+	oldEntries, err := base.entries()
+	if err != nil {
+		return nil, err
+	}
+	newEntries, err := other.entries()
+	if err != nil {
+		return nil, err
+	}
+	maxDiffSize := opts.MaxDiffSize
+	if maxDiffSize <= 0 {
+		maxDiffSize = defaultMaxDiffSize
+	}
+	res := &DiffResult{Changes: make([]EntryDiff, 0, 10)}
+	// entries whose content must be read from both archives
+	var hashed, diffed []string
+	// links whose target is the content of the entry in either archive
+	var linked []EntryDiff
+	for name, oldEntry := range oldEntries {
+		newEntry, ok := newEntries[name]
+		if !ok {
+			res.Changes = append(res.Changes, EntryDiff{Name: name, Status: DiffRemoved, OldSize: oldEntry.Size, NewSize: -1})
+			continue
+		}
+		if oldEntry.IsDir {
+			res.Unchanged++
+			continue
+		}
+		change := EntryDiff{Name: name, Status: DiffModified, OldSize: oldEntry.Size, NewSize: newEntry.Size}
+		oldLink, newLink := oldEntry.Mode&fs.ModeSymlink != 0, newEntry.Mode&fs.ModeSymlink != 0
+		switch {
+		case oldLink != newLink:
+			change.By = byType
+		case oldLink && (oldEntry.Linkname == "" || newEntry.Linkname == ""):
+			change.By = byTarget
+			linked = append(linked, change)
+			continue
+		case oldLink:
+			if oldEntry.Linkname == newEntry.Linkname {
+				res.Unchanged++
+				continue
+			}
+			change.By = byTarget
+		case oldEntry.Size >= 0 && newEntry.Size >= 0 && oldEntry.Size != newEntry.Size:
+			change.By = bySize
+		case base.Format == ZIP_TYPE && other.Format == ZIP_TYPE:
+			if oldEntry.CRC32 == newEntry.CRC32 {
+				res.Unchanged++
+				continue
+			}
+			change.By = byCRC32
+		case opts.Content:
+			hashed = append(hashed, name)
+			continue
+		default:
+			res.Unchanged++
+			res.Unverified++
+			continue
+		}
+		res.Changes = append(res.Changes, change)
+	}
+	for name, newEntry := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			res.Changes = append(res.Changes, EntryDiff{Name: name, Status: DiffAdded, OldSize: -1, NewSize: newEntry.Size})
+		}
+	}
+	if opts.Unified {
+		for _, change := range res.Changes {
+			if change.Status == DiffModified && change.By != byType && change.By != byTarget &&
+				change.OldSize <= maxDiffSize && change.NewSize <= maxDiffSize {
+				diffed = append(diffed, change.Name)
+			}
+		}
+	}
+	if len(hashed) != 0 || len(diffed) != 0 || len(linked) != 0 {
+		if err := compareContent(base, other, hashed, diffed, linked, maxDiffSize, opts.Unified, res); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(res.Changes, func(i, j int) bool {
+		return res.Changes[i].Name < res.Changes[j].Name
+	})
+	for _, change := range res.Changes {
+		switch change.Status {
+		case DiffAdded:
+			res.Added++
+		case DiffRemoved:
+			res.Removed++
+		case DiffModified:
+			res.Modified++
+		}
+	}
+	return res, nil
+}
+
+// compareContent hashes the hashed entries of both archives and adds the
+// modified ones to res, adds the linked changes whose targets differ, then
+// adds the unified diff of modified text entries if unified is set, reading
+// each archive once.
+func compareContent(base *ArchiveSource, other *ArchiveSource, hashed []string, diffed []string, linked []EntryDiff, maxDiffSize int64, unified bool, res *DiffResult) error {
+	names := append([]string{}, diffed...)
+	for _, change := range linked {
+		names = append(names, change.Name)
+	}
+	oldContent, err := base.read(hashed, names, maxDiffSize)
+	if err != nil {
+		return err
+	}
+	newContent, err := other.read(hashed, names, maxDiffSize)
+	if err != nil {
+		return err
+	}
+	for _, change := range linked {
+		oldRead, newRead := oldContent[change.Name], newContent[change.Name]
+		if oldRead == nil || newRead == nil || oldRead.target == newRead.target {
+			res.Unchanged++
+			continue
+		}
+		res.Changes = append(res.Changes, change)
+	}
+	for _, name := range hashed {
+		oldRead, newRead := oldContent[name], newContent[name]
+		if oldRead == nil || newRead == nil || oldRead.sum == newRead.sum {
+			res.Unchanged++
+			continue
+		}
+		res.Changes = append(res.Changes, EntryDiff{
+			Name:    name,
+			Status:  DiffModified,
+			OldSize: oldRead.size,
+			NewSize: newRead.size,
+			By:      bySHA256,
+		})
+	}
+	if !unified {
+		return nil
+	}
+	for i := range res.Changes {
+		change := &res.Changes[i]
+		oldRead, newRead := oldContent[change.Name], newContent[change.Name]
+		if change.Status != DiffModified || oldRead == nil || newRead == nil || oldRead.content == nil || newRead.content == nil {
+			continue
+		}
+		if isBinary(oldRead.content) || isBinary(newRead.content) {
+			continue
+		}
+		change.Diff = unifiedDiff(change.Name, oldRead.content, newRead.content)
+	}
+	return nil
+}
+
+// entryContent is what read keeps of an entry.
+type entryContent struct {
+	size int64
+	sum  [sha256.Size]byte
+	// content is nil if the entry is larger than the limit of read
+	content []byte
+	// target is the target of a symbolic link
+	target string
+}
+
+// entries returns the entries of the archive by name.
+func (s *ArchiveSource) entries() (map[string]Entry, error) {
+	if _, err := s.Reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	list, err := ListEntries(s.Format, s.Reader, s.Charset)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]Entry, len(list))
+	for _, entry := range list {
+		if name := diffName(entry.Name); name != "" {
+			entries[name] = entry
+		}
+	}
+	return entries, nil
+}
+
+// diffName is the name under which entries of two archives are compared,
+// tar archives made of "." name their entries "./name".
+func diffName(name string) string {
+	return strings.TrimPrefix(name, "./")
+}
+
+// read returns the digest of the hashed entries and the content of the
+// hashed and diffed entries not larger than limit, or the target of those
+// which are symbolic links.
+func (s *ArchiveSource) read(hashed []string, diffed []string, limit int64) (map[string]*entryContent, error) {
+	wanted := make(map[string]bool, len(hashed)+len(diffed))
+	for _, name := range hashed {
+		wanted[name] = true
+	}
+	for _, name := range diffed {
+		wanted[name] = true
+	}
+	if _, err := s.Reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	contents := make(map[string]*entryContent, len(wanted))
+	err := Walk(s.Format, s.Reader, s.Charset, func(entry *Entry, r io.Reader) error {
+		name := diffName(entry.Name)
+		if !wanted[name] {
+			return nil
+		}
+		if entry.Mode&fs.ModeSymlink != 0 {
+			target, err := linkTarget(entry, r)
+			contents[name] = &entryContent{target: target}
+			return err
+		}
+		digest := sha256.New()
+		var buf bytes.Buffer
+		n, err := io.Copy(io.MultiWriter(digest, &limitedBuffer{buf: &buf, limit: limit}), r)
+		if err != nil {
+			return err
+		}
+		content := &entryContent{size: n}
+		copy(content.sum[:], digest.Sum(nil))
+		if n <= limit {
+			content.content = append([]byte{}, buf.Bytes()...)
+		}
+		contents[name] = content
+		return nil
+	})
+	return contents, err
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int64
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if room := l.limit - int64(l.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			l.buf.Write(p[:room])
+		} else {
+			l.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"testing"
+)
+
+// openDiffFixture opens a tar, or a zip if name ends with .zip, of the
+// fixtures.
+func openDiffFixture(t *testing.T, name string, fixtures []fixture) *ArchiveSource {
+	t.Helper()
+	if filepath.Ext(name) == ".zip" {
+		return &ArchiveSource{Format: ZIP_TYPE, Reader: openFixture(t, name, zipFixture(t, fixtures...))}
+	}
+	return &ArchiveSource{Format: TAR_TYPE, Reader: openFixture(t, name, tarFixture(t, fixtures...))}
+}
+
+func TestDiffLinks(t *testing.T) {
+	base := []fixture{
+		fileFixture("a.txt", "hello\n"),
+		{name: "same", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+		{name: "moved", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+		{name: "typed", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+	}
+	other := []fixture{
+		fileFixture("./a.txt", "hello\n"),
+		{name: "./same", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+		{name: "./moved", typeflag: tar.TypeSymlink, linkname: "b.txt"},
+		fileFixture("./typed", "hello\n"),
+	}
+	for _, test := range []struct {
+		base, other string
+	}{
+		{"a.tar", "b.zip"},
+		{"a.zip", "b.tar"},
+		{"a.tar", "b.tar"},
+		{"a.zip", "b.zip"},
+	} {
+		res, err := Diff(openDiffFixture(t, test.base, base), openDiffFixture(t, test.other, other), &DiffOptions{Content: true, Unified: true})
+		if err != nil {
+			t.Fatalf("%s and %s: %s", test.base, test.other, err)
+		}
+		if res.Modified != 2 || res.Unchanged != 2 || len(res.Changes) != 2 {
+			t.Fatalf("%s and %s: unexpected result %+v", test.base, test.other, res)
+		}
+		for i, want := range []EntryDiff{
+			{Name: "moved", Status: DiffModified, By: byTarget},
+			{Name: "typed", Status: DiffModified, By: byType},
+		} {
+			got := res.Changes[i]
+			if got.Name != want.Name || got.Status != want.Status || got.By != want.By || got.Diff != "" {
+				t.Errorf("%s and %s: got change %+v, want %+v", test.base, test.other, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Heng-Bian/httpreader"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// the kinds of documents stored in a zip container
+const (
+	DocumentJAR   = "jar"
+	DocumentAPK   = "apk"
+	DocumentWheel = "wheel"
+	DocumentNuGet = "nupkg"
+	DocumentEPUB  = "epub"
+	DocumentOOXML = "ooxml"
+	DocumentODF   = "odf"
+)
+
+// maxDocumentPart bounds the size of a metadata entry read in memory.
+const maxDocumentPart = 1 << 20
+
+// DocumentInfo describes the document stored in a zip container from its
+// metadata entry.
+type DocumentInfo struct {
+	// Type is one of DocumentJAR, DocumentAPK, DocumentWheel, DocumentNuGet,
+	// DocumentEPUB, DocumentOOXML and DocumentODF
+	Type string
+	// ContentType is the media type an EPUB or ODF document declares in
+	// its mimetype entry
+	ContentType string `json:",omitempty"`
+	// Source is the entry the properties are read from
+	Source string `json:",omitempty"`
+	// Properties are named like in Source, eg. Main-Class for a jar, Version
+	// for a wheel, title and creator for EPUB, OOXML and ODF. Repeated
+	// properties are joined with ", ".
+	Properties map[string]string `json:",omitempty"`
+}
+
+// Document recognizes the zip container r, eg. a jar, a wheel, an epub or an
+// office document, from the names of its entries and reads its metadata.
+// It returns nil if r is a plain zip file.
+func Document(r *httpreader.Reader) (*DocumentInfo, error) {
+	zipReader, err := zip.NewReader(r, r.Length)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File, len(zipReader.File))
+	var nuspec, wheelMetadata *zip.File
+	for _, file := range zipReader.File {
+		files[file.Name] = file
+		dir, name := path.Split(file.Name)
+		switch {
+		case dir == "" && strings.HasSuffix(name, ".nuspec"):
+			nuspec = file
+		case name == "METADATA" && strings.HasSuffix(dir, ".dist-info/") && strings.Count(dir, "/") == 1:
+			wheelMetadata = file
+		}
+	}
+	doc := &DocumentInfo{}
+	switch {
+	case nuspec != nil:
+		doc.Type = DocumentNuGet
+		err = doc.readXML(nuspec, "metadata")
+	case files["mimetype"] != nil:
+		err = doc.readMimetypeDocument(files)
+	case files["[Content_Types].xml"] != nil:
+		doc.Type = DocumentOOXML
+		if core := files["docProps/core.xml"]; core != nil {
+			err = doc.readXML(core, "coreProperties")
+		}
+	case wheelMetadata != nil:
+		doc.Type = DocumentWheel
+		err = doc.readHeaders(wheelMetadata, false)
+	case files["AndroidManifest.xml"] != nil:
+		// the manifest of an apk is binary XML, the jar manifest only lists
+		// digests
+		doc.Type = DocumentAPK
+	case files["META-INF/MANIFEST.MF"] != nil:
+		doc.Type = DocumentJAR
+		err = doc.readHeaders(files["META-INF/MANIFEST.MF"], true)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if doc.Type == "" {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// readMimetypeDocument reads an EPUB or an ODF document, which store their
+// media type uncompressed in the first entry named mimetype.
+func (doc *DocumentInfo) readMimetypeDocument(files map[string]*zip.File) error {
+	content, err := readDocumentPart(files["mimetype"])
+	if err != nil {
+		return err
+	}
+	doc.ContentType = strings.TrimSpace(string(content))
+	switch {
+	case doc.ContentType == "application/epub+zip":
+		doc.Type = DocumentEPUB
+		container := files["META-INF/container.xml"]
+		if container == nil {
+			return nil
+		}
+		rootfile, err := epubRootfile(container)
+		if err != nil {
+			return err
+		}
+		if opf := files[rootfile]; opf != nil {
+			return doc.readXML(opf, "metadata")
+		}
+	case strings.HasPrefix(doc.ContentType, "application/vnd.oasis.opendocument."):
+		doc.Type = DocumentODF
+		if meta := files["meta.xml"]; meta != nil {
+			return doc.readXML(meta, "meta")
+		}
+	}
+	return nil
+}
+
+func readDocumentPart(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxDocumentPart {
+		return nil, fmt.Errorf("%s is larger than %d bytes", file.Name, maxDocumentPart)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("fail to open %s,err:%s", file.Name, err)
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxDocumentPart))
+}
+
+// readHeaders reads the main section of a jar manifest, whose long values
+// continue on lines starting with a space, or the headers of the metadata
+// of a wheel.
+func (doc *DocumentInfo) readHeaders(file *zip.File, continued bool) error {
+	content, err := readDocumentPart(file)
+	if err != nil {
+		return err
+	}
+	doc.Source = file.Name
+	doc.Properties = make(map[string]string)
+	var key string
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxDocumentPart)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			// the end of the main section or of the headers
+			break
+		}
+		if continued && strings.HasPrefix(line, " ") && key != "" {
+			doc.Properties[key] += line[1:]
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		key = line[:i]
+		doc.addProperty(key, strings.TrimSpace(line[i+1:]))
+	}
+	return scanner.Err()
+}
+
+// readXML reads the text of the children of the first element named parent,
+// eg. dc:title in the metadata of an OPF package.
+func (doc *DocumentInfo) readXML(file *zip.File, parent string) error {
+	content, err := readDocumentPart(file)
+	if err != nil {
+		return err
+	}
+	doc.Source = file.Name
+	doc.Properties = make(map[string]string)
+	decoder := xml.NewDecoder(strings.NewReader(string(content)))
+	decoder.CharsetReader = xmlCharsetReader
+	// the depth of the current element below parent, 0 outside of it
+	depth := 0
+	var key string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fail to parse %s,err:%s", file.Name, err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0 && t.Name.Local == parent:
+				depth = 1
+			case depth == 1:
+				depth = 2
+				key = t.Name.Local
+				for _, attr := range t.Attr {
+					// EPUB 3 names its meta elements with property
+					if key == "meta" && attr.Name.Local == "property" {
+						key = attr.Value
+					}
+				}
+				text.Reset()
+			case depth > 1:
+				depth++
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			switch depth {
+			case 1:
+				// only the first parent is read
+				return nil
+			case 2:
+				doc.addProperty(key, strings.TrimSpace(text.String()))
+			}
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+}
+
+func (doc *DocumentInfo) addProperty(key, value string) {
+	if value == "" {
+		return
+	}
+	if previous, ok := doc.Properties[key]; ok {
+		value = previous + ", " + value
+	}
+	doc.Properties[key] = value
+}
+
+// epubRootfile returns the name of the OPF package of an EPUB from its
+// container.
+func epubRootfile(container *zip.File) (string, error) {
+	content, err := readDocumentPart(container)
+	if err != nil {
+		return "", err
+	}
+	var c struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	decoder := xml.NewDecoder(strings.NewReader(string(content)))
+	decoder.CharsetReader = xmlCharsetReader
+	if err := decoder.Decode(&c); err != nil {
+		return "", fmt.Errorf("fail to parse %s,err:%s", container.Name, err)
+	}
+	if len(c.Rootfiles) == 0 {
+		return "", errors.New("no rootfile in " + container.Name)
+	}
+	return c.Rootfiles[0].FullPath, nil
+}
+
+// xmlCharsetReader decodes XML declaring a charset other than UTF-8.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := ianaindex.IANA.Encoding(charset)
+	if err != nil {
+		return nil, err
+	}
+	if enc == nil {
+		return nil, errors.New("unsupported charset " + charset)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,279 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+// fixture is an entry of a test archive: a file holding content, a
+// directory if the name ends with "/", or a link to linkname of the given
+// tar typeflag.
+type fixture struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+	// extra is the extra field of a zip entry
+	extra []byte
+}
+
+// fileFixture returns the fixture of a file holding content.
+func fileFixture(name, content string) fixture {
+	return fixture{name: name, content: content}
+}
+
+// tarFixture returns a tar of the fixtures, in order, in the GNU format so
+// that names need not be valid UTF-8.
+func tarFixture(t *testing.T, fixtures ...fixture) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range fixtures {
+		header := &tar.Header{Name: f.name, Mode: 0644, Typeflag: f.typeflag, Linkname: f.linkname, Format: tar.FormatGNU}
+		switch {
+		case strings.HasSuffix(f.name, "/"):
+			header.Mode, header.Typeflag = 0755, tar.TypeDir
+		case f.typeflag == tar.TypeSymlink:
+			header.Mode = 0777
+		case f.typeflag == 0 || f.typeflag == tar.TypeReg:
+			header.Typeflag, header.Size = tar.TypeReg, int64(len(f.content))
+		}
+		err := tw.WriteHeader(header)
+		if err == nil {
+			_, err = io.WriteString(tw, f.content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipFixture returns a zip of the fixtures, in order. A symbolic link holds
+// its target as content, as zip stores it.
+func zipFixture(t *testing.T, fixtures ...fixture) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range fixtures {
+		header := &zip.FileHeader{Name: f.name, Method: zip.Deflate, Extra: f.extra}
+		header.SetMode(0644)
+		content := f.content
+		switch {
+		case strings.HasSuffix(f.name, "/"):
+			header.SetMode(fs.ModeDir | 0755)
+		case f.typeflag == tar.TypeSymlink:
+			header.SetMode(fs.ModeSymlink | 0777)
+			content = f.linkname
+		case f.typeflag != 0 && f.typeflag != tar.TypeReg:
+			t.Fatalf("zip cannot hold %s of type %c", f.name, f.typeflag)
+		}
+		w, err := zw.CreateHeader(header)
+		if err == nil {
+			_, err = io.WriteString(w, content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// corruptZipCRC changes the checksum of the named entry in the central
+// directory of a zip, which is the one readers check the content against.
+func corruptZipCRC(t *testing.T, data []byte, name string) []byte {
+	t.Helper()
+	data = append([]byte(nil), data...)
+	for off := 0; ; {
+		i := bytes.Index(data[off:], []byte("PK\x01\x02"))
+		if i < 0 {
+			t.Fatalf("%s not in the central directory", name)
+		}
+		off += i
+		nameLen := int(binary.LittleEndian.Uint16(data[off+28:]))
+		if string(data[off+46:off+46+nameLen]) == name {
+			crc := binary.LittleEndian.Uint32(data[off+16:])
+			binary.LittleEndian.PutUint32(data[off+16:], ^crc)
+			return data
+		}
+		off += 46
+	}
+}
+
+// arFixture returns an ar archive of the files, in order, with the short
+// names of GNU ar.
+func arFixture(t *testing.T, files ...fixture) []byte {
+	t.Helper()
+	buf := bytes.NewBufferString(arMagic)
+	for _, f := range files {
+		if len(f.name) > 15 {
+			t.Fatalf("ar name %s too long", f.name)
+		}
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", f.name+"/", 0, 0, 0, 0100644, len(f.content))
+		buf.WriteString(f.content)
+		if len(f.content)%2 != 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// cpioFixture returns a cpio archive of the fixtures, in order, in the newc
+// format. A symbolic link holds its target as content.
+func cpioFixture(t *testing.T, fixtures ...fixture) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	pad := func() {
+		buf.Write(make([]byte, cpioPad(int64(buf.Len()))))
+	}
+	for i, f := range append(fixtures, fileFixture(cpioTrailer, "")) {
+		mode, name, content := int64(0100644), f.name, f.content
+		switch {
+		case strings.HasSuffix(f.name, "/"):
+			mode, name = 040755, strings.TrimSuffix(f.name, "/")
+		case f.typeflag == tar.TypeSymlink:
+			mode, content = 0120777, f.linkname
+		case f.typeflag != 0 && f.typeflag != tar.TypeReg:
+			t.Fatalf("cpio fixture cannot hold %s of type %c", f.name, f.typeflag)
+		}
+		// ino, mode, uid, gid, nlink, mtime, filesize, devmajor, devminor,
+		// rdevmajor, rdevminor, namesize and check
+		fmt.Fprintf(&buf, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x", cpioNewc,
+			i+1, mode, 0, 0, 1, 0, len(content), 0, 0, 0, 0, len(name)+1, 0)
+		buf.WriteString(name + "\x00")
+		pad()
+		buf.WriteString(content)
+		pad()
+	}
+	return buf.Bytes()
+}
+
+// rpmFixture returns a RPM package whose payload is a cpio archive of the
+// fixtures, compressed with gzip unless compressor is empty. Its lead,
+// signature and header only hold what is needed to read the payload.
+func rpmFixture(t *testing.T, compressor string, fixtures ...fixture) []byte {
+	t.Helper()
+	lead := make([]byte, rpmLeadSize)
+	copy(lead, rpmLeadMagic)
+	buf := bytes.NewBuffer(lead)
+	header := func(tag int32, value string) {
+		var intro [rpmHeaderIntroSize]byte
+		copy(intro[:], rpmHeaderMagic)
+		if value == "" {
+			buf.Write(intro[:])
+			return
+		}
+		binary.BigEndian.PutUint32(intro[8:], 1)
+		binary.BigEndian.PutUint32(intro[12:], uint32(len(value)+1))
+		buf.Write(intro[:])
+		// tag, type, offset and count of the only index entry
+		binary.Write(buf, binary.BigEndian, [4]int32{tag, rpmTypeString, 0, 1})
+		buf.WriteString(value + "\x00")
+	}
+	// the empty signature needs no padding
+	header(0, "")
+	payload := cpioFixture(t, fixtures...)
+	if compressor != "" {
+		header(rpmTagPayloadCompressor, compressor)
+		payload = gzipFixture(t, payload)
+	} else {
+		// rpmbuild leaves out the compressor, the format leaves the payload
+		// unaligned like the headers of real packages
+		header(rpmTagPayloadFormat, "cpio")
+	}
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// rarFixture returns a rar 5 archive of the files, in order, stored without
+// compression. Each file is stored with the checksum crc of its content,
+// that of the content if crc is 0.
+func rarFixture(t *testing.T, crc uint32, files ...fixture) []byte {
+	t.Helper()
+	buf := bytes.NewBufferString("Rar!\x1a\x07\x01\x00")
+	vint := func(b []byte, n uint64) []byte {
+		for ; n >= 0x80; n >>= 7 {
+			b = append(b, byte(n)|0x80)
+		}
+		return append(b, byte(n))
+	}
+	// block writes a header of the given fields preceded by its checksum
+	// and size
+	block := func(fields []byte) {
+		header := append(vint(nil, uint64(len(fields))), fields...)
+		binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(header))
+		buf.Write(header)
+	}
+	// the main header: type 1, no flags
+	block([]byte{1, 0, 0})
+	for _, f := range files {
+		sum := crc
+		if sum == 0 {
+			sum = crc32.ChecksumIEEE([]byte(f.content))
+		}
+		// type 2 with a data area, its size, the file flags telling the
+		// checksum, the unpacked size and the attributes
+		fields := vint([]byte{2, 2}, uint64(len(f.content)))
+		fields = append(fields, 4)
+		fields = vint(fields, uint64(len(f.content)))
+		fields = append(fields, 0)
+		fields = append(fields, byte(sum), byte(sum>>8), byte(sum>>16), byte(sum>>24))
+		// stored by version 0 on Unix
+		fields = append(fields, 0, 1)
+		fields = vint(fields, uint64(len(f.name)))
+		block(append(fields, f.name...))
+		buf.WriteString(f.content)
+	}
+	// the end of archive header
+	block([]byte{5, 0, 0})
+	return buf.Bytes()
+}
+
+// gzipFixture returns data compressed with gzip.
+func gzipFixture(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// openFixture writes data to a file of the given name in a temporary
+// directory and opens it, closing it at the end of the test.
+func openFixture(t *testing.T, name string, data []byte) *httpreader.Reader {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := FileToReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/Heng-Bian/httpreader"
+	"lukechampine.com/blake3"
+)
+
+const (
+	SHA256 = "sha256"
+	SHA1   = "sha1"
+	MD5    = "md5"
+	BLAKE3 = "blake3"
+)
+
+func ListSupportedHash() []string {
+	return []string{SHA256, SHA1, MD5, BLAKE3}
+}
+
+// rarChecksumError is the message of the unexported error rardecode returns
+// at the end of an entry whose content does not match its checksum.
+const rarChecksumError = "rardecode: bad file checksum"
+
+// EntryHash is the digest of the content of an entry.
+type EntryHash struct {
+	Name string
+	// ID is the ID of the entry, see OpenByID
+	ID   string `json:",omitempty"`
+	Size int64
+	// Hashes maps the algorithms to the hex encoded digests
+	Hashes map[string]string
+	// CRC32 is the hex encoded checksum stored by zip
+	CRC32 string `json:",omitempty"`
+	// Verified tells whether the content matches the checksum stored in
+	// the archive, it is nil if the format stores none
+	Verified *bool `json:",omitempty"`
+}
+
+// Hash computes the digests of the entries chosen by sel with the given
+// algorithms while reading them in the order of the archive. The checksums
+// stored by zip and rar are verified along the way, a mismatch is reported
+// in Verified instead of as an error.
+func Hash(format string, r *httpreader.Reader, charset string, sel *Selector, algorithms []string) ([]EntryHash, error) {
+	for _, algorithm := range algorithms {
+		if newHash(algorithm) == nil {
+			return nil, errors.New("do not support hash " + algorithm)
+		}
+	}
+	hashes := make([]EntryHash, 0, 10)
+	err := Walk(format, r, charset, func(entry *Entry, er io.Reader) error {
+		if entry.IsDir || !sel.MatchEntry(entry) {
+			return nil
+		}
+		digests := make([]hash.Hash, len(algorithms))
+		writers := make([]io.Writer, 0, len(algorithms)+1)
+		for i, algorithm := range algorithms {
+			digests[i] = newHash(algorithm)
+			writers = append(writers, digests[i])
+		}
+		crc := crc32.NewIEEE()
+		writers = append(writers, crc)
+		n, err := io.Copy(io.MultiWriter(writers...), er)
+		res := EntryHash{Name: entry.Name, ID: entry.ID, Size: n, Hashes: make(map[string]string, len(algorithms))}
+		switch format {
+		case ZIP_TYPE:
+			if err == zip.ErrChecksum {
+				err = nil
+			}
+			verified := crc.Sum32() == entry.CRC32
+			res.CRC32 = fmt.Sprintf("%08x", entry.CRC32)
+			res.Verified = &verified
+		case RAR_TYPE:
+			verified := true
+			if err != nil && err.Error() == rarChecksumError {
+				verified, err = false, nil
+			}
+			res.Verified = &verified
+		}
+		if err != nil {
+			return fmt.Errorf("fail to read %s,err:%s", entry.Name, err)
+		}
+		for i, algorithm := range algorithms {
+			res.Hashes[algorithm] = hex.EncodeToString(digests[i].Sum(nil))
+		}
+		hashes = append(hashes, res)
+		return nil
+	})
+	return hashes, err
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case SHA256:
+		return sha256.New()
+	case SHA1:
+		return sha1.New()
+	case MD5:
+		return md5.New()
+	case BLAKE3:
+		return blake3.New(32, nil)
+	}
+	return nil
+}
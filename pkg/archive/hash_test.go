@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	files := []fixture{fileFixture("dir/", ""), fileFixture("dir/a.txt", "hello\n"), fileFixture("b.txt", "b")}
+	zipData := zipFixture(t, files...)
+	for _, test := range []struct {
+		name   string
+		format string
+		data   []byte
+		// verified tells the expected Verified of a.txt and b.txt, nil if
+		// the format stores no checksum
+		verified []bool
+	}{
+		{"tar", TAR_TYPE, tarFixture(t, files...), nil},
+		{"zip", ZIP_TYPE, zipData, []bool{true, true}},
+		{"zip with a wrong checksum", ZIP_TYPE, corruptZipCRC(t, zipData, "dir/a.txt"), []bool{false, true}},
+		{"rar", RAR_TYPE, rarFixture(t, 0, files[1:]...), []bool{true, true}},
+		{"rar with wrong checksums", RAR_TYPE, rarFixture(t, 1, files[1:]...), []bool{false, false}},
+	} {
+		hashes, err := Hash(test.format, openFixture(t, "a."+test.format, test.data), "", NamesSelector(nil), []string{SHA256, MD5})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(hashes) != 2 {
+			t.Fatalf("%s: got %+v, want the hashes of the two files", test.name, hashes)
+		}
+		for i, h := range hashes {
+			content := files[i+1].content
+			sha := sha256.Sum256([]byte(content))
+			md := md5.Sum([]byte(content))
+			if h.Name != files[i+1].name || h.Size != int64(len(content)) || h.ID == "" ||
+				h.Hashes[SHA256] != hex.EncodeToString(sha[:]) || h.Hashes[MD5] != hex.EncodeToString(md[:]) {
+				t.Errorf("%s: got %+v for %s", test.name, h, files[i+1].name)
+			}
+			switch {
+			case test.verified == nil && h.Verified != nil:
+				t.Errorf("%s: %s verified without a checksum", test.name, h.Name)
+			case test.verified != nil && (h.Verified == nil || *h.Verified != test.verified[i]):
+				t.Errorf("%s: %s verified %v, want %v", test.name, h.Name, h.Verified, test.verified[i])
+			}
+		}
+		if test.format == ZIP_TYPE && hashes[1].CRC32 != fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("b"))) {
+			t.Errorf("%s: got CRC32 %s of b.txt", test.name, hashes[1].CRC32)
+		}
+	}
+
+	r := openFixture(t, "a.zip", zipData)
+	hashes, err := Hash(ZIP_TYPE, r, "", NamesSelector([]string{"b.txt"}), []string{BLAKE3})
+	if err != nil || len(hashes) != 1 || hashes[0].Name != "b.txt" || len(hashes[0].Hashes[BLAKE3]) != 64 {
+		t.Errorf("got %+v of the selected entry,err:%v", hashes, err)
+	}
+	if _, err := Hash(ZIP_TYPE, r, "", NamesSelector(nil), []string{"md4"}); err == nil {
+		t.Error("hashed with md4")
+	}
+}
@@ -0,0 +1,432 @@
+package archive
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Heng-Bian/httpreader"
+	"github.com/saracen/go7z/headers"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	// zipEncryptedFlag is the general purpose bit of an encrypted zip entry
+	zipEncryptedFlag = 0x1
+	// zipSpanSignature starts the first volume of a split zip archive
+	zipSpanSignature = "PK\x07\x08"
+	zipEndSignature  = "PK\x05\x06"
+	// zipEndLen is the size of the end of central directory record without
+	// the comment
+	zipEndLen = 22
+)
+
+const (
+	sevenZLZMA  = 0x030101
+	sevenZLZMA2 = 0x21
+	sevenZAES   = 0x06F10701
+)
+
+var (
+	rar4Signature = []byte("Rar!\x1a\x07\x00")
+	rar5Signature = []byte("Rar!\x1a\x07\x01\x00")
+)
+
+// ArchiveInfo summarizes an archive from its headers, without reading the
+// content of the entries.
+type ArchiveInfo struct {
+	Entries int
+	Files   int
+	Dirs    int
+	// Size is the total uncompressed size of the entries, -1 if unknown
+	Size int64
+	// CompressedSize is the total size of the compressed entries, -1 if
+	// unknown
+	CompressedSize int64
+	// Ratio is CompressedSize divided by Size, absent if either is unknown
+	Ratio float64 `json:",omitempty"`
+	// Solid tells that entries are compressed together, so that reading one
+	// requires decompressing the ones before it
+	Solid bool
+	// Encrypted tells that entries or headers are encrypted
+	Encrypted bool
+	// MultiVolume tells that the archive is a volume of a split archive
+	MultiVolume bool
+	Comment     string `json:",omitempty"`
+	// Charset is the charset of the entry names, see WalkCharset, empty if
+	// unknown
+	Charset string `json:",omitempty"`
+}
+
+// Info summarizes the archive. Zip is described from its central directory
+// and 7z from its header, tar, rar, ar and cpio headers are walked without
+// reading the content of the entries, which still decompresses solid rar
+// archives and the payloads of deb and rpm. The directories of iso are read
+// for its files, the layers of oci are decompressed to merge them.
+func Info(format string, r *httpreader.Reader, charset string) (*ArchiveInfo, error) {
+	info := &ArchiveInfo{Size: -1, CompressedSize: -1}
+	var err error
+	switch format {
+	case ZIP_TYPE:
+		err = zipInfo(r, charset, info)
+	case TAR_TYPE:
+		err = tarInfo(r, charset, info)
+	case RAR_TYPE:
+		err = rarInfo(r, info)
+	case SEVEN_Z_TYPE:
+		err = sevenZInfo(r, info)
+	case AR_TYPE, DEB_TYPE, CPIO_TYPE, RPM_TYPE:
+		err = packageInfo(format, r, info)
+	case ISO_TYPE:
+		err = isoInfo(r, info)
+	case OCI_TYPE:
+		err = ociInfo(r, info)
+	default:
+		return nil, errors.New("do not support " + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size > 0 && info.CompressedSize >= 0 {
+		info.Ratio = float64(info.CompressedSize) / float64(info.Size)
+	}
+	return info, nil
+}
+
+func zipInfo(r *httpreader.Reader, charset string, info *ArchiveInfo) error {
+	info.MultiVolume = bytes.HasPrefix(r.HeadBytes, []byte(zipSpanSignature))
+	if disk, err := zipEndDisk(r); err == nil && disk != 0 {
+		info.MultiVolume = true
+	}
+	zipReader, err := zip.NewReader(r, r.Length)
+	if err != nil {
+		if info.MultiVolume {
+			// the central directory refers to other volumes
+			return nil
+		}
+		return err
+	}
+	info.Size, info.CompressedSize = 0, 0
+	_, info.Charset = zipNames(zipReader.File, charset)
+	for _, file := range zipReader.File {
+		info.Entries++
+		if strings.HasSuffix(file.Name, "/") {
+			info.Dirs++
+		} else {
+			info.Files++
+		}
+		info.Size += int64(file.UncompressedSize64)
+		info.CompressedSize += int64(file.CompressedSize64)
+		if file.Flags&zipEncryptedFlag != 0 {
+			info.Encrypted = true
+		}
+	}
+	info.Comment = zipReader.Comment
+	if charset == "" && !utf8.ValidString(info.Comment) {
+		// the comment is in the charset of the names if they have one
+		charset = info.Charset
+		if charset == UTF8 {
+			charset = DetectCharset([]string{info.Comment}, IBM437)
+		}
+	}
+	if charset != "" {
+		if comment, err := DecodeString(info.Comment, charset); err == nil {
+			info.Comment = comment
+		}
+	}
+	return nil
+}
+
+// zipEndDisk returns the number of the volume holding the end of central
+// directory record, 0 unless the archive is the last volume of a split
+// archive.
+func zipEndDisk(r *httpreader.Reader) (uint16, error) {
+	tail, _, i, err := readZipEnd(r)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(tail[i+4:]), nil
+}
+
+func tarInfo(r *httpreader.Reader, charset string, info *ArchiveInfo) error {
+	entries, detected, err := ListEntriesCharset(TAR_TYPE, r, charset)
+	if err != nil {
+		return err
+	}
+	info.Size = 0
+	info.Charset = detected
+	for _, entry := range entries {
+		countEntry(info, &entry)
+	}
+	// tar does not compress
+	info.CompressedSize = info.Size
+	return nil
+}
+
+func rarInfo(r *httpreader.Reader, info *ArchiveInfo) error {
+	rarHeadFlags(r.HeadBytes, info)
+	info.Charset = UTF8
+	if info.Encrypted {
+		// the headers cannot be read without the password
+		return nil
+	}
+	info.Size = 0
+	err := WalkRar(r, func(entry *Entry, _ io.Reader) error {
+		countEntry(info, entry)
+		return nil
+	})
+	if err != nil && info.MultiVolume && info.Entries != 0 {
+		// the last entry continues in the next volume
+		return nil
+	}
+	return err
+}
+
+// rarHeadFlags reads the flags of the main header of a rar archive starting
+// with its signature.
+func rarHeadFlags(head []byte, info *ArchiveInfo) {
+	const (
+		rar4Volume    = 0x0001
+		rar4Solid     = 0x0008
+		rar4Encrypted = 0x0080
+		rar5Volume    = 0x0001
+		rar5Solid     = 0x0004
+		// header types of rar 5
+		rar5Main    = 1
+		rar5Encrypt = 4
+	)
+	if bytes.HasPrefix(head, rar4Signature) {
+		// crc, type, flags
+		block := head[len(rar4Signature):]
+		if len(block) < 5 {
+			return
+		}
+		flags := binary.LittleEndian.Uint16(block[3:])
+		info.MultiVolume = flags&rar4Volume != 0
+		info.Solid = flags&rar4Solid != 0
+		info.Encrypted = flags&rar4Encrypted != 0
+		return
+	}
+	if !bytes.HasPrefix(head, rar5Signature) || len(head) < len(rar5Signature)+4 {
+		return
+	}
+	// crc, size, type, flags, optional extra and data size, archive flags
+	br := bytes.NewReader(head[len(rar5Signature)+4:])
+	var fields [3]uint64
+	for i := range fields {
+		value, err := binary.ReadUvarint(br)
+		if err != nil {
+			return
+		}
+		fields[i] = value
+	}
+	headerType, headerFlags := fields[1], fields[2]
+	if headerType == rar5Encrypt {
+		info.Encrypted = true
+		return
+	}
+	if headerType != rar5Main {
+		return
+	}
+	for _, present := range []bool{headerFlags&0x1 != 0, headerFlags&0x2 != 0} {
+		if present {
+			if _, err := binary.ReadUvarint(br); err != nil {
+				return
+			}
+		}
+	}
+	archiveFlags, err := binary.ReadUvarint(br)
+	if err != nil {
+		return
+	}
+	info.MultiVolume = archiveFlags&rar5Volume != 0
+	info.Solid = archiveFlags&rar5Solid != 0
+}
+
+func sevenZInfo(r *httpreader.Reader, info *ArchiveInfo) error {
+	header, err := readSevenZHeader(r, info)
+	if err != nil || info.MultiVolume || info.Encrypted && header == nil {
+		return err
+	}
+	if header == nil {
+		return walkSevenZInfo(r, info)
+	}
+	info.Charset = UTF8
+	info.CompressedSize = 0
+	if streams := header.MainStreamsInfo; streams != nil {
+		if streams.PackInfo != nil {
+			for _, packSize := range streams.PackInfo.PackSizes {
+				info.CompressedSize += int64(packSize)
+			}
+		}
+		if streams.UnpackInfo != nil {
+			for _, folder := range streams.UnpackInfo.Folders {
+				if sevenZEncrypted(folder) {
+					info.Encrypted = true
+				}
+			}
+		}
+		if streams.SubStreamsInfo != nil {
+			for _, count := range streams.SubStreamsInfo.NumUnpackStreamsInFolders {
+				if count > 1 {
+					info.Solid = true
+				}
+			}
+		}
+	}
+	info.Size = 0
+	sizes := sevenZSizes(header)
+	for i, file := range header.FilesInfo {
+		countEntry(info, &Entry{IsDir: file.Attrib&sevenZDirAttrib != 0, Size: sizes[i]})
+	}
+	return nil
+}
+
+// readSevenZHeader reads the header of a 7z archive, which go7z does not
+// expose. It returns a nil header and sets MultiVolume of info if the header
+// is in another volume, and returns a nil header if decodeSevenZHeader does
+// not decode it.
+func readSevenZHeader(r *httpreader.Reader, info *ArchiveInfo) (*headers.Header, error) {
+	head := make([]byte, headers.SignatureHeaderSize)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && (err != io.EOF || n != len(head)) {
+		return nil, err
+	}
+	signature, err := headers.ReadSignatureHeader(bytes.NewReader(head))
+	if err != nil {
+		return nil, err
+	}
+	start := headers.SignatureHeaderSize + signature.StartHeader.NextHeaderOffset
+	size := signature.StartHeader.NextHeaderSize
+	if start+size > r.Length {
+		// the header is in the last volume
+		info.MultiVolume = true
+		return nil, nil
+	}
+	if size == 0 {
+		// an archive without entries has no header
+		return &headers.Header{}, nil
+	}
+	header, encoded, err := headers.ReadPackedStreamsForHeaders(&io.LimitedReader{
+		R: bufio.NewReader(io.NewSectionReader(r, start, size)),
+		N: size,
+	})
+	if err != nil || encoded == nil {
+		return header, err
+	}
+	return decodeSevenZHeader(r, encoded, info)
+}
+
+// sevenZSizes returns the unpacked size of every file of a 7z header in the
+// order of the files, -1 for the files the header has no stream for.
+func sevenZSizes(header *headers.Header) []int64 {
+	// the sizes of the entries with content in the order of the archive
+	var streams []uint64
+	if info := header.MainStreamsInfo; info != nil && info.UnpackInfo != nil {
+		if info.SubStreamsInfo != nil {
+			streams = info.SubStreamsInfo.UnpackSizes
+		} else {
+			for _, folder := range info.UnpackInfo.Folders {
+				streams = append(streams, folder.UnpackSize())
+			}
+		}
+	}
+	sizes := make([]int64, len(header.FilesInfo))
+	for i, file := range header.FilesInfo {
+		switch {
+		case file.IsEmptyStream:
+		case len(streams) == 0:
+			sizes[i] = -1
+		default:
+			sizes[i] = int64(streams[0])
+			streams = streams[1:]
+		}
+	}
+	return sizes
+}
+
+// decodeSevenZHeader decompresses the header of a 7z archive compressed
+// with LZMA, as 7-Zip does by default, or with LZMA2. It returns a nil
+// header if the header is encrypted or compressed otherwise.
+func decodeSevenZHeader(r *httpreader.Reader, encoded *headers.StreamsInfo, info *ArchiveInfo) (*headers.Header, error) {
+	if encoded.PackInfo == nil || len(encoded.PackInfo.PackSizes) == 0 ||
+		encoded.UnpackInfo == nil || len(encoded.UnpackInfo.Folders) != 1 {
+		return nil, nil
+	}
+	folder := encoded.UnpackInfo.Folders[0]
+	if sevenZEncrypted(folder) {
+		info.Encrypted = true
+		return nil, nil
+	}
+	if len(folder.CoderInfo) != 1 {
+		return nil, nil
+	}
+	coder := folder.CoderInfo[0]
+	packed := bufio.NewReader(io.NewSectionReader(r, headers.SignatureHeaderSize+int64(encoded.PackInfo.PackPos), int64(encoded.PackInfo.PackSizes[0])))
+	var decoded io.Reader
+	var err error
+	switch coder.CodecID {
+	case sevenZLZMA:
+		// the lzma reader expects the properties and the size in front of
+		// the stream
+		lzmaHeader := bytes.NewBuffer(append([]byte{}, coder.Properties...))
+		binary.Write(lzmaHeader, binary.LittleEndian, folder.UnpackSize())
+		decoded, err = lzma.NewReader(io.MultiReader(lzmaHeader, packed))
+	case sevenZLZMA2:
+		config := lzma.Reader2Config{}
+		if len(coder.Properties) > 0 {
+			// the dictionary size is encoded in one byte, as go7z reads it
+			config.DictCap = int(2|coder.Properties[0]&1) << (coder.Properties[0]>>1 + 11)
+		}
+		decoded, err = config.NewReader2(packed)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := headers.ReadPackedStreamsForHeaders(&io.LimitedReader{
+		R: bufio.NewReader(decoded),
+		N: int64(folder.UnpackSize()),
+	})
+	return header, err
+}
+
+// walkSevenZInfo counts the entries of a 7z archive whose header cannot be
+// decoded by decodeSevenZHeader, decompressing the whole archive.
+func walkSevenZInfo(r *httpreader.Reader, info *ArchiveInfo) error {
+	info.Charset = UTF8
+	info.Size = 0
+	return Walk7z(r, func(entry *Entry, _ io.Reader) error {
+		countEntry(info, entry)
+		return nil
+	})
+}
+
+func sevenZEncrypted(folder *headers.Folder) bool {
+	for _, coder := range folder.CoderInfo {
+		if coder.CodecID == sevenZAES {
+			return true
+		}
+	}
+	return false
+}
+
+func countEntry(info *ArchiveInfo, entry *Entry) {
+	info.Entries++
+	if entry.IsDir {
+		info.Dirs++
+		return
+	}
+	info.Files++
+	if info.Size >= 0 && entry.Size >= 0 {
+		info.Size += entry.Size
+	} else {
+		info.Size = -1
+	}
+}
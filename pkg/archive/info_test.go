@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	files := []fixture{fileFixture("dir/", ""), fileFixture("dir/a.txt", "hello hello hello\n"), fileFixture("b.txt", "b")}
+	zipData := zipFixture(t, files...)
+	for _, test := range []struct {
+		name   string
+		format string
+		data   []byte
+		want   ArchiveInfo
+	}{
+		{"tar", TAR_TYPE, tarFixture(t, files...), ArchiveInfo{Entries: 3, Files: 2, Dirs: 1, Size: 19, CompressedSize: 19, Ratio: 1, Charset: UTF8}},
+		{"rar", RAR_TYPE, rarFixture(t, 0, files[1:]...), ArchiveInfo{Entries: 2, Files: 2, Size: 19, CompressedSize: -1, Charset: UTF8}},
+		{"cpio", CPIO_TYPE, cpioFixture(t, files...), ArchiveInfo{Entries: 3, Files: 2, Dirs: 1, Size: 19, CompressedSize: 19, Ratio: 1, Charset: UTF8}},
+		{"rpm", RPM_TYPE, rpmFixture(t, "gzip", files...), ArchiveInfo{Entries: 3, Files: 2, Dirs: 1, Size: 19, CompressedSize: -1, Solid: true, Charset: UTF8}},
+	} {
+		info, err := Info(test.format, openFixture(t, "a."+test.format, test.data), "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(*info, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *info, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		name        string
+		data        []byte
+		multiVolume bool
+	}{
+		{"zip", zipData, false},
+		{"first volume of a split zip", append([]byte(zipSpanSignature), zipData...), true},
+	} {
+		info, err := Info(ZIP_TYPE, openFixture(t, "a.zip", test.data), "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if info.Entries != 3 || info.Files != 2 || info.Dirs != 1 || info.Size != 19 ||
+			info.CompressedSize <= 0 || info.Ratio != float64(info.CompressedSize)/19 || info.MultiVolume != test.multiVolume {
+			t.Errorf("%s: got %+v", test.name, *info)
+		}
+	}
+}
+
+func TestRarHeadFlags(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		head []byte
+		want ArchiveInfo
+	}{
+		// crc, type, flags of rar 4
+		{"rar 4 solid volume", append(append([]byte(nil), rar4Signature...), 0, 0, 0x73, 0x09, 0), ArchiveInfo{Solid: true, MultiVolume: true}},
+		{"rar 4 encrypted", append(append([]byte(nil), rar4Signature...), 0, 0, 0x73, 0x80, 0), ArchiveInfo{Encrypted: true}},
+		// crc, size, type, flags and archive flags of rar 5
+		{"rar 5 solid", append(append([]byte(nil), rar5Signature...), 0, 0, 0, 0, 3, 1, 0, 4), ArchiveInfo{Solid: true}},
+		{"rar 5 volume", append(append([]byte(nil), rar5Signature...), 0, 0, 0, 0, 3, 1, 0, 1), ArchiveInfo{MultiVolume: true}},
+		{"rar 5 encrypted headers", append(append([]byte(nil), rar5Signature...), 0, 0, 0, 0, 3, 4, 0, 0), ArchiveInfo{Encrypted: true}},
+		{"rar 5 file", rarFixture(t, 0, fileFixture("a.txt", "a")), ArchiveInfo{}},
+	} {
+		var info ArchiveInfo
+		rarHeadFlags(test.head, &info)
+		if info != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, info, test.want)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files []fixture
+		want  *DocumentInfo
+	}{
+		{"plain zip", []fixture{fileFixture("a.txt", "a")}, nil},
+		{"jar", []fixture{
+			fileFixture("META-INF/MANIFEST.MF", "Manifest-Version: 1.0\r\nMain-Class: com.example.Ma\r\n in\r\n\r\nName: a/b.class\r\n"),
+		}, &DocumentInfo{Type: DocumentJAR, Source: "META-INF/MANIFEST.MF", Properties: map[string]string{
+			"Manifest-Version": "1.0", "Main-Class": "com.example.Main",
+		}}},
+		{"apk", []fixture{
+			fileFixture("AndroidManifest.xml", "\x03\x00\x08\x00"),
+			fileFixture("META-INF/MANIFEST.MF", "Manifest-Version: 1.0\n"),
+		}, &DocumentInfo{Type: DocumentAPK}},
+		{"wheel", []fixture{
+			fileFixture("a/__init__.py", ""),
+			fileFixture("a-1.0.dist-info/METADATA", "Name: a\nVersion: 1.0\nClassifier: x\nClassifier: y\n\nName: description\n"),
+		}, &DocumentInfo{Type: DocumentWheel, Source: "a-1.0.dist-info/METADATA", Properties: map[string]string{
+			"Name": "a", "Version": "1.0", "Classifier": "x, y",
+		}}},
+		{"nupkg", []fixture{
+			fileFixture("a.nuspec", `<package><metadata><id>a</id><version>1.0</version><dependencies><group/></dependencies></metadata></package>`),
+		}, &DocumentInfo{Type: DocumentNuGet, Source: "a.nuspec", Properties: map[string]string{"id": "a", "version": "1.0"}}},
+		{"epub", []fixture{
+			fileFixture("mimetype", "application/epub+zip"),
+			fileFixture("META-INF/container.xml", `<container><rootfiles><rootfile full-path="OEBPS/content.opf"/></rootfiles></container>`),
+			fileFixture("OEBPS/content.opf", `<package><metadata><dc:title>A</dc:title><dc:creator>B</dc:creator><dc:creator>C</dc:creator>`+
+				`<meta property="dcterms:modified">2020-01-01</meta></metadata></package>`),
+		}, &DocumentInfo{Type: DocumentEPUB, ContentType: "application/epub+zip", Source: "OEBPS/content.opf", Properties: map[string]string{
+			"title": "A", "creator": "B, C", "dcterms:modified": "2020-01-01",
+		}}},
+		{"ooxml in Latin-1", []fixture{
+			fileFixture("[Content_Types].xml", "<Types/>"),
+			fileFixture("docProps/core.xml", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><cp:coreProperties><dc:title>Caf\xe9</dc:title></cp:coreProperties>"),
+		}, &DocumentInfo{Type: DocumentOOXML, Source: "docProps/core.xml", Properties: map[string]string{"title": "Café"}}},
+		{"odf", []fixture{
+			fileFixture("mimetype", "application/vnd.oasis.opendocument.text"),
+			fileFixture("meta.xml", `<office:document-meta><office:meta><dc:title>A</dc:title></office:meta></office:document-meta>`),
+		}, &DocumentInfo{Type: DocumentODF, ContentType: "application/vnd.oasis.opendocument.text", Source: "meta.xml", Properties: map[string]string{"title": "A"}}},
+	} {
+		doc, err := Document(openFixture(t, "a.zip", zipFixture(t, test.files...)))
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(doc, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, doc, test.want)
+		}
+	}
+
+	broken := zipFixture(t, fileFixture("a.nuspec", "<package><metadata>"))
+	if doc, err := Document(openFixture(t, "a.zip", broken)); err == nil {
+		t.Errorf("got %+v of a broken nuspec", doc)
+	}
+	if _, err := Document(openFixture(t, "a.zip", bytes.Repeat([]byte("x"), 100))); err == nil {
+		t.Error("read a document from a file that is not a zip")
+	}
+}
@@ -0,0 +1,739 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	isoSectorSize = 2048
+	// the volume descriptors start at sector 16, after the system area
+	isoDescriptorStart = 16 * isoSectorSize
+	isoMagic           = "CD001"
+
+	isoPrimary       = 1
+	isoSupplementary = 2
+	isoTerminator    = 255
+	// isoMaxDescriptors bounds the volume descriptors read before the
+	// terminator
+	isoMaxDescriptors = 64
+	// isoRootRecord is the offset of the root directory record in a volume
+	// descriptor
+	isoRootRecord = 156
+
+	// isoRecordHeaderSize is the size of a directory record before the name
+	isoRecordHeaderSize = 33
+	isoFlagDir          = 0x02
+	isoFlagMultiExtent  = 0x80
+
+	// isoMaxDirectory bounds the size of a directory read in memory
+	isoMaxDirectory = 16 << 20
+	// isoMaxDepth bounds the nesting of directories
+	isoMaxDepth = 256
+	// isoMaxContinuations bounds the continuation areas of the system use
+	// entries of a record
+	isoMaxContinuations = 32
+)
+
+// the escape sequences of the UCS-2 levels of Joliet
+var jolietEscapes = []string{"%/@", "%/C", "%/E"}
+
+// isoImage reads the directory hierarchy of an ISO 9660 image. Names are
+// those of the Rock Ridge extensions if the primary hierarchy has them,
+// else those of the Joliet hierarchy if any, else the primary ones.
+type isoImage struct {
+	r    *httpreader.Reader
+	root *isoRecord
+	// volume is the volume identifier of the primary volume descriptor
+	volume string
+	joliet bool
+	// rockRidge tells that the system use areas of the records hold Rock
+	// Ridge names and attributes, after suspSkip bytes
+	rockRidge bool
+	suspSkip  int
+}
+
+// isoExtent is a part of the content of a file, only large files have more
+// than one.
+type isoExtent struct {
+	offset int64
+	size   int64
+}
+
+// isoRecord is a directory record, with the Rock Ridge attributes of its
+// system use area.
+type isoRecord struct {
+	// offset is the offset of the record in the image
+	offset  int64
+	extents []isoExtent
+	size    int64
+	flags   byte
+	name    string
+	modTime time.Time
+
+	rockRidge bool
+	// suspSkip is given by the SP entry of the root directory
+	suspSkip int
+	// mode, uid and gid are given by PX, mode is 0 without it
+	mode     int64
+	uid, gid int
+	linkname string
+	// child is the offset of the directory relocated by Rock Ridge that the
+	// record stands for, 0 if none
+	child int64
+	// relocated tells that the directory is listed in place of a record
+	// naming it as child
+	relocated bool
+}
+
+func openIso(r *httpreader.Reader) (*isoImage, error) {
+	var primary, joliet []byte
+	for i := int64(0); i < isoMaxDescriptors; i++ {
+		descriptor, err := readIsoAt(r, isoDescriptorStart+i*isoSectorSize, isoSectorSize)
+		if err != nil || string(descriptor[1:6]) != isoMagic {
+			if i == 0 {
+				return nil, errors.New("not an iso image")
+			}
+			break
+		}
+		if descriptor[0] == isoTerminator {
+			break
+		}
+		switch descriptor[0] {
+		case isoPrimary:
+			if primary == nil {
+				primary = descriptor
+			}
+		case isoSupplementary:
+			for _, escape := range jolietEscapes {
+				if bytes.Contains(descriptor[88:120], []byte(escape)) && joliet == nil {
+					joliet = descriptor
+				}
+			}
+		}
+	}
+	if primary == nil {
+		return nil, errors.New("no primary volume descriptor in iso image")
+	}
+	img := &isoImage{r: r, volume: strings.TrimRight(string(primary[40:72]), " ")}
+	root, err := img.parseRecord(primary[isoRootRecord:], isoDescriptorStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root directory of iso image,err:%s", err)
+	}
+	img.root = root
+	// the SP entry of the first record of the root directory tells that
+	// the records have system use entries
+	sector, err := readIsoAt(r, root.extents[0].offset, isoSectorSize)
+	if err != nil {
+		return nil, err
+	}
+	img.rockRidge = true
+	dot, err := img.parseRecord(sector, root.extents[0].offset)
+	img.rockRidge = err == nil && dot.rockRidge
+	if img.rockRidge {
+		img.suspSkip = dot.suspSkip
+		return img, nil
+	}
+	if joliet != nil {
+		img.joliet = true
+		if img.root, err = img.parseRecord(joliet[isoRootRecord:], isoDescriptorStart); err != nil {
+			return nil, fmt.Errorf("invalid joliet root directory of iso image,err:%s", err)
+		}
+	}
+	return img, nil
+}
+
+// udfBeginning, udfNSR02, udfNSR03 and udfBoot are the standard identifiers
+// of the volume recognition sequence of UDF (ECMA-167), which starts at
+// sector 16 like the descriptors of ISO 9660.
+const (
+	udfBeginning = "BEA01"
+	udfNSR02     = "NSR02"
+	udfNSR03     = "NSR03"
+	udfBoot      = "BOOT2"
+)
+
+// isUdf reports whether the volume recognition sequence of r has a UDF
+// descriptor but no ISO 9660 one. UDF bridge images, which have both, are
+// read as ISO 9660.
+func isUdf(r io.ReaderAt) bool {
+	id := make([]byte, len(udfBeginning))
+	udf := false
+	for i := int64(0); i < isoMaxDescriptors; i++ {
+		n, _ := r.ReadAt(id, isoDescriptorStart+i*isoSectorSize+1)
+		if n != len(id) {
+			break
+		}
+		switch string(id) {
+		case isoMagic:
+			return false
+		case udfNSR02, udfNSR03:
+			udf = true
+		case udfBeginning, udfBoot:
+		default:
+			// the terminating descriptor TEA01 or the end of the sequence
+			return udf
+		}
+	}
+	return udf
+}
+
+// isIso tells if r holds the volume descriptors of an ISO 9660 image.
+func isIso(r io.ReaderAt) bool {
+	magic := make([]byte, len(isoMagic))
+	n, _ := r.ReadAt(magic, isoDescriptorStart+1)
+	return n == len(magic) && string(magic) == isoMagic
+}
+
+func readIsoAt(r *httpreader.Reader, off int64, size int64) ([]byte, error) {
+	if off < 0 || size < 0 || off+size > r.Length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(io.NewSectionReader(r, off, size), buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// parseRecord parses the directory record at the start of buf, whose offset
+// in the image is off.
+func (img *isoImage) parseRecord(buf []byte, off int64) (*isoRecord, error) {
+	if len(buf) < isoRecordHeaderSize+1 || int(buf[0]) < isoRecordHeaderSize+1 || int(buf[0]) > len(buf) {
+		return nil, fmt.Errorf("invalid iso directory record at %d", off)
+	}
+	buf = buf[:buf[0]]
+	nameLen := int(buf[32])
+	if isoRecordHeaderSize+nameLen > len(buf) {
+		return nil, fmt.Errorf("invalid iso directory record at %d", off)
+	}
+	// the extended attribute record precedes the content
+	extent := int64(binary.LittleEndian.Uint32(buf[2:])) + int64(buf[1])
+	size := int64(binary.LittleEndian.Uint32(buf[10:]))
+	if extent*isoSectorSize+size > img.r.Length {
+		return nil, fmt.Errorf("iso directory record at %d out of the image", off)
+	}
+	rec := &isoRecord{
+		offset:  off,
+		extents: []isoExtent{{offset: extent * isoSectorSize, size: size}},
+		size:    size,
+		flags:   buf[25],
+		name:    isoName(buf[33:33+nameLen], img.joliet),
+		modTime: isoTime(buf[18:25]),
+	}
+	// the system use area follows the name padded to an even length
+	su := isoRecordHeaderSize + nameLen + (nameLen+1)%2
+	if img.rockRidge {
+		if su+img.suspSkip < len(buf) {
+			if err := img.readSystemUse(buf[su+img.suspSkip:], rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rec, nil
+}
+
+// isoName decodes the name of a record, "." and ".." are named by a single
+// byte 0 and 1. The version of files, eg. ";1", is removed.
+func isoName(name []byte, joliet bool) string {
+	if len(name) == 1 && name[0] <= 1 {
+		return strings.Repeat(".", int(name[0])+1)
+	}
+	s := string(name)
+	if joliet {
+		units := make([]uint16, len(name)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(name[2*i:])
+		}
+		s = string(utf16.Decode(units))
+	}
+	if i := strings.LastIndex(s, ";"); i >= 0 {
+		s = s[:i]
+	}
+	if !joliet {
+		// names without extension end with "."
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
+
+// isoTime decodes the 7 bytes date of a record: the years since 1900, month,
+// day, hour, minute, second and the offset from UTC in 15 minutes.
+func isoTime(b []byte) time.Time {
+	if len(b) < 7 || b[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, zone)
+}
+
+// isoLongTime decodes the 17 bytes date of a volume descriptor, as digits of
+// the year, month, day, hour, minute, second and hundredths followed by the
+// offset from UTC in 15 minutes.
+func isoLongTime(b []byte) time.Time {
+	if len(b) < 17 {
+		return time.Time{}
+	}
+	var fields [7]int
+	widths := [7]int{4, 2, 2, 2, 2, 2, 2}
+	digits := b
+	for i, width := range widths {
+		n, err := strconv.Atoi(string(digits[:width]))
+		if err != nil {
+			return time.Time{}
+		}
+		fields[i] = n
+		digits = digits[width:]
+	}
+	if fields[1] == 0 {
+		return time.Time{}
+	}
+	zone := time.FixedZone("", int(int8(b[16]))*15*60)
+	return time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], fields[6]*10_000_000, zone)
+}
+
+// readSystemUse reads the SUSP entries of a record and of their continuation
+// areas.
+func (img *isoImage) readSystemUse(su []byte, rec *isoRecord) error {
+	var name, target strings.Builder
+	hasName, slash := false, false
+	for areas := 0; su != nil; areas++ {
+		if areas > isoMaxContinuations {
+			return fmt.Errorf("too many continuations of iso directory record at %d", rec.offset)
+		}
+		var next []byte
+	entries:
+		for len(su) >= 4 {
+			sig, length := string(su[:2]), int(su[2])
+			if length < 4 || length > len(su) {
+				break
+			}
+			data := su[4:length]
+			su = su[length:]
+			switch sig {
+			case "SP":
+				if len(data) >= 3 && data[0] == 0xbe && data[1] == 0xef {
+					rec.suspSkip = int(data[2])
+				}
+			case "CE":
+				if len(data) < 24 {
+					continue
+				}
+				block := int64(binary.LittleEndian.Uint32(data))
+				off := int64(binary.LittleEndian.Uint32(data[8:]))
+				size := int64(binary.LittleEndian.Uint32(data[16:]))
+				if size > isoSectorSize {
+					return fmt.Errorf("invalid continuation of iso directory record at %d", rec.offset)
+				}
+				area, err := readIsoAt(img.r, block*isoSectorSize+off, size)
+				if err != nil {
+					return err
+				}
+				next = area
+			case "ST":
+				break entries
+			case "RR", "ER", "PN":
+				rec.rockRidge = true
+			case "PX":
+				rec.rockRidge = true
+				if len(data) >= 32 {
+					rec.mode = int64(binary.LittleEndian.Uint32(data))
+					rec.uid = int(binary.LittleEndian.Uint32(data[16:]))
+					rec.gid = int(binary.LittleEndian.Uint32(data[24:]))
+				}
+			case "NM":
+				rec.rockRidge = true
+				// the names of "." and ".." are flagged without content
+				if len(data) >= 1 && data[0]&0x06 == 0 {
+					name.Write(data[1:])
+					hasName = true
+				}
+			case "SL":
+				rec.rockRidge = true
+				if len(data) >= 1 {
+					slash = readSymlink(data[1:], &target, slash)
+				}
+			case "TF":
+				rec.rockRidge = true
+				readTimestamps(data, rec)
+			case "CL":
+				rec.rockRidge = true
+				if len(data) >= 4 {
+					rec.child = int64(binary.LittleEndian.Uint32(data)) * isoSectorSize
+				}
+			case "RE":
+				rec.rockRidge = true
+				rec.relocated = true
+			}
+		}
+		su = next
+	}
+	if hasName {
+		rec.name = name.String()
+	}
+	rec.linkname = target.String()
+	return nil
+}
+
+// readSymlink appends the components of a SL entry to the target of a
+// symbolic link. slash tells that a "/" separates the next component, the
+// last component of a SL entry may continue in the next one.
+func readSymlink(components []byte, target *strings.Builder, slash bool) bool {
+	for len(components) >= 2 {
+		flags, length := components[0], int(components[1])
+		if 2+length > len(components) {
+			break
+		}
+		content := components[2 : 2+length]
+		components = components[2+length:]
+		var text string
+		switch {
+		case flags&0x08 != 0:
+			target.WriteString("/")
+			slash = false
+			continue
+		case flags&0x02 != 0:
+			text = "."
+		case flags&0x04 != 0:
+			text = ".."
+		default:
+			text = string(content)
+		}
+		if slash {
+			target.WriteString("/")
+		}
+		target.WriteString(text)
+		slash = flags&0x01 == 0
+	}
+	return slash
+}
+
+// readTimestamps reads the modification time from a TF entry, which lists
+// the times flagged in its first byte in order: creation, modification,
+// access and so on, in the 17 bytes form if flagged with 0x80.
+func readTimestamps(data []byte, rec *isoRecord) {
+	if len(data) < 1 {
+		return
+	}
+	flags := data[0]
+	size := 7
+	if flags&0x80 != 0 {
+		size = 17
+	}
+	stamps := data[1:]
+	if flags&0x01 != 0 {
+		// the creation time precedes the modification time
+		if len(stamps) < size {
+			return
+		}
+		stamps = stamps[size:]
+	}
+	if flags&0x02 == 0 || len(stamps) < size {
+		return
+	}
+	if size == 17 {
+		rec.modTime = isoLongTime(stamps)
+	} else {
+		rec.modTime = isoTime(stamps)
+	}
+}
+
+// readDirectory returns the records of the directory dir without "." and
+// "..". The extents of a file larger than 4 GiB are joined into the first
+// record.
+func (img *isoImage) readDirectory(dir *isoRecord) ([]*isoRecord, error) {
+	if dir.size > isoMaxDirectory {
+		return nil, fmt.Errorf("iso directory %s is larger than %d bytes", dir.name, isoMaxDirectory)
+	}
+	start := dir.extents[0].offset
+	buf, err := readIsoAt(img.r, start, dir.size)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read iso directory %s,err:%s", dir.name, err)
+	}
+	var records []*isoRecord
+	// multi is the file whose next extent is expected
+	var multi *isoRecord
+	for i := 0; i < len(buf); {
+		if buf[i] == 0 {
+			// records do not cross sectors, the rest of a sector is zero
+			i = (i/isoSectorSize + 1) * isoSectorSize
+			continue
+		}
+		end := (i/isoSectorSize + 1) * isoSectorSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		rec, err := img.parseRecord(buf[i:end], start+int64(i))
+		if err != nil {
+			return nil, err
+		}
+		i += int(buf[i])
+		switch {
+		case rec.name == "." || rec.name == "..":
+			continue
+		case multi != nil && rec.name == multi.name:
+			multi.extents = append(multi.extents, rec.extents...)
+			multi.size += rec.size
+		default:
+			records = append(records, rec)
+			multi = rec
+		}
+		if rec.flags&isoFlagMultiExtent == 0 {
+			multi = nil
+		}
+	}
+	return records, nil
+}
+
+// resolve turns a record standing for a directory relocated by Rock Ridge
+// into that directory, whose size and attributes are given by its "."
+// record.
+func (img *isoImage) resolve(rec *isoRecord) error {
+	if rec.child == 0 {
+		return nil
+	}
+	sector, err := readIsoAt(img.r, rec.child, isoSectorSize)
+	if err != nil {
+		return err
+	}
+	dot, err := img.parseRecord(sector, rec.child)
+	if err != nil {
+		return err
+	}
+	rec.extents, rec.size = dot.extents, dot.size
+	rec.mode, rec.uid, rec.gid = dot.mode, dot.uid, dot.gid
+	rec.flags |= isoFlagDir
+	rec.child = 0
+	return nil
+}
+
+func (rec *isoRecord) isDir() bool {
+	return rec.flags&isoFlagDir != 0
+}
+
+func (rec *isoRecord) entry(name string, id string) *Entry {
+	entry := &Entry{
+		Name:     name,
+		Size:     rec.size,
+		ModTime:  rec.modTime,
+		IsDir:    rec.isDir(),
+		Uid:      rec.uid,
+		Gid:      rec.gid,
+		Linkname: rec.linkname,
+		ID:       id,
+	}
+	switch {
+	case rec.mode != 0:
+		entry.Mode = unixMode(rec.mode)
+	case entry.IsDir:
+		entry.Mode = fs.ModeDir | 0555
+	default:
+		entry.Mode = 0444
+	}
+	if entry.IsDir || entry.Linkname != "" {
+		entry.Size = 0
+	}
+	return entry
+}
+
+// content reads the content of a file from its extents.
+func (rec *isoRecord) content(r *httpreader.Reader) io.Reader {
+	if rec.isDir() || rec.linkname != "" {
+		return bytes.NewReader(nil)
+	}
+	readers := make([]io.Reader, 0, len(rec.extents))
+	for _, extent := range rec.extents {
+		readers = append(readers, io.NewSectionReader(r, extent.offset, extent.size))
+	}
+	return io.MultiReader(readers...)
+}
+
+// walk calls fn for every entry of the image, each directory followed by
+// its entries. Entries are identified by the offsets of their records, the
+// files made of several extents by their indexes.
+func (img *isoImage) walk(fn func(entry *Entry, rec *isoRecord) error) error {
+	var index int64
+	visited := make(map[int64]bool)
+	var walkDir func(dir *isoRecord, prefix string, depth int) error
+	walkDir = func(dir *isoRecord, prefix string, depth int) error {
+		if depth > isoMaxDepth {
+			return errors.New("iso directories nested too deep")
+		}
+		visited[dir.extents[0].offset] = true
+		records, err := img.readDirectory(dir)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if rec.relocated {
+				continue
+			}
+			if err := img.resolve(rec); err != nil {
+				return err
+			}
+			name := prefix + rec.name
+			if rec.isDir() {
+				name += "/"
+			}
+			id := entryID(offsetID, rec.offset)
+			if len(rec.extents) > 1 {
+				id = entryID(indexID, index)
+			}
+			index++
+			if err := fn(rec.entry(name, id), rec); err != nil {
+				return err
+			}
+			if rec.isDir() && !visited[rec.extents[0].offset] {
+				if err := walkDir(rec, name, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walkDir(img.root, "", 0)
+}
+
+func walkIso(r *httpreader.Reader, fn WalkFunc) error {
+	img, err := openIso(r)
+	if err != nil {
+		return err
+	}
+	return img.walk(func(entry *Entry, rec *isoRecord) error {
+		return fn(entry, rec.content(r))
+	})
+}
+
+// openIsoByName looks the named entry up directory by directory.
+func openIsoByName(r *httpreader.Reader, name string) (io.Reader, error) {
+	img, err := openIso(r)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	dir := img.root
+	for i, part := range parts {
+		records, err := img.readDirectory(dir)
+		if err != nil {
+			return nil, err
+		}
+		var found *isoRecord
+		for _, rec := range records {
+			if rec.name == part && !rec.relocated {
+				found = rec
+				break
+			}
+		}
+		if found == nil || part == "" {
+			return nil, ErrFileNotFound
+		}
+		if err := img.resolve(found); err != nil {
+			return nil, err
+		}
+		if i < len(parts)-1 {
+			if !found.isDir() {
+				return nil, ErrFileNotFound
+			}
+			dir = found
+			continue
+		}
+		if found.isDir() {
+			return nil, ErrIsDir
+		}
+		if strings.HasSuffix(name, "/") {
+			return nil, ErrFileNotFound
+		}
+		return found.content(r), nil
+	}
+	return nil, ErrFileNotFound
+}
+
+func openIsoByIndex(r *httpreader.Reader, index int) (io.Reader, error) {
+	img, err := openIso(r)
+	if err != nil {
+		return nil, err
+	}
+	var found io.Reader
+	i := 0
+	err = img.walk(func(entry *Entry, rec *isoRecord) error {
+		if i < index {
+			i++
+			return nil
+		}
+		if entry.IsDir {
+			return ErrIsDir
+		}
+		found = rec.content(r)
+		return ErrStopWalk
+	})
+	if err == ErrStopWalk {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrOutOfBoundary
+}
+
+// openIsoByOffset opens the file whose record starts at off.
+func openIsoByOffset(r *httpreader.Reader, off int64) (io.Reader, error) {
+	if off < isoDescriptorStart+isoSectorSize || off >= r.Length {
+		return nil, ErrFileNotFound
+	}
+	img, err := openIso(r)
+	if err != nil {
+		return nil, err
+	}
+	// records do not cross sectors
+	end := (off/isoSectorSize + 1) * isoSectorSize
+	if end > r.Length {
+		end = r.Length
+	}
+	buf, err := readIsoAt(r, off, end-off)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := img.parseRecord(buf, off)
+	if err != nil || rec.name == "." || rec.name == ".." || rec.relocated || rec.flags&isoFlagMultiExtent != 0 {
+		// off is not the start of a record of a single extent file
+		return nil, ErrFileNotFound
+	}
+	if err := img.resolve(rec); err != nil {
+		return nil, err
+	}
+	if rec.isDir() {
+		return nil, ErrIsDir
+	}
+	return rec.content(r), nil
+}
+
+// isoInfo counts the entries of an ISO 9660 image, whose files are stored
+// uncompressed. The comment is the volume identifier.
+func isoInfo(r *httpreader.Reader, info *ArchiveInfo) error {
+	img, err := openIso(r)
+	if err != nil {
+		return err
+	}
+	info.Size = 0
+	info.Charset = UTF8
+	info.Comment = img.volume
+	err = img.walk(func(entry *Entry, _ *isoRecord) error {
+		countEntry(info, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	info.CompressedSize = info.Size
+	return nil
+}
@@ -0,0 +1,37 @@
+package archive
+
+import (
+	"errors"
+	"testing"
+)
+
+// imageFixture returns an image whose volume descriptors, from sector 16,
+// have the given standard identifiers.
+func imageFixture(ids ...string) []byte {
+	image := make([]byte, isoDescriptorStart+(len(ids)+1)*isoSectorSize)
+	for i, id := range ids {
+		descriptor := image[isoDescriptorStart+i*isoSectorSize:]
+		copy(descriptor[1:], id)
+		descriptor[6] = 1
+	}
+	return image
+}
+
+func TestDetectUDF(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		ids    []string
+		format string
+		err    error
+	}{
+		{"UDF", []string{"BEA01", "NSR02", "TEA01"}, "", ErrUDFNotSupported},
+		{"UDF 2.x", []string{"BEA01", "NSR03", "TEA01"}, "", ErrUDFNotSupported},
+		{"UDF bridge", []string{"CD001", "BEA01", "NSR02", "TEA01"}, ISO_TYPE, nil},
+		{"unknown", nil, "", nil},
+	} {
+		format, err := DetectFormat(openFixture(t, "a.img", imageFixture(test.ids...)))
+		if format != test.format || !errors.Is(err, test.err) || test.err == nil && err != nil {
+			t.Errorf("%s: got %q,err:%v, want %q,err:%v", test.name, format, err, test.format, test.err)
+		}
+	}
+}
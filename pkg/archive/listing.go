@@ -0,0 +1,140 @@
+package archive
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// the Sort of ListOptions
+const (
+	SortName = "name"
+	SortSize = "size"
+	SortDate = "date"
+)
+
+// ListOptions selects one directory level of an archive and a page of it.
+type ListOptions struct {
+	// Prefix only keeps the entries under it, eg. "go/src/"
+	Prefix string
+	// Depth only keeps the entries at most Depth levels below Prefix, the
+	// directories of deeper entries are listed even if the archive has no
+	// entry for them. 0 means no limit.
+	Depth int
+	// Sort orders the entries by SortName, SortSize or SortDate, the order
+	// of the archive if empty
+	Sort string
+	// Desc reverses the order of Sort
+	Desc bool
+	// Offset skips the first entries and Limit bounds the number of
+	// entries returned, 0 means no limit
+	Offset int
+	Limit  int
+}
+
+// Validate checks the values of the options.
+func (o *ListOptions) Validate() error {
+	if o.Depth < 0 || o.Offset < 0 || o.Limit < 0 {
+		return errors.New("depth, offset and limit must not be negative")
+	}
+	switch o.Sort {
+	case "", SortName, SortSize, SortDate:
+		return nil
+	}
+	return errors.New("do not support sort " + o.Sort)
+}
+
+// ListFilter applies the Prefix and Depth of ListOptions to the entries of
+// an archive given in order, so that a listing can be streamed.
+type ListFilter struct {
+	opts *ListOptions
+	// directories already listed
+	dirs map[string]bool
+}
+
+func NewListFilter(opts *ListOptions) *ListFilter {
+	return &ListFilter{opts: opts, dirs: make(map[string]bool)}
+}
+
+// Filter returns the entries to list for entry: the directories above it
+// not listed yet, then entry itself if it is within Depth.
+func (f *ListFilter) Filter(entry *Entry) []Entry {
+	if !strings.HasPrefix(entry.Name, f.opts.Prefix) {
+		return nil
+	}
+	rel := strings.TrimSuffix(entry.Name[len(f.opts.Prefix):], "/")
+	if rel == "" {
+		// the directory of the prefix itself
+		return nil
+	}
+	elems := strings.Split(rel, "/")
+	var res []Entry
+	if f.opts.Depth > 0 {
+		for level := 1; level < len(elems) && level <= f.opts.Depth; level++ {
+			dir := f.opts.Prefix + strings.Join(elems[:level], "/") + "/"
+			if !f.dirs[dir] {
+				f.dirs[dir] = true
+				res = append(res, Entry{Name: dir, Mode: fs.ModeDir | 0755, IsDir: true})
+			}
+		}
+		if len(elems) > f.opts.Depth {
+			return res
+		}
+	}
+	if entry.IsDir {
+		if f.dirs[entry.Name] {
+			return res
+		}
+		f.dirs[entry.Name] = true
+	}
+	return append(res, *entry)
+}
+
+// ListPage filters entries in the order of the archive, sorts them and
+// returns the page selected by opts with the number of entries before
+// pagination.
+func ListPage(entries []Entry, opts *ListOptions) ([]Entry, int) {
+	filter := NewListFilter(opts)
+	listed := make([]Entry, 0, len(entries))
+	for i := range entries {
+		listed = append(listed, filter.Filter(&entries[i])...)
+	}
+	SortEntries(listed, opts.Sort, opts.Desc)
+	total := len(listed)
+	if opts.Offset >= total {
+		return listed[:0], total
+	}
+	listed = listed[opts.Offset:]
+	if opts.Limit > 0 && opts.Limit < len(listed) {
+		listed = listed[:opts.Limit]
+	}
+	return listed, total
+}
+
+// SortEntries orders entries by SortName, SortSize or SortDate, keeping the
+// order of equal entries. An empty by keeps the order of the archive.
+func SortEntries(entries []Entry, by string, desc bool) {
+	var less func(a, b *Entry) bool
+	switch by {
+	case SortName:
+		less = func(a, b *Entry) bool { return a.Name < b.Name }
+	case SortSize:
+		less = func(a, b *Entry) bool { return a.Size < b.Size }
+	case SortDate:
+		less = func(a, b *Entry) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		if desc {
+			for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+				entries[i], entries[j] = entries[j], entries[i]
+			}
+		}
+		return
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if desc {
+			return less(&entries[j], &entries[i])
+		}
+		return less(&entries[i], &entries[j])
+	})
+}
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListPage(t *testing.T) {
+	r := openFixture(t, "a.tar", tarFixture(t,
+		fileFixture("a/", ""),
+		fileFixture("a/x.txt", "xxx"),
+		fileFixture("a/b/c.txt", "c"),
+		fileFixture("z.txt", "zzzzzzzzzz"),
+	))
+	entries, err := ListEntries(TAR_TYPE, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		opts  ListOptions
+		names string
+		total int
+	}{
+		{"everything", ListOptions{}, "a/ a/x.txt a/b/c.txt z.txt", 4},
+		{"prefix", ListOptions{Prefix: "a/"}, "a/x.txt a/b/c.txt", 2},
+		{"prefix and depth", ListOptions{Prefix: "a/", Depth: 1}, "a/x.txt a/b/", 2},
+		{"depth listing a directory once", ListOptions{Depth: 1}, "a/ z.txt", 2},
+		{"sort by size", ListOptions{Sort: SortSize, Desc: true}, "z.txt a/x.txt a/b/c.txt a/", 4},
+		{"reversed order", ListOptions{Desc: true}, "z.txt a/b/c.txt a/x.txt a/", 4},
+		{"page", ListOptions{Sort: SortName, Offset: 1, Limit: 2}, "a/b/c.txt a/x.txt", 4},
+		{"page after the end", ListOptions{Offset: 4}, "", 4},
+	} {
+		if err := test.opts.Validate(); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		page, total := ListPage(append([]Entry(nil), entries...), &test.opts)
+		var names []string
+		for _, entry := range page {
+			names = append(names, entry.Name)
+		}
+		if strings.Join(names, " ") != test.names || total != test.total {
+			t.Errorf("%s: got %q of %d, want %q of %d", test.name, names, total, test.names, test.total)
+		}
+	}
+
+	for _, opts := range []ListOptions{{Depth: -1}, {Limit: -1}, {Sort: "type"}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v is valid", opts)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// maxMP4HeaderBox bounds the size of the moov box of MP4 read in memory.
+const maxMP4HeaderBox = 16 << 20
+
+var errNoMediaInfo = errors.New("no duration found in the first bytes")
+
+// previewMedia reads the duration, the dimensions of videos and the sample
+// rate and channels of audio from r. MP4 and QuickTime, MP3, WAV and FLAC
+// are supported.
+func previewMedia(preview *EntryPreview, mime *mimetype.MIME, r io.Reader) error {
+	switch {
+	case mime.Is("video/mp4") || mime.Is("video/quicktime") || mime.Is("video/x-m4v") ||
+		mime.Is("audio/mp4") || mime.Is("audio/x-m4a") || mime.Is("video/3gpp") || mime.Is("video/3gpp2"):
+		return mp4Info(r, preview)
+	case mime.Is("audio/mpeg"):
+		return mp3Info(r, preview)
+	case mime.Is("audio/wav"):
+		return wavInfo(r, preview)
+	case mime.Is("audio/flac"):
+		return flacInfo(r, preview)
+	}
+	return nil
+}
+
+// mp4Info reads the moov box of an ISO base media file, skipping the boxes
+// before it.
+func mp4Info(r io.Reader, preview *EntryPreview) error {
+	for {
+		size, boxType, headerLen, err := readMP4BoxHeader(r)
+		if err != nil {
+			if err == io.EOF {
+				return errNoMediaInfo
+			}
+			return err
+		}
+		if size == 0 {
+			// the box extends to the end of the file
+			return errNoMediaInfo
+		}
+		if size < headerLen {
+			return errors.New("invalid mp4 box")
+		}
+		if boxType != "moov" {
+			if _, err := io.CopyN(io.Discard, r, size-headerLen); err != nil {
+				return errNoMediaInfo
+			}
+			continue
+		}
+		if size-headerLen > maxMP4HeaderBox {
+			return errors.New("mp4 header too large")
+		}
+		moov := make([]byte, size-headerLen)
+		if _, err := io.ReadFull(r, moov); err != nil {
+			return err
+		}
+		parseMP4Moov(moov, preview)
+		return nil
+	}
+}
+
+func readMP4BoxHeader(r io.Reader) (int64, string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	boxType := string(header[4:])
+	if size != 1 {
+		return size, boxType, 8, nil
+	}
+	var large [8]byte
+	if _, err := io.ReadFull(r, large[:]); err != nil {
+		return 0, "", 0, err
+	}
+	return int64(binary.BigEndian.Uint64(large[:])), boxType, 16, nil
+}
+
+// mp4Boxes calls fn with the type and content of the boxes in data.
+func mp4Boxes(data []byte, fn func(boxType string, content []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerLen := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			headerLen = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return
+		}
+		fn(string(data[4:8]), data[headerLen:size])
+		data = data[size:]
+	}
+}
+
+// parseMP4Moov reads the duration of the movie header and the dimensions
+// of the first visual track.
+func parseMP4Moov(moov []byte, preview *EntryPreview) {
+	mp4Boxes(moov, func(boxType string, content []byte) {
+		switch boxType {
+		case "mvhd":
+			// version, flags, creation and modification times, time scale,
+			// duration
+			var timescale, duration uint64
+			if len(content) >= 32 && content[0] == 1 {
+				timescale = uint64(binary.BigEndian.Uint32(content[20:]))
+				duration = binary.BigEndian.Uint64(content[24:])
+			} else if len(content) >= 20 {
+				timescale = uint64(binary.BigEndian.Uint32(content[12:]))
+				duration = uint64(binary.BigEndian.Uint32(content[16:]))
+			}
+			if timescale != 0 {
+				preview.Duration = float64(duration) / float64(timescale)
+			}
+		case "trak":
+			mp4Boxes(content, func(boxType string, content []byte) {
+				if boxType != "tkhd" || preview.Width != 0 {
+					return
+				}
+				// the width and height in 16.16 fixed point end the track
+				// header of both versions
+				offset := 76
+				if len(content) > 0 && content[0] == 1 {
+					offset = 88
+				}
+				if len(content) >= offset+8 {
+					preview.Width = int(binary.BigEndian.Uint32(content[offset:]) >> 16)
+					preview.Height = int(binary.BigEndian.Uint32(content[offset+4:]) >> 16)
+				}
+			})
+		}
+	})
+}
+
+// wavInfo reads the format and the size of the data chunk of a RIFF WAVE
+// file.
+func wavInfo(r io.Reader, preview *EntryPreview) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	var byteRate uint32
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return errNoMediaInfo
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return errors.New("invalid wav format chunk")
+			}
+			format := make([]byte, size)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return err
+			}
+			preview.Channels = int(binary.LittleEndian.Uint16(format[2:]))
+			preview.SampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			byteRate = binary.LittleEndian.Uint32(format[8:])
+		case "data":
+			if byteRate == 0 {
+				return errNoMediaInfo
+			}
+			preview.Duration = float64(size) / float64(byteRate)
+			return nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return errNoMediaInfo
+			}
+		}
+		if size%2 == 1 {
+			// chunks are padded to an even size
+			if _, err := io.CopyN(io.Discard, r, 1); err != nil {
+				return errNoMediaInfo
+			}
+		}
+	}
+}
+
+// flacInfo reads the STREAMINFO block, the first metadata block of FLAC.
+func flacInfo(r io.Reader, preview *EntryPreview) error {
+	// the signature, the block header and 18 bytes of STREAMINFO up to the
+	// total number of samples
+	var header [26]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	if header[4]&0x7f != 0 {
+		return errors.New("flac does not start with STREAMINFO")
+	}
+	info := header[8:]
+	// 20 bits of sample rate, 3 bits of channels - 1, 5 bits of bits per
+	// sample - 1 and 36 bits of total samples
+	bits := binary.BigEndian.Uint64(info[10:])
+	sampleRate := bits >> 44
+	channels := (bits>>41)&0x7 + 1
+	samples := bits & 0xfffffffff
+	preview.SampleRate = int(sampleRate)
+	preview.Channels = int(channels)
+	if sampleRate != 0 && samples != 0 {
+		preview.Duration = float64(samples) / float64(sampleRate)
+	}
+	return nil
+}
+
+// the bitrates in kbit/s of MPEG audio layer III by bitrate index, for MPEG
+// 1 and for MPEG 2 and 2.5
+var (
+	mp3Bitrates1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3Bitrates2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+)
+
+// mp3SampleRates by version bits and sample rate index
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG 1
+	2: {22050, 24000, 16000}, // MPEG 2
+	0: {11025, 12000, 8000},  // MPEG 2.5
+}
+
+// mp3Info reads the first frame of MPEG audio layer III. The duration is
+// the number of frames of a Xing or VBRI header, or is estimated from the
+// bitrate and the size of the entry.
+func mp3Info(r io.Reader, preview *EntryPreview) error {
+	br := bufio.NewReaderSize(r, 64<<10)
+	var tagLen int64
+	if head, err := br.Peek(10); err == nil && bytes.HasPrefix(head, []byte("ID3")) {
+		// the size of an ID3v2 tag is stored in 7 bits per byte
+		tagLen = 10 + (int64(head[6])<<21 | int64(head[7])<<14 | int64(head[8])<<7 | int64(head[9]))
+		if head[5]&0x10 != 0 {
+			// footer
+			tagLen += 10
+		}
+		if _, err := br.Discard(int(tagLen)); err != nil {
+			return errNoMediaInfo
+		}
+	}
+	data, _ := br.Peek(64 << 10)
+	for i := 0; i+4 <= len(data); i++ {
+		if data[i] != 0xff || data[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := (data[i+1] >> 3) & 0x3
+		layer := (data[i+1] >> 1) & 0x3
+		bitrateIndex := data[i+2] >> 4
+		rateIndex := (data[i+2] >> 2) & 0x3
+		rates, ok := mp3SampleRates[version]
+		if !ok || layer != 1 || rateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+			// not a layer III frame header
+			continue
+		}
+		mono := data[i+3]>>6 == 3
+		preview.SampleRate = rates[rateIndex]
+		preview.Channels = 2
+		if mono {
+			preview.Channels = 1
+		}
+		bitrate := mp3Bitrates1[bitrateIndex]
+		samplesPerFrame := 1152
+		// the offset of the Xing header after the side information
+		xing := i + 4 + 32
+		switch {
+		case version == 3 && mono:
+			xing = i + 4 + 17
+		case version != 3 && mono:
+			xing = i + 4 + 9
+			bitrate = mp3Bitrates2[bitrateIndex]
+			samplesPerFrame = 576
+		case version != 3:
+			xing = i + 4 + 17
+			bitrate = mp3Bitrates2[bitrateIndex]
+			samplesPerFrame = 576
+		}
+		frames := mp3Frames(data, xing, i+4+32)
+		switch {
+		case frames > 0:
+			preview.Duration = float64(frames) * float64(samplesPerFrame) / float64(preview.SampleRate)
+		case preview.Size > 0:
+			// constant bitrate
+			preview.Duration = float64(preview.Size-tagLen-int64(i)) * 8 / float64(bitrate*1000)
+		default:
+			return errors.New("no duration in a mp3 of unknown size")
+		}
+		return nil
+	}
+	return errNoMediaInfo
+}
+
+// mp3Frames returns the number of frames of a Xing header at xing or a
+// VBRI header at vbri, 0 if there is none.
+func mp3Frames(data []byte, xing int, vbri int) uint32 {
+	if xing+12 <= len(data) {
+		id := string(data[xing : xing+4])
+		flags := binary.BigEndian.Uint32(data[xing+4:])
+		if (id == "Xing" || id == "Info") && flags&0x1 != 0 {
+			return binary.BigEndian.Uint32(data[xing+8:])
+		}
+	}
+	if vbri+18 <= len(data) && string(data[vbri:vbri+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(data[vbri+14:])
+	}
+	return 0
+}
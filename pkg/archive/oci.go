@@ -0,0 +1,465 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	// the manifest written by docker save, listing the layers of the images
+	dockerManifest = "manifest.json"
+	// the index of an OCI image layout, pointing to the manifests of the
+	// images in its blobs directory
+	ociIndex = "index.json"
+	// ociMaxManifest bounds the size of a manifest or an index read in memory
+	ociMaxManifest = 4 << 20
+	// ociMaxNesting bounds the indexes followed to reach a manifest
+	ociMaxNesting = 8
+	// ociMaxLinks bounds the symbolic links followed to reach a layer, docker
+	// save links the layers shared by several images
+	ociMaxLinks = 8
+
+	// ociWhiteout prefixes the name of a file deleting the file of the same
+	// name from the layers below
+	ociWhiteout = ".wh."
+	// ociOpaque is the name of a file deleting the content of its directory
+	// from the layers below
+	ociOpaque = ".wh..wh..opq"
+)
+
+// ociDescriptor points to a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType   string
+	Digest      string
+	Annotations map[string]string
+}
+
+// ociImage reads the layers of the first image of a tarball written by
+// docker save or of an OCI image layout.
+type ociImage struct {
+	r *httpreader.Reader
+	// layers are the files of the layer blobs, the lowest first
+	layers []*ociFile
+	// tags names the image
+	tags []string
+}
+
+// ociFile is a file of the tarball.
+type ociFile struct {
+	offset   int64
+	size     int64
+	linkname string
+	link     bool
+}
+
+func openOci(r *httpreader.Reader) (*ociImage, error) {
+	files, err := ociFiles(r)
+	if err != nil {
+		return nil, err
+	}
+	img := &ociImage{r: r}
+	var layers []string
+	switch {
+	case files[dockerManifest] != nil:
+		content, err := img.readFile(files, dockerManifest)
+		if err != nil {
+			return nil, err
+		}
+		var manifests []struct {
+			RepoTags []string
+			Layers   []string
+		}
+		if err := json.Unmarshal(content, &manifests); err != nil {
+			return nil, fmt.Errorf("fail to parse %s,err:%s", dockerManifest, err)
+		}
+		if len(manifests) == 0 {
+			return nil, errors.New("no image in " + dockerManifest)
+		}
+		img.tags, layers = manifests[0].RepoTags, manifests[0].Layers
+	case files[ociIndex] != nil:
+		layers, err = img.readIndex(files)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("neither " + dockerManifest + " nor " + ociIndex + " in image")
+	}
+	for _, layer := range layers {
+		file, err := resolveOciFile(files, path.Clean(layer))
+		if err != nil {
+			return nil, err
+		}
+		img.layers = append(img.layers, file)
+	}
+	return img, nil
+}
+
+// ociFiles indexes the files of the tarball by name, without the leading
+// "./" of a layout archived from its directory.
+func ociFiles(r *httpreader.Reader) (map[string]*ociFile, error) {
+	files := make(map[string]*ociFile)
+	section := io.NewSectionReader(r, 0, r.Length)
+	tarReader := tar.NewReader(section)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			offset, err := section.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = &ociFile{offset: offset, size: header.Size}
+		case tar.TypeSymlink:
+			files[name] = &ociFile{linkname: path.Join(path.Dir(name), header.Linkname), link: true}
+		case tar.TypeLink:
+			files[name] = &ociFile{linkname: path.Clean(strings.TrimPrefix(header.Linkname, "./")), link: true}
+		}
+	}
+}
+
+func resolveOciFile(files map[string]*ociFile, name string) (*ociFile, error) {
+	for i := 0; i <= ociMaxLinks; i++ {
+		file := files[name]
+		if file == nil {
+			return nil, errors.New(name + " not found in image")
+		}
+		if !file.link {
+			return file, nil
+		}
+		name = file.linkname
+	}
+	return nil, errors.New("too many links to " + name)
+}
+
+func (img *ociImage) readFile(files map[string]*ociFile, name string) ([]byte, error) {
+	file, err := resolveOciFile(files, name)
+	if err != nil {
+		return nil, err
+	}
+	if file.size > ociMaxManifest {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, ociMaxManifest)
+	}
+	content := make([]byte, file.size)
+	if _, err := io.ReadFull(io.NewSectionReader(img.r, file.offset, file.size), content); err != nil {
+		return nil, fmt.Errorf("fail to read %s,err:%s", name, err)
+	}
+	return content, nil
+}
+
+// readIndex follows the first manifest of the index of an OCI image layout,
+// through nested indexes of multi-platform images, to the names of the blobs
+// of its layers.
+func (img *ociImage) readIndex(files map[string]*ociFile) ([]string, error) {
+	name := ociIndex
+	for i := 0; i < ociMaxNesting; i++ {
+		content, err := img.readFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		var blob struct {
+			Manifests []ociDescriptor
+			Layers    []ociDescriptor
+		}
+		if err := json.Unmarshal(content, &blob); err != nil {
+			return nil, fmt.Errorf("fail to parse %s,err:%s", name, err)
+		}
+		if blob.Layers != nil {
+			layers := make([]string, 0, len(blob.Layers))
+			for _, layer := range blob.Layers {
+				layers = append(layers, ociBlob(layer.Digest))
+			}
+			return layers, nil
+		}
+		if len(blob.Manifests) == 0 {
+			return nil, errors.New("no image in " + name)
+		}
+		if ref := blob.Manifests[0].Annotations["org.opencontainers.image.ref.name"]; ref != "" && img.tags == nil {
+			img.tags = []string{ref}
+		}
+		name = ociBlob(blob.Manifests[0].Digest)
+	}
+	return nil, errors.New("too many nested indexes in image")
+}
+
+// ociBlob is the name of the blob of a digest, eg. blobs/sha256/<hex>.
+func ociBlob(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// ociLayer reads the entries of a layer, whose names are cleaned of the
+// leading "./" and "/" and exclude the root directory. The entries of an
+// uncompressed layer are identified by the offsets of their headers in the
+// image, the others are left to the caller.
+type ociLayer struct {
+	tar          *tar.Reader
+	decompressor io.ReadCloser
+	// r counts the bytes read of an uncompressed layer, nil for a
+	// compressed one
+	r *countingReader
+	// header is the offset of the next header of an uncompressed layer, -1
+	// once it is unknown
+	header int64
+}
+
+// the magic numbers of the compressions of layers
+var layerCompressions = []struct {
+	magic      []byte
+	compressor string
+}{
+	{[]byte{0x1f, 0x8b}, "gzip"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "zstd"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "xz"},
+	{[]byte("BZh"), "bzip2"},
+}
+
+// openLayer opens the layer at index i, a tar archive compressed or not as
+// told by its first bytes.
+func (img *ociImage) openLayer(i int) (*ociLayer, error) {
+	file := img.layers[i]
+	layer := io.NewSectionReader(img.r, file.offset, file.size)
+	compressor := layerCompressor(layer)
+	if compressor == "" {
+		counter := &countingReader{r: layer, n: file.offset}
+		return &ociLayer{tar: tar.NewReader(counter), decompressor: io.NopCloser(counter), r: counter, header: file.offset}, nil
+	}
+	decompressor, err := newDecompressor(compressor, layer)
+	if err != nil {
+		return nil, fmt.Errorf("fail to decompress layer %d,err:%s", i, err)
+	}
+	return &ociLayer{tar: tar.NewReader(decompressor), decompressor: decompressor}, nil
+}
+
+// layerCompressor returns the compression of a layer told by its first
+// bytes, empty if it is not compressed.
+func layerCompressor(layer io.ReaderAt) string {
+	magic := make([]byte, 6)
+	n, _ := layer.ReadAt(magic, 0)
+	for _, compression := range layerCompressions {
+		if bytes.HasPrefix(magic[:n], compression.magic) {
+			return compression.compressor
+		}
+	}
+	return ""
+}
+
+// compressed tells if any layer is compressed.
+func (img *ociImage) compressed() bool {
+	for _, file := range img.layers {
+		if layerCompressor(io.NewSectionReader(img.r, file.offset, file.size)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ociLayer) next() (*Entry, error) {
+	for {
+		header, err := l.tar.Next()
+		if err != nil {
+			return nil, err
+		}
+		var id string
+		if l.r != nil && l.header >= 0 {
+			id = entryID(offsetID, l.header)
+			l.header = tarHeaderAfter(header, l.r.n)
+		}
+		name := ociName(header.Name)
+		if name == "" {
+			continue
+		}
+		entry := tarEntry(header, name, id)
+		if entry.IsDir {
+			entry.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			// the target of a hard link is the name of another file
+			entry.Linkname = ociName(header.Linkname)
+		}
+		return entry, nil
+	}
+}
+
+// ociName cleans the name of a file of a layer of the leading "./" and "/",
+// it is empty for the root directory.
+func ociName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (l *ociLayer) Read(p []byte) (int, error) {
+	return l.tar.Read(p)
+}
+
+func (l *ociLayer) Close() error {
+	return l.decompressor.Close()
+}
+
+// whiteout tells if the entry named name is a whiteout file, returning the
+// name it deletes with everything below, or the directory whose content it
+// deletes if opaque. The other whiteout files of aufs delete nothing.
+func whiteout(name string) (deleted string, opaque bool, ok bool) {
+	dir, base := path.Split(name)
+	switch {
+	case base == ociOpaque:
+		return strings.TrimSuffix(dir, "/"), true, true
+	case strings.HasPrefix(base, ociWhiteout+ociWhiteout):
+		return "", false, true
+	case strings.HasPrefix(base, ociWhiteout):
+		return dir + base[len(ociWhiteout):], false, true
+	}
+	return "", false, false
+}
+
+// ociMerge reads the entries of the merged filesystem of an image, from the
+// topmost layer down. An entry is listed by the topmost layer providing it,
+// so it is known to be visible as soon as it is read and each layer is
+// decompressed once. Entries of compressed layers are identified by their
+// index.
+type ociMerge struct {
+	img *ociImage
+	// layer reads the layer at index i, nil between layers
+	layer *ociLayer
+	i     int
+	// seen tells if the names listed so far are directories
+	seen map[string]bool
+	// deleted are the names deleted with everything below by the whiteouts
+	// of the layers read, and opaque the directories whose content they
+	// delete
+	deleted map[string]bool
+	opaque  map[string]bool
+	// the whiteouts of the current layer, which only apply below it
+	nextDeleted []string
+	nextOpaque  []string
+	index       int64
+}
+
+func newOciMerge(r *httpreader.Reader) (*ociMerge, error) {
+	img, err := openOci(r)
+	if err != nil {
+		return nil, err
+	}
+	return &ociMerge{
+		img:     img,
+		i:       len(img.layers) - 1,
+		seen:    make(map[string]bool),
+		deleted: make(map[string]bool),
+		opaque:  make(map[string]bool),
+	}, nil
+}
+
+func (m *ociMerge) next() (*Entry, error) {
+	for {
+		if m.layer == nil {
+			if m.i < 0 {
+				return nil, io.EOF
+			}
+			layer, err := m.img.openLayer(m.i)
+			if err != nil {
+				return nil, err
+			}
+			m.layer = layer
+		}
+		entry, err := m.layer.next()
+		if err == io.EOF {
+			m.layer.Close()
+			m.layer = nil
+			m.i--
+			for _, name := range m.nextDeleted {
+				m.deleted[name] = true
+			}
+			for _, dir := range m.nextOpaque {
+				m.opaque[dir] = true
+			}
+			m.nextDeleted, m.nextOpaque = nil, nil
+			continue
+		}
+		if err != nil {
+			m.layer.Close()
+			return nil, fmt.Errorf("fail to read layer %d,err:%s", m.i, err)
+		}
+		key := strings.TrimSuffix(entry.Name, "/")
+		if deleted, opaque, ok := whiteout(key); ok {
+			if opaque {
+				m.nextOpaque = append(m.nextOpaque, deleted)
+			} else if deleted != "" {
+				m.nextDeleted = append(m.nextDeleted, deleted)
+			}
+			continue
+		}
+		if _, ok := m.seen[key]; ok || m.hidden(key) {
+			continue
+		}
+		m.seen[key] = entry.IsDir
+		if entry.ID == "" {
+			entry.ID = entryID(indexID, m.index)
+		}
+		m.index++
+		return entry, nil
+	}
+}
+
+// hidden tells if the entry named key of the current layer is hidden by
+// the layers above: deleted by a whiteout, below an opaque directory, or
+// below a name that is not a directory there. Only the parent directories of
+// key are looked up.
+func (m *ociMerge) hidden(key string) bool {
+	if m.deleted[key] {
+		return true
+	}
+	for dir := key; dir != ""; {
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+		if m.deleted[dir] || m.opaque[dir] {
+			return true
+		}
+		if isDir, ok := m.seen[dir]; ok && !isDir {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ociMerge) Read(p []byte) (int, error) {
+	if m.layer == nil {
+		return 0, io.EOF
+	}
+	return m.layer.Read(p)
+}
+
+// ociInfo counts the entries of the merged filesystem of the image. The
+// comment lists the tags of the image.
+func ociInfo(r *httpreader.Reader, info *ArchiveInfo) error {
+	m, err := newOciMerge(r)
+	if err != nil {
+		return err
+	}
+	info.Size = 0
+	info.Charset = UTF8
+	info.Comment = strings.Join(m.img.tags, ", ")
+	info.Solid = m.img.compressed()
+	for {
+		entry, err := m.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		countEntry(info, entry)
+	}
+}
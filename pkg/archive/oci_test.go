@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestOciWhiteouts(t *testing.T) {
+	lower := tarFixture(t,
+		fileFixture("./bin/", ""),
+		fileFixture("./bin/sh", "sh\n"),
+		fixture{name: "./bin/bash", typeflag: tar.TypeLink, linkname: "./bin/sh"},
+		fileFixture("./etc/", ""),
+		fileFixture("./etc/a.conf", "a\n"),
+		fileFixture("./etc/b.conf", "old\n"),
+		fileFixture("./opt/", ""),
+		fileFixture("./opt/x/", ""),
+		fileFixture("./opt/x/1.txt", "1\n"),
+		fileFixture("./opt/y.txt", "y\n"),
+		fileFixture("./var/", ""),
+		fileFixture("./var/lib/", ""),
+		fileFixture("./var/lib/data.txt", "data\n"),
+	)
+	upper := gzipFixture(t, tarFixture(t,
+		fileFixture("etc/", ""),
+		fileFixture("etc/.wh.a.conf", ""),
+		fileFixture("etc/b.conf", "new\n"),
+		fileFixture("opt/.wh..wh..opq", ""),
+		fileFixture("opt/z.txt", "z\n"),
+		fileFixture("var/lib", "lib\n"),
+		fileFixture(".wh..wh.plnk", ""),
+	))
+	image := tarFixture(t,
+		fileFixture("manifest.json", `[{"RepoTags":["a:latest"],"Layers":["lower/layer.tar","upper/layer.tar"]}]`),
+		fileFixture("lower/layer.tar", string(lower)),
+		fileFixture("upper/layer.tar", string(upper)),
+	)
+	r := openFixture(t, "a.tar", image)
+	entries, err := ListEntries(OCI_TYPE, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	// the upper layer first, then what it does not hide of the lower one
+	want := []string{"etc/", "etc/b.conf", "opt/z.txt", "var/lib", "bin/", "bin/sh", "bin/bash", "opt/", "var/"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %q, want %q", names, want)
+	}
+	if entries[6].Linkname != "bin/sh" {
+		t.Errorf("hard link to %q, want bin/sh", entries[6].Linkname)
+	}
+
+	contents := map[string]string{"etc/b.conf": "new\n", "opt/z.txt": "z\n", "var/lib": "lib\n", "bin/sh": "sh\n"}
+	for i, name := range names {
+		content, ok := contents[name]
+		if !ok {
+			continue
+		}
+		for _, open := range []func() (io.Reader, error){
+			func() (io.Reader, error) { return OpenByName(OCI_TYPE, r, name, "") },
+			func() (io.Reader, error) { return OpenByIndex(OCI_TYPE, r, i) },
+			func() (io.Reader, error) { return OpenByID(OCI_TYPE, r, entries[i].ID) },
+		} {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			er, err := open()
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+				continue
+			}
+			if got, _ := io.ReadAll(er); string(got) != content {
+				t.Errorf("%s holds %q, want %q", name, got, content)
+			}
+		}
+	}
+	for _, name := range []string{"etc/a.conf", "opt/x/1.txt", "opt/y.txt", "var/lib/data.txt", "etc/.wh.a.conf"} {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenByName(OCI_TYPE, r, name, ""); err != ErrFileNotFound {
+			t.Errorf("%s: got %v, want ErrFileNotFound", name, err)
+		}
+	}
+}
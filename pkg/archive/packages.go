@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/Heng-Bian/httpreader"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// entryIterator reads the entries of ar, cpio, deb, rpm and oci one after
+// another, like tar.Reader.
+type entryIterator interface {
+	// next advances to the next entry, io.EOF after the last one
+	next() (*Entry, error)
+	// Read reads the content of the current entry
+	io.Reader
+}
+
+// packageEntries returns an iterator of the entries of ar, deb, cpio, rpm
+// and oci, read from the current position of r.
+func packageEntries(format string, r *httpreader.Reader) (entryIterator, error) {
+	switch format {
+	case AR_TYPE:
+		return newArReader(r)
+	case DEB_TYPE:
+		return newDebReader(r)
+	case CPIO_TYPE:
+		return newCpioReader(r, true)
+	case RPM_TYPE:
+		return newRpmReader(r)
+	case OCI_TYPE:
+		return newOciMerge(r)
+	}
+	return nil, errors.New("do not support " + format)
+}
+
+func walkPackage(format string, r *httpreader.Reader, fn WalkFunc) error {
+	it, err := packageEntries(format, r)
+	if err != nil {
+		return err
+	}
+	for {
+		entry, err := it.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry, it); err != nil {
+			return err
+		}
+	}
+}
+
+// openPackage returns the content of the first entry matched by match,
+// which is given the entry and its index. notFound is returned if no entry
+// matches.
+func openPackage(format string, r *httpreader.Reader, match func(entry *Entry, index int) bool, notFound error) (io.Reader, error) {
+	it, err := packageEntries(format, r)
+	if err != nil {
+		return nil, err
+	}
+	for index := 0; ; index++ {
+		entry, err := it.next()
+		if err == io.EOF {
+			return nil, notFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if match(entry, index) {
+			if entry.IsDir {
+				return nil, ErrIsDir
+			}
+			return it, nil
+		}
+	}
+}
+
+// openPackageByOffset opens the entry of ar, cpio or the uncompressed
+// payload of rpm whose header starts at off.
+func openPackageByOffset(format string, r *httpreader.Reader, off int64) (io.Reader, error) {
+	if off >= r.Length {
+		return nil, ErrFileNotFound
+	}
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var it entryIterator
+	switch format {
+	case AR_TYPE:
+		if off < int64(len(arMagic)) || off%2 != 0 {
+			return nil, ErrFileNotFound
+		}
+		it = &arReader{r: &countingReader{r: r, n: off}}
+	case CPIO_TYPE:
+		it = &cpioReader{r: &countingReader{r: r, n: off}, offsets: true}
+	case RPM_TYPE:
+		rpm, err := openRpmAt(r, off)
+		if err != nil {
+			return nil, err
+		}
+		it = rpm
+	default:
+		return nil, ErrFileNotFound
+	}
+	entry, err := it.next()
+	if err != nil {
+		// off is not the start of a header
+		return nil, ErrFileNotFound
+	}
+	if entry.IsDir {
+		return nil, ErrIsDir
+	}
+	return it, nil
+}
+
+// packageInfo counts the entries of ar, deb, cpio and rpm. The content of
+// deb and rpm is compressed together, whose compressed size is not told.
+func packageInfo(format string, r *httpreader.Reader, info *ArchiveInfo) error {
+	info.Size = 0
+	info.Charset = UTF8
+	err := walkPackage(format, r, func(entry *Entry, _ io.Reader) error {
+		countEntry(info, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if format == AR_TYPE || format == CPIO_TYPE {
+		info.CompressedSize = info.Size
+	} else {
+		info.Solid = true
+	}
+	return nil
+}
+
+// packageName is the name of a file of deb or rpm without the leading "./"
+// of the paths stored by dpkg-deb and rpmbuild, which an URL path cannot
+// hold. It is empty for the root directory.
+func packageName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// skip discards the next n bytes, seeking over large ones if possible. The
+// count is the offset in the archive of the next byte if n starts at the
+// offset of r.
+func (c *countingReader) skip(n int64) error {
+	if seeker, ok := c.r.(io.Seeker); ok && n > skipSeekThreshold {
+		// httpreader refuses to seek to the end, which is read instead
+		if _, err := seeker.Seek(n, io.SeekCurrent); err == nil {
+			c.n += n
+			return nil
+		}
+	}
+	skipped, err := io.CopyN(io.Discard, c, n)
+	if err == io.EOF && skipped < n {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// skipSeekThreshold is the size above which skipping content of an archive
+// read over HTTP starts a new request rather than reading through it.
+const skipSeekThreshold = 64 << 10
+
+// the file type bits of a Unix mode
+const (
+	unixTypeMask = 0170000
+	unixFIFO     = 0010000
+	unixChar     = 0020000
+	unixDir      = 0040000
+	unixBlock    = 0060000
+	unixRegular  = 0100000
+	unixSymlink  = 0120000
+	unixSocket   = 0140000
+	unixSetuid   = 04000
+	unixSetgid   = 02000
+	unixSticky   = 01000
+)
+
+// unixMode converts the mode of a Unix file stored by ar and cpio.
+func unixMode(mode int64) fs.FileMode {
+	m := fs.FileMode(mode).Perm()
+	if mode&unixSetuid != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&unixSetgid != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&unixSticky != 0 {
+		m |= fs.ModeSticky
+	}
+	switch mode & unixTypeMask {
+	case unixFIFO:
+		m |= fs.ModeNamedPipe
+	case unixChar:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case unixDir:
+		m |= fs.ModeDir
+	case unixBlock:
+		m |= fs.ModeDevice
+	case unixSymlink:
+		m |= fs.ModeSymlink
+	case unixSocket:
+		m |= fs.ModeSocket
+	}
+	return m
+}
+
+// stMode is the inverse of unixMode.
+func stMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= unixSetuid
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= unixSetgid
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= unixSticky
+	}
+	switch {
+	case m&fs.ModeDir != 0:
+		mode |= unixDir
+	case m&fs.ModeSymlink != 0:
+		mode |= unixSymlink
+	case m&fs.ModeNamedPipe != 0:
+		mode |= unixFIFO
+	case m&fs.ModeCharDevice != 0:
+		mode |= unixChar
+	case m&fs.ModeDevice != 0:
+		mode |= unixBlock
+	case m&fs.ModeSocket != 0:
+		mode |= unixSocket
+	default:
+		mode |= unixRegular
+	}
+	return mode
+}
+
+// newDecompressor decompresses the payload of rpm or a member of deb,
+// compressor names the compression like rpm, eg. gzip, xz or zstd, and is
+// empty for an uncompressed payload.
+func newDecompressor(compressor string, r io.Reader) (io.ReadCloser, error) {
+	switch compressor {
+	case "":
+		return io.NopCloser(r), nil
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case "lzma":
+		lr, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(lr), nil
+	case "zstd":
+		// without concurrency the decoder starts no goroutine, which would
+		// leak when walking stops early
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, errors.New("do not support compression " + compressor)
+}
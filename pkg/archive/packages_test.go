@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPackages(t *testing.T) {
+	data := []fixture{
+		fileFixture("./", ""),
+		fileFixture("./usr/", ""),
+		fileFixture("./usr/a.txt", "hello\n"),
+		{name: "./usr/b.txt", typeflag: tar.TypeLink, linkname: "./usr/a.txt"},
+	}
+	deb := arFixture(t,
+		fileFixture("debian-binary", "2.0\n"),
+		fileFixture("control.tar.gz", string(gzipFixture(t, tarFixture(t, fileFixture("./control", "Package: a\n"))))),
+		fileFixture("data.tar.gz", string(gzipFixture(t, tarFixture(t, data...)))),
+	)
+	cpio := []fixture{
+		fileFixture("usr/", ""),
+		fileFixture("usr/a.txt", "hello\n"),
+		{name: "usr/b.txt", typeflag: tar.TypeSymlink, linkname: "a.txt"},
+	}
+	for _, test := range []struct {
+		format string
+		data   []byte
+		// the contents of the files by name, in order
+		files [][2]string
+	}{
+		{AR_TYPE, arFixture(t, fileFixture("a.o", "hello\n"), fileFixture("b.o", "odd")), [][2]string{
+			{"a.o", "hello\n"}, {"b.o", "odd"},
+		}},
+		{DEB_TYPE, deb, [][2]string{
+			{"DEBIAN/control", "Package: a\n"}, {"usr/", ""}, {"usr/a.txt", "hello\n"}, {"usr/b.txt", ""},
+		}},
+		{CPIO_TYPE, cpioFixture(t, cpio...), [][2]string{
+			{"usr/", ""}, {"usr/a.txt", "hello\n"}, {"usr/b.txt", "a.txt"},
+		}},
+		{RPM_TYPE, rpmFixture(t, "gzip", append([]fixture{fileFixture("./", "")}, cpio...)...), [][2]string{
+			{"usr/", ""}, {"usr/a.txt", "hello\n"}, {"usr/b.txt", "a.txt"},
+		}},
+	} {
+		r := openFixture(t, "a."+test.format, test.data)
+		format, err := DetectFormat(r)
+		if err != nil || format != test.format {
+			t.Errorf("%s: detected %q,err:%v", test.format, format, err)
+		}
+		names, err := List(test.format, r, "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.format, err)
+		}
+		if len(names) != len(test.files) {
+			t.Fatalf("%s: got names %q", test.format, names)
+		}
+		for i, file := range test.files {
+			if names[i] != file[0] {
+				t.Errorf("%s: got name %q, want %q", test.format, names[i], file[0])
+				continue
+			}
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			content, err := OpenByName(test.format, r, file[0], "")
+			if file[0][len(file[0])-1] == '/' {
+				if err != ErrIsDir {
+					t.Errorf("%s: open directory %s,err:%v", test.format, file[0], err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: open %s,err:%s", test.format, file[0], err)
+				continue
+			}
+			if got, _ := io.ReadAll(content); string(got) != file[1] {
+				t.Errorf("%s: %s holds %q, want %q", test.format, file[0], got, file[1])
+			}
+		}
+	}
+}
+
+func TestDebHardLinks(t *testing.T) {
+	deb := arFixture(t,
+		fileFixture("debian-binary", "2.0\n"),
+		fileFixture("data.tar", string(tarFixture(t,
+			fileFixture("./usr/bin/a", "#!/bin/sh\n"),
+			fixture{name: "./usr/bin/b", typeflag: tar.TypeLink, linkname: "./usr/bin/a"},
+		))),
+	)
+	r := openFixture(t, "a.deb", deb)
+	entries, err := ListEntries(DEB_TYPE, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Name != "usr/bin/b" || entries[1].Linkname != entries[0].Name {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := Pack(DEB_TYPE, &buf, r, NamesSelector(nil), "", TAR_TYPE, DefaultCompression, ""); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	for _, want := range []*tar.Header{
+		{Name: "usr/bin/a", Typeflag: tar.TypeReg},
+		{Name: "usr/bin/b", Typeflag: tar.TypeLink, Linkname: "usr/bin/a"},
+	} {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name != want.Name || header.Typeflag != want.Typeflag || header.Linkname != want.Linkname {
+			t.Errorf("got %s %c %s, want %s %c %s", header.Name, header.Typeflag, header.Linkname, want.Name, want.Typeflag, want.Linkname)
+		}
+	}
+}
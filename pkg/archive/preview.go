@@ -0,0 +1,446 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Heng-Bian/httpreader"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/saintfish/chardet"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// the Kind of an EntryPreview
+const (
+	PreviewText   = "text"
+	PreviewImage  = "image"
+	PreviewMedia  = "media"
+	PreviewBinary = "binary"
+)
+
+// the defaults of PreviewOptions
+const (
+	defaultPreviewTextBytes = 64 << 10
+	defaultThumbnailSize    = 256
+	defaultMaxImageBytes    = 32 << 20
+	defaultMaxImagePixels   = 50 << 20
+	defaultMaxMediaBytes    = 64 << 20
+)
+
+// PreviewOptions bounds what Preview reads and generates, the zero values
+// select the defaults.
+type PreviewOptions struct {
+	// MaxTextBytes is the size of the beginning of a text entry returned,
+	// 64KB by default
+	MaxTextBytes int64
+	// ThumbnailSize bounds the width and height of thumbnails, 256 by
+	// default
+	ThumbnailSize int
+	// MaxImageBytes and MaxImagePixels bound the images decoded for a
+	// thumbnail, 32MB and 50M pixels by default
+	MaxImageBytes  int64
+	MaxImagePixels int64
+	// MaxMediaBytes is how much of an audio or video entry is read to find
+	// its metadata, 64MB by default
+	MaxMediaBytes int64
+}
+
+func (o *PreviewOptions) withDefaults() PreviewOptions {
+	opts := *o
+	if opts.MaxTextBytes <= 0 {
+		opts.MaxTextBytes = defaultPreviewTextBytes
+	}
+	if opts.ThumbnailSize <= 0 {
+		opts.ThumbnailSize = defaultThumbnailSize
+	}
+	if opts.MaxImageBytes <= 0 {
+		opts.MaxImageBytes = defaultMaxImageBytes
+	}
+	if opts.MaxImagePixels <= 0 {
+		opts.MaxImagePixels = defaultMaxImagePixels
+	}
+	if opts.MaxMediaBytes <= 0 {
+		opts.MaxMediaBytes = defaultMaxMediaBytes
+	}
+	return opts
+}
+
+// EntryPreview is what can be shown of an entry without downloading it.
+type EntryPreview struct {
+	Name string
+	ID   string `json:",omitempty"`
+	// Size is the size stored in the archive, -1 if unknown
+	Size int64
+	// Kind is text, image, media or binary
+	Kind        string
+	ContentType string
+	// Text is the beginning of a text entry decoded from Charset to UTF-8,
+	// Truncated if the entry is longer
+	Text      string `json:",omitempty"`
+	Charset   string `json:",omitempty"`
+	Truncated bool   `json:",omitempty"`
+	// Language is a syntax highlighting hint, eg. go or python
+	Language string `json:",omitempty"`
+	// Width and Height of an image or a video in pixels
+	Width  int `json:",omitempty"`
+	Height int `json:",omitempty"`
+	// Thumbnail is a data URI of a JPEG or PNG image fitting in the
+	// ThumbnailSize of PreviewOptions
+	Thumbnail string `json:",omitempty"`
+	// Duration of audio or video in seconds
+	Duration   float64 `json:",omitempty"`
+	SampleRate int     `json:",omitempty"`
+	Channels   int     `json:",omitempty"`
+	// Error tells why no thumbnail or metadata could be produced
+	Error string `json:",omitempty"`
+}
+
+// Preview previews the first entry chosen by sel, reading no more of it
+// than opts allow. It returns ErrFileNotFound if no entry is chosen and
+// ErrIsDir if the entry is a directory.
+func Preview(format string, r *httpreader.Reader, charset string, sel *Selector, opts *PreviewOptions) (*EntryPreview, error) {
+	var preview *EntryPreview
+	err := Walk(format, r, charset, func(entry *Entry, er io.Reader) error {
+		if !sel.MatchEntry(entry) {
+			return nil
+		}
+		if entry.IsDir {
+			return ErrIsDir
+		}
+		var err error
+		preview, err = PreviewEntry(entry, er, opts)
+		if err != nil {
+			return err
+		}
+		return ErrStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if preview == nil {
+		return nil, ErrFileNotFound
+	}
+	return preview, nil
+}
+
+// PreviewEntry previews the content of entry read from r. The kind of
+// content is detected from its first bytes.
+func PreviewEntry(entry *Entry, r io.Reader, opts *PreviewOptions) (*EntryPreview, error) {
+	o := opts.withDefaults()
+	preview := &EntryPreview{Name: entry.Name, ID: entry.ID, Size: entry.Size, Kind: PreviewBinary}
+	head := make([]byte, o.MaxTextBytes+1)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	mime := mimetype.Detect(head)
+	preview.ContentType = mime.String()
+	switch {
+	case isTextMime(mime):
+		preview.Kind = PreviewText
+		previewText(preview, head, o.MaxTextBytes)
+		if i := strings.Index(preview.ContentType, "; charset="); i >= 0 {
+			// mimetype does not tell the legacy encodings from UTF-8
+			preview.ContentType = preview.ContentType[:i] + "; charset=" + strings.ToLower(preview.Charset)
+		}
+	case strings.HasPrefix(mime.String(), "image/"):
+		preview.Kind = PreviewImage
+		content := io.MultiReader(bytes.NewReader(head), r)
+		if err := previewImage(preview, content, &o); err != nil {
+			preview.Error = err.Error()
+		}
+	case strings.HasPrefix(mime.String(), "audio/") || strings.HasPrefix(mime.String(), "video/"):
+		preview.Kind = PreviewMedia
+		content := io.LimitReader(io.MultiReader(bytes.NewReader(head), r), o.MaxMediaBytes)
+		if err := previewMedia(preview, mime, content); err != nil {
+			preview.Error = err.Error()
+		}
+	}
+	return preview, nil
+}
+
+// isTextMime reports whether mime is plain text or a type based on it, eg.
+// JSON or SVG.
+func isTextMime(mime *mimetype.MIME) bool {
+	for ; mime != nil; mime = mime.Parent() {
+		if strings.HasPrefix(mime.String(), "text/plain") {
+			return true
+		}
+	}
+	return false
+}
+
+// previewText decodes head, of which at most limit bytes are shown, and
+// guesses the language from the name and the first line.
+func previewText(preview *EntryPreview, head []byte, limit int64) {
+	if int64(len(head)) > limit {
+		head = head[:limit]
+		preview.Truncated = true
+	}
+	text, charset := decodeText(head, preview.Truncated)
+	preview.Text = text
+	preview.Charset = charset
+	preview.Language = textLanguage(preview.Name, text)
+}
+
+var (
+	utf8BOM    = []byte{0xef, 0xbb, 0xbf}
+	utf16LEBOM = []byte{0xff, 0xfe}
+	utf16BEBOM = []byte{0xfe, 0xff}
+)
+
+// decodeText returns content as UTF-8 with its detected charset. A
+// truncated content may end in the middle of a character, which is dropped.
+func decodeText(content []byte, truncated bool) (string, string) {
+	switch {
+	case bytes.HasPrefix(content, utf8BOM):
+		content = content[len(utf8BOM):]
+	case bytes.HasPrefix(content, utf16LEBOM):
+		return decodeTextWith(content, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder(), "UTF-16LE", truncated)
+	case bytes.HasPrefix(content, utf16BEBOM):
+		return decodeTextWith(content, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder(), "UTF-16BE", truncated)
+	}
+	valid := content
+	if truncated {
+		valid = trimPartialRune(content)
+	}
+	if utf8.Valid(valid) {
+		return string(valid), UTF8
+	}
+	results, err := chardet.NewTextDetector().DetectAll(content)
+	if err == nil {
+		for _, result := range results {
+			charset := ianaCharset(result.Charset)
+			if strings.HasPrefix(charset, "UTF-") {
+				continue
+			}
+			e, err := ianaindex.IANA.Encoding(charset)
+			if err != nil || e == nil {
+				continue
+			}
+			return decodeTextWith(content, e.NewDecoder(), charset, truncated)
+		}
+	}
+	return strings.ToValidUTF8(string(content), string(utf8.RuneError)), ""
+}
+
+func decodeTextWith(content []byte, decoder *encoding.Decoder, charset string, truncated bool) (string, string) {
+	decoded, err := decoder.Bytes(content)
+	if err != nil {
+		return strings.ToValidUTF8(string(content), string(utf8.RuneError)), ""
+	}
+	text := string(decoded)
+	if truncated {
+		text = strings.TrimRight(text, string(utf8.RuneError))
+	}
+	return text, charset
+}
+
+// trimPartialRune removes the incomplete UTF-8 sequence content may end
+// with.
+func trimPartialRune(content []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(content); i++ {
+		c := content[len(content)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(content[len(content)-i:]) {
+				return content[:len(content)-i]
+			}
+			break
+		}
+	}
+	return content
+}
+
+// languages maps file extensions to the names of syntax highlighting
+// languages.
+var languages = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".jsx":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".ini":   "ini",
+	".cfg":   "ini",
+	".md":    "markdown",
+	".html":  "html",
+	".htm":   "html",
+	".css":   "css",
+	".scss":  "scss",
+	".xml":   "xml",
+	".svg":   "xml",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".java":  "java",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".swift": "swift",
+	".rs":    "rust",
+	".rb":    "ruby",
+	".php":   "php",
+	".pl":    "perl",
+	".lua":   "lua",
+	".r":     "r",
+	".sh":    "bash",
+	".bash":  "bash",
+	".ps1":   "powershell",
+	".bat":   "dos",
+	".sql":   "sql",
+	".proto": "protobuf",
+	".diff":  "diff",
+	".patch": "diff",
+	".tex":   "latex",
+	".csv":   "csv",
+}
+
+// languageFiles maps file names without a telling extension to languages.
+var languageFiles = map[string]string{
+	"makefile":    "makefile",
+	"gnumakefile": "makefile",
+	"dockerfile":  "dockerfile",
+	"cmakelists":  "cmake",
+	"go.mod":      "go",
+}
+
+// shebangs maps the interpreters of scripts to languages.
+var shebangs = map[string]string{
+	"sh":      "bash",
+	"bash":    "bash",
+	"zsh":     "bash",
+	"python":  "python",
+	"python3": "python",
+	"node":    "javascript",
+	"perl":    "perl",
+	"ruby":    "ruby",
+	"php":     "php",
+}
+
+// textLanguage guesses the language of a text entry from its name, then
+// from the interpreter of a script.
+func textLanguage(name string, text string) string {
+	base := strings.ToLower(path.Base(name))
+	if language, ok := languages[path.Ext(base)]; ok {
+		return language
+	}
+	if language, ok := languageFiles[strings.TrimSuffix(base, ".txt")]; ok {
+		return language
+	}
+	if !strings.HasPrefix(text, "#!") {
+		return ""
+	}
+	line := text[2:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	return shebangs[interpreter]
+}
+
+// previewImage reads the dimensions of the image and generates its
+// thumbnail if it is within the limits of opts.
+func previewImage(preview *EntryPreview, r io.Reader, opts *PreviewOptions) error {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, opts.MaxImageBytes+1))
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return err
+	}
+	preview.Width, preview.Height = config.Width, config.Height
+	if n > opts.MaxImageBytes {
+		return errors.New("image too large for a thumbnail")
+	}
+	if int64(config.Width)*int64(config.Height) > opts.MaxImagePixels {
+		return errors.New("image has too many pixels for a thumbnail")
+	}
+	img, _, err := image.Decode(&buf)
+	if err != nil {
+		return err
+	}
+	preview.Thumbnail, err = thumbnail(img, opts.ThumbnailSize)
+	return err
+}
+
+// thumbnail scales img down to fit in a size x size square and encodes it
+// as a data URI, PNG if it has transparent pixels and JPEG otherwise.
+func thumbnail(img image.Image, size int) (string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+		if width == 0 {
+			width = 1
+		}
+		if height == 0 {
+			height = 1
+		}
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+	}
+	var buf bytes.Buffer
+	if opaque(img) {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+			return "", err
+		}
+		return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pngFixture returns a PNG of the given size, opaque or transparent.
+func pngFixture(t *testing.T, width, height int, alpha uint8) string {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: alpha})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// mp4Box returns a box of the given type holding the contents.
+func mp4Box(boxType string, contents ...[]byte) []byte {
+	content := bytes.Join(contents, nil)
+	box := make([]byte, 4, 8+len(content))
+	binary.BigEndian.PutUint32(box, uint32(8+len(content)))
+	return append(append(box, boxType...), content...)
+}
+
+// mediaFixtures returns a WAV, a FLAC, an MP4 and an MP3 file lasting 2, 2,
+// 3 and 1 seconds.
+func mediaFixtures() map[string]string {
+	var wav bytes.Buffer
+	wav.WriteString("RIFF\x00\x00\x00\x00WAVEfmt ")
+	// the size of the format chunk, PCM, 2 channels at 8kHz, 32000 bytes
+	// per second, 4 bytes per sample frame of 16 bits
+	binary.Write(&wav, binary.LittleEndian, []uint32{16})
+	binary.Write(&wav, binary.LittleEndian, []uint16{1, 2})
+	binary.Write(&wav, binary.LittleEndian, []uint32{8000, 32000})
+	binary.Write(&wav, binary.LittleEndian, []uint16{4, 16})
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, []uint32{64000})
+
+	// STREAMINFO of 34 bytes: block and frame sizes, then 44.1kHz, 2
+	// channels, 16 bits and 88200 samples, and the MD5 of the audio. An
+	// empty padding block is the last metadata block.
+	flac := append([]byte("fLaC\x00\x00\x00\x22"), make([]byte, 34)...)
+	binary.BigEndian.PutUint64(flac[18:], 44100<<44|1<<41|15<<36|88200)
+	flac = append(flac, 0x81, 0, 0, 0)
+
+	// a movie header of 3 seconds at 1000 units per second and a 640x480
+	// track
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 3000)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 640<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 480<<16)
+	mp4 := append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")), mp4Box("moov", mp4Box("mvhd", mvhd), mp4Box("trak", mp4Box("tkhd", tkhd)))...)
+
+	// an empty ID3v2 tag and a stereo MPEG 1 layer III frame at 128kbit/s
+	// and 44.1kHz, 16000 bytes of audio lasting a second
+	mp3 := []byte("ID3\x03\x00\x00\x00\x00\x00\x00\xff\xfb\x90\x00")
+	mp3 = append(mp3, make([]byte, 16000-4)...)
+
+	return map[string]string{"a.wav": wav.String(), "a.flac": string(flac), "a.mp4": string(mp4), "a.mp3": string(mp3)}
+}
+
+func TestPreview(t *testing.T) {
+	media := mediaFixtures()
+	files := []fixture{
+		fileFixture("dir/", ""),
+		fileFixture("main.go", "package main\n"),
+		fileFixture("run", "#!/usr/bin/env python3\nprint(1)\n"),
+		fileFixture("long.txt", "hé"+strings.Repeat("x", 10)),
+		fileFixture("utf16.txt", "\xff\xfeh\x00i\x00"),
+		fileFixture("a.bin", "\x00\x01\x02\x03"),
+		fileFixture("opaque.png", pngFixture(t, 300, 100, 255)),
+		fileFixture("alpha.png", pngFixture(t, 10, 20, 128)),
+	}
+	for _, name := range []string{"a.wav", "a.flac", "a.mp4", "a.mp3"} {
+		files = append(files, fileFixture(name, media[name]))
+	}
+	sizes := make(map[string]int64)
+	for _, f := range files {
+		sizes[f.name] = int64(len(f.content))
+	}
+	r := openFixture(t, "a.zip", zipFixture(t, files...))
+	for _, test := range []struct {
+		name string
+		opts PreviewOptions
+		want EntryPreview
+		// thumbnail is the type and the size of the thumbnail
+		thumbnail string
+	}{
+		{name: "main.go", want: EntryPreview{Kind: PreviewText, ContentType: "text/plain; charset=utf-8", Text: "package main\n", Charset: UTF8, Language: "go"}},
+		{name: "run", want: EntryPreview{Kind: PreviewText, ContentType: "text/plain; charset=utf-8", Text: "#!/usr/bin/env python3\nprint(1)\n", Charset: UTF8, Language: "python"}},
+		// the limit cuts é in the middle
+		{name: "long.txt", opts: PreviewOptions{MaxTextBytes: 2}, want: EntryPreview{Kind: PreviewText, ContentType: "text/plain; charset=utf-8", Text: "h", Charset: UTF8, Truncated: true}},
+		{name: "utf16.txt", want: EntryPreview{Kind: PreviewText, ContentType: "text/plain; charset=utf-16le", Text: "hi", Charset: "UTF-16LE"}},
+		{name: "a.bin", want: EntryPreview{Kind: PreviewBinary, ContentType: "application/octet-stream"}},
+		{name: "opaque.png", want: EntryPreview{Kind: PreviewImage, ContentType: "image/png", Width: 300, Height: 100}, thumbnail: "image/jpeg 256x85"},
+		{name: "alpha.png", want: EntryPreview{Kind: PreviewImage, ContentType: "image/png", Width: 10, Height: 20}, thumbnail: "image/png 10x20"},
+		{name: "opaque.png", opts: PreviewOptions{MaxImagePixels: 1000}, want: EntryPreview{Kind: PreviewImage, ContentType: "image/png", Width: 300, Height: 100, Error: "image has too many pixels for a thumbnail"}},
+		{name: "a.wav", want: EntryPreview{Kind: PreviewMedia, ContentType: "audio/wav", Duration: 2, SampleRate: 8000, Channels: 2}},
+		{name: "a.flac", want: EntryPreview{Kind: PreviewMedia, ContentType: "audio/flac", Duration: 2, SampleRate: 44100, Channels: 2}},
+		{name: "a.mp4", want: EntryPreview{Kind: PreviewMedia, ContentType: "video/mp4", Duration: 3, Width: 640, Height: 480}},
+		{name: "a.mp3", want: EntryPreview{Kind: PreviewMedia, ContentType: "audio/mpeg", Duration: 1, SampleRate: 44100, Channels: 2}},
+	} {
+		preview, err := Preview(ZIP_TYPE, r, "", NamesSelector([]string{test.name}), &test.opts)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		thumbnail := preview.Thumbnail
+		preview.Thumbnail = ""
+		test.want.Name, test.want.ID, test.want.Size = test.name, preview.ID, sizes[test.name]
+		if *preview != test.want || preview.ID == "" {
+			t.Errorf("%s: got %+v, want %+v", test.name, *preview, test.want)
+		}
+		if got := thumbnailOf(t, thumbnail); got != test.thumbnail {
+			t.Errorf("%s: got thumbnail %q, want %q", test.name, got, test.thumbnail)
+		}
+	}
+
+	if _, err := Preview(ZIP_TYPE, r, "", NamesSelector([]string{"dir/"}), &PreviewOptions{}); err != ErrIsDir {
+		t.Errorf("previewed a directory,err:%v", err)
+	}
+	if _, err := Preview(ZIP_TYPE, r, "", NamesSelector([]string{"missing"}), &PreviewOptions{}); err != ErrFileNotFound {
+		t.Errorf("previewed a missing entry,err:%v", err)
+	}
+}
+
+// thumbnailOf returns the media type and the size of the image of a data
+// URI, empty if there is none.
+func thumbnailOf(t *testing.T, uri string) string {
+	t.Helper()
+	if uri == "" {
+		return ""
+	}
+	mediaType, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ";base64,")
+	content, err := base64.StdEncoding.DecodeString(data)
+	if !ok || err != nil {
+		t.Fatalf("invalid thumbnail %.40q", uri)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("invalid %s thumbnail,err:%s", mediaType, err)
+	}
+	return mediaType + " " + strconv.Itoa(config.Width) + "x" + strconv.Itoa(config.Height)
+}
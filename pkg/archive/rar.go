@@ -1,10 +1,7 @@
 package archive
 
 import (
-	"archive/zip"
-	"errors"
 	"io"
-	"sort"
 	"strings"
 
 	"github.com/Heng-Bian/httpreader"
@@ -45,13 +42,13 @@ func UnRarByFileName(r *httpreader.Reader, name string) (io.Reader, error) {
 		if err != nil {
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil, errors.New("file not found")
+				return nil, ErrFileNotFound
 			} else {
 				return nil, err
 			}
 		}
-		if name == header.Name {
-			return rarReader, nil
+		if name == header.Name || header.IsDir && name == header.Name+"/" {
+			return rarContent(rarReader, header)
 		}
 	}
 }
@@ -63,33 +60,42 @@ func UnRarByFileIndex(r *httpreader.Reader, index int) (io.Reader, error) {
 	}
 	var count int
 	for {
-		_, err := rarReader.Next()
+		header, err := rarReader.Next()
 		if err != nil {
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil, errors.New("file not found")
+				return nil, ErrOutOfBoundary
 			} else {
 				return nil, err
 			}
 		}
 		if count == index {
-			return rarReader, nil
+			return rarContent(rarReader, header)
 		}
 		count++
 	}
 }
 
+func rarContent(rarReader *rardecode.Reader, header *rardecode.FileHeader) (io.Reader, error) {
+	if header.IsDir {
+		return nil, ErrIsDir
+	}
+	return rarReader, nil
+}
+
 func RarToZip(w io.Writer, r *httpreader.Reader, names []string) error {
+	_, err := pack(RAR_TYPE, w, r, NamesSelector(names), "", ZIP_TYPE, DefaultCompression, "")
+	return err
+}
+
+func WalkRar(r *httpreader.Reader, fn WalkFunc) error {
 	rarReader, err := rardecode.NewReader(r)
 	if err != nil {
 		return err
 	}
-	zipWriter := zip.NewWriter(w)
-	sort.Strings(names)
-	for {
+	for index := int64(0); ; index++ {
 		header, err := rarReader.Next()
 		if err != nil {
-			zipWriter.Close()
 			//io.EOF is not a error
 			if err == io.EOF {
 				return nil
@@ -101,11 +107,20 @@ func RarToZip(w io.Writer, r *httpreader.Reader, names []string) error {
 		if header.IsDir && !strings.HasSuffix(header.Name, "/") {
 			name = name + "/"
 		}
-		if Exists(names, name) {
-			z, err := zipWriter.Create(name)
-			if err == nil && !strings.HasSuffix(header.Name, "/") {
-				io.Copy(z, rarReader)
-			}
+		size := header.UnPackedSize
+		if header.UnKnownSize {
+			size = -1
+		}
+		entry := &Entry{
+			Name:    name,
+			Size:    size,
+			Mode:    header.Mode(),
+			ModTime: header.ModificationTime,
+			IsDir:   header.IsDir,
+			ID:      entryID(indexID, index),
+		}
+		if err := fn(entry, rarReader); err != nil {
+			return err
 		}
 	}
 }
@@ -0,0 +1,207 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	rpmLeadSize        = 96
+	rpmHeaderIntroSize = 16
+	// rpmMaxHeader bounds the size of the header read in memory
+	rpmMaxHeader = 64 << 20
+
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTypeString           = 6
+)
+
+var (
+	rpmLeadMagic   = []byte{0xed, 0xab, 0xee, 0xdb}
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+)
+
+// rpmReader reads the files of the cpio payload of a RPM package, which
+// follows the lead, the signature and the header of the package. The files
+// of an uncompressed payload are identified by the offsets of their headers
+// in the package, the others by their indexes.
+type rpmReader struct {
+	*cpioReader
+	decompressor io.ReadCloser
+	index        int64
+}
+
+func newRpmReader(r *httpreader.Reader) (*rpmReader, error) {
+	compressor, err := readRpmPayloadCompressor(r)
+	if err != nil {
+		return nil, err
+	}
+	if compressor == "" {
+		cpio, err := newCpioReader(r, true)
+		if err != nil {
+			return nil, err
+		}
+		cpio.base = cpio.r.n
+		return &rpmReader{cpioReader: cpio, decompressor: io.NopCloser(r)}, nil
+	}
+	decompressor, err := newDecompressor(compressor, r)
+	if err != nil {
+		return nil, fmt.Errorf("fail to decompress rpm payload,err:%s", err)
+	}
+	return &rpmReader{
+		cpioReader:   &cpioReader{r: &countingReader{r: decompressor}},
+		decompressor: decompressor,
+	}, nil
+}
+
+// openRpmAt returns a reader of the package positioned on the file whose
+// header starts at off in an uncompressed payload.
+func openRpmAt(r *httpreader.Reader, off int64) (*rpmReader, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	compressor, err := readRpmPayloadCompressor(r)
+	if err != nil || compressor != "" {
+		return nil, ErrFileNotFound
+	}
+	base, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if off < base {
+		return nil, ErrFileNotFound
+	}
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cpio := &cpioReader{r: &countingReader{r: r, n: off}, offsets: true, base: base}
+	return &rpmReader{cpioReader: cpio, decompressor: io.NopCloser(r)}, nil
+}
+
+// readRpmPayloadCompressor reads the lead, the signature and the header of
+// a package, returning the compression of the payload that follows, empty
+// if it is not compressed.
+func readRpmPayloadCompressor(r *httpreader.Reader) (string, error) {
+	lead := make([]byte, rpmLeadSize)
+	if _, err := io.ReadFull(r, lead); err != nil || !bytes.HasPrefix(lead, rpmLeadMagic) {
+		return "", errors.New("not a rpm package")
+	}
+	_, signature, err := readRpmHeader(r)
+	if err != nil {
+		return "", fmt.Errorf("fail to read rpm signature,err:%s", err)
+	}
+	// the signature is padded to 8 bytes
+	if pad := (8 - (rpmHeaderIntroSize+len(signature))%8) % 8; pad != 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(pad)); err != nil {
+			return "", err
+		}
+	}
+	count, header, err := readRpmHeader(r)
+	if err != nil {
+		return "", fmt.Errorf("fail to read rpm header,err:%s", err)
+	}
+	tags := rpmStrings(count, header, rpmTagPayloadFormat, rpmTagPayloadCompressor)
+	if format := tags[rpmTagPayloadFormat]; format != "" && format != "cpio" {
+		return "", errors.New("do not support rpm payload " + format)
+	}
+	// rpmbuild leaves out the tag of an uncompressed payload, which is told
+	// by its magic
+	payload, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	magic := make([]byte, len(cpioNewc))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", fmt.Errorf("fail to read rpm payload,err:%s", err)
+	}
+	if _, err := r.Seek(payload, io.SeekStart); err != nil {
+		return "", err
+	}
+	switch string(magic) {
+	case cpioNewc, cpioCRC, cpioODC:
+		return "", nil
+	}
+	compressor, ok := tags[rpmTagPayloadCompressor]
+	if !ok {
+		// packages older than the tag are compressed with gzip
+		compressor = "gzip"
+	}
+	return compressor, nil
+}
+
+func (p *rpmReader) next() (*Entry, error) {
+	for {
+		entry, err := p.cpioReader.next()
+		if err == io.EOF {
+			p.decompressor.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.Name = packageName(entry.Name)
+		if entry.Name != "" {
+			// the root directory skipped is not counted
+			if !p.offsets {
+				entry.ID = entryID(indexID, p.index)
+			}
+			p.index++
+			return entry, nil
+		}
+	}
+}
+
+// readRpmHeader reads a header structure and returns the number of its
+// index entries followed by the entries and the store.
+func readRpmHeader(r io.Reader) (int, []byte, error) {
+	var intro [rpmHeaderIntroSize]byte
+	if _, err := io.ReadFull(r, intro[:]); err != nil {
+		return 0, nil, err
+	}
+	if !bytes.HasPrefix(intro[:], rpmHeaderMagic) {
+		return 0, nil, errors.New("invalid header magic")
+	}
+	// the magic, 4 reserved bytes, the number of index entries and the size
+	// of the store
+	count := int64(binary.BigEndian.Uint32(intro[8:]))
+	size := count*16 + int64(binary.BigEndian.Uint32(intro[12:]))
+	if size > rpmMaxHeader {
+		return 0, nil, errors.New("header too large")
+	}
+	header := make([]byte, size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	return int(count), header, nil
+}
+
+// rpmStrings returns the values of the string tags of a header of count
+// index entries.
+func rpmStrings(count int, header []byte, tags ...int32) map[int32]string {
+	values := make(map[int32]string)
+	index := header[:count*16]
+	store := header[count*16:]
+	for i := 0; i < count; i++ {
+		entry := index[i*16:]
+		tag := int32(binary.BigEndian.Uint32(entry))
+		if binary.BigEndian.Uint32(entry[4:]) != rpmTypeString {
+			continue
+		}
+		offset := int(int32(binary.BigEndian.Uint32(entry[8:])))
+		for _, wanted := range tags {
+			if tag != wanted || offset < 0 || offset >= len(store) {
+				continue
+			}
+			value := store[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+			values[tag] = string(value)
+		}
+	}
+	return values
+}
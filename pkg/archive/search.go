@@ -0,0 +1,220 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+// maxLineLen is the length up to which a line is matched and reported,
+// the rest of a longer line is ignored.
+const maxLineLen = 4096
+
+// SearchOptions configures Search.
+type SearchOptions struct {
+	// Pattern is matched against every line of the selected entries
+	Pattern *regexp.Regexp
+	// Context is the number of lines reported before and after a match
+	Context int
+	// MaxMatches stops the search after that many matches, 0 means no limit
+	MaxMatches int
+	// MaxBytes stops the search after that many bytes of content are read,
+	// 0 means no limit
+	MaxBytes int64
+	// MaxEntryBytes limits the bytes searched in each entry, 0 means no
+	// limit
+	MaxEntryBytes int64
+}
+
+// Match is a line of an entry matching the pattern of Search. Line counts
+// from 1.
+type Match struct {
+	Entry  string
+	Line   int
+	Text   string
+	Before []string `json:",omitempty"`
+	After  []string `json:",omitempty"`
+}
+
+// SearchResult reports what Search read.
+type SearchResult struct {
+	// Matches is the number of matching lines found
+	Matches int
+	// Entries is the number of entries searched, binary entries are
+	// skipped
+	Entries int
+	// Bytes is the number of bytes of content read
+	Bytes int64
+	// Truncated tells that a match beyond MaxMatches was found, or that a
+	// byte limit stopped reading an entry before its end
+	Truncated bool
+}
+
+// Search calls fn for every line of the entries chosen by sel that matches
+// the pattern of opts, in the order of the archive. Entries are read one
+// after another and the search stops early when a limit is reached or fn
+// returns an error. Once MaxMatches matches are found, the search reads on,
+// within MaxBytes, until one more match tells that the result is truncated.
+func Search(format string, r *httpreader.Reader, charset string, sel *Selector, opts *SearchOptions, fn func(*Match) error) (*SearchResult, error) {
+	res := &SearchResult{}
+	err := Walk(format, r, charset, func(entry *Entry, er io.Reader) error {
+		if entry.IsDir || !sel.MatchEntry(entry) {
+			return nil
+		}
+		if opts.MaxBytes > 0 && res.Bytes >= opts.MaxBytes {
+			// the limit was reached at the end of the previous entry
+			if hasMore(er) {
+				res.Truncated = true
+				return ErrStopWalk
+			}
+			return nil
+		}
+		limit := opts.MaxEntryBytes
+		if opts.MaxBytes > 0 && (limit <= 0 || opts.MaxBytes-res.Bytes < limit) {
+			limit = opts.MaxBytes - res.Bytes
+		}
+		content := er
+		if limit > 0 {
+			content = io.LimitReader(er, limit)
+		}
+		counter := &countingReader{r: content}
+		err := searchEntry(entry.Name, counter, opts, res, fn)
+		res.Bytes += counter.n
+		if err == errMoreMatches {
+			res.Truncated = true
+			return ErrStopWalk
+		}
+		if err != nil {
+			return err
+		}
+		if limit > 0 && counter.n == limit && hasMore(er) {
+			res.Truncated = true
+			if opts.MaxBytes > 0 && res.Bytes >= opts.MaxBytes {
+				return ErrStopWalk
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// errMoreMatches stops the search when a match beyond MaxMatches is found.
+var errMoreMatches = errors.New("more matches")
+
+// hasMore reports whether r has content left.
+func hasMore(r io.Reader) bool {
+	var b [1]byte
+	n, _ := io.ReadFull(r, b[:])
+	return n == 1
+}
+
+func searchEntry(name string, r io.Reader, opts *SearchOptions, res *SearchResult, fn func(*Match) error) error {
+	br := bufio.NewReaderSize(r, maxLineLen)
+	head, _ := br.Peek(sniffLen)
+	if bytes.IndexByte(head, 0) >= 0 {
+		// like grep, do not print lines of binary content
+		return nil
+	}
+	res.Entries++
+	var before []string
+	// matches waiting for the lines after them
+	var pending []*Match
+	// more is set by a match beyond MaxMatches, which is not reported
+	more := false
+	emit := func() error {
+		for len(pending) != 0 && len(pending[0].After) == opts.Context {
+			if err := fn(pending[0]); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+		return nil
+	}
+	for lineNo := 1; ; lineNo++ {
+		line, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, match := range pending {
+			if len(match.After) < opts.Context {
+				match.After = append(match.After, line)
+			}
+		}
+		if err := emit(); err != nil {
+			return err
+		}
+		if opts.MaxMatches > 0 && res.Matches >= opts.MaxMatches {
+			more = more || opts.Pattern.MatchString(line)
+			if more && len(pending) == 0 {
+				return errMoreMatches
+			}
+			continue
+		}
+		if opts.Pattern.MatchString(line) {
+			res.Matches++
+			match := &Match{Entry: name, Line: lineNo, Text: line}
+			if len(before) != 0 {
+				match.Before = append([]string(nil), before...)
+			}
+			pending = append(pending, match)
+			if err := emit(); err != nil {
+				return err
+			}
+		}
+		if opts.Context > 0 {
+			before = append(before, line)
+			if len(before) > opts.Context {
+				before = before[1:]
+			}
+		}
+	}
+	// the entry ends before the context of the last matches
+	for _, match := range pending {
+		if err := fn(match); err != nil {
+			return err
+		}
+	}
+	if more {
+		return errMoreMatches
+	}
+	return nil
+}
+
+// readLine returns the next line of br without the line break, truncated to
+// maxLineLen bytes.
+func readLine(br *bufio.Reader) (string, error) {
+	line, isPrefix, err := br.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	text := string(line)
+	for isPrefix {
+		_, isPrefix, err = br.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
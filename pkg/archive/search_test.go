@@ -0,0 +1,39 @@
+package archive
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSearchTruncated(t *testing.T) {
+	archive := tarFixture(t, fileFixture("a.txt", "x\nx\n"), fileFixture("b.txt", "y\n"), fileFixture("c.txt", "x\n"))
+	for _, test := range []struct {
+		name      string
+		opts      SearchOptions
+		matches   int
+		truncated bool
+	}{
+		{"all matches", SearchOptions{MaxMatches: 3}, 3, false},
+		{"more matches in a later entry", SearchOptions{MaxMatches: 2}, 2, true},
+		{"more matches in the entry", SearchOptions{MaxMatches: 1}, 1, true},
+		{"entries within maxEntryBytes", SearchOptions{MaxEntryBytes: 4}, 3, false},
+		{"entry cut by maxEntryBytes", SearchOptions{MaxEntryBytes: 2}, 2, true},
+		{"archive within maxBytes", SearchOptions{MaxBytes: 8}, 3, false},
+		{"maxBytes at the end of an entry", SearchOptions{MaxBytes: 6}, 2, true},
+		{"entry cut by maxBytes", SearchOptions{MaxBytes: 5}, 2, true},
+	} {
+		opts := test.opts
+		opts.Pattern = regexp.MustCompile("x")
+		matches := 0
+		res, err := Search(TAR_TYPE, openFixture(t, "a.tar", archive), "", NamesSelector(nil), &opts, func(*Match) error {
+			matches++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if matches != test.matches || res.Matches != test.matches || res.Truncated != test.truncated {
+			t.Errorf("%s: got %d matches reported, %+v", test.name, matches, res)
+		}
+	}
+}
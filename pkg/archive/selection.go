@@ -0,0 +1,203 @@
+package archive
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Selection chooses entries of an archive and how they are named in the
+// output. An entry is selected when it is one of Names or IDs, matches one
+// of the Include globs or Regex expressions, or is under one of the
+// Prefixes, and matches none of the Exclude globs. Without any of Names,
+// IDs, Include, Regex and Prefixes every entry not excluded is selected.
+//
+// Globs are matched against the full entry name with path.Match, where a
+// "**" element also matches any number of directories.
+type Selection struct {
+	Names    []string
+	Include  []string
+	Exclude  []string
+	Regex    []string
+	Prefixes []string
+	// IDs are the ID of entries as listed, which do not depend on the
+	// charset of the names
+	IDs []string
+
+	// StripPrefix is removed from the start of the selected names
+	StripPrefix string
+	// Rewrite replaces the first matching prefix after StripPrefix
+	Rewrite []PrefixRewrite
+}
+
+// PrefixRewrite replaces the prefix From of a name with To.
+type PrefixRewrite struct {
+	From string
+	To   string
+}
+
+// Selector is a compiled Selection.
+type Selector struct {
+	all      bool
+	names    map[string]bool
+	ids      map[string]bool
+	include  []string
+	exclude  []string
+	regex    []*regexp.Regexp
+	prefixes []string
+	strip    string
+	rewrite  []PrefixRewrite
+}
+
+// NamesSelector returns a Selector of exactly the given names.
+func NamesSelector(names []string) *Selector {
+	sel, _ := (&Selection{Names: names}).Compile()
+	return sel
+}
+
+// Compile validates the globs and regular expressions of s.
+func (s *Selection) Compile() (*Selector, error) {
+	sel := &Selector{
+		all:      len(s.Names) == 0 && len(s.IDs) == 0 && len(s.Include) == 0 && len(s.Regex) == 0 && len(s.Prefixes) == 0,
+		names:    make(map[string]bool, len(s.Names)),
+		ids:      make(map[string]bool, len(s.IDs)),
+		include:  s.Include,
+		exclude:  s.Exclude,
+		prefixes: s.Prefixes,
+		strip:    s.StripPrefix,
+		rewrite:  s.Rewrite,
+	}
+	for _, name := range s.Names {
+		sel.names[name] = true
+	}
+	for _, id := range s.IDs {
+		sel.ids[id] = true
+	}
+	for _, patterns := range [][]string{s.Include, s.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid glob %q,err:%s", pattern, err)
+			}
+		}
+	}
+	for _, expr := range s.Regex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q,err:%s", expr, err)
+		}
+		sel.regex = append(sel.regex, re)
+	}
+	return sel, nil
+}
+
+// Match reports whether the named entry is selected.
+func (s *Selector) Match(name string) bool {
+	for _, pattern := range s.exclude {
+		if matchGlob(pattern, name) {
+			return false
+		}
+	}
+	if s.all || s.names[name] {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range s.include {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	for _, re := range s.regex {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchEntry reports whether entry is selected by its name or its ID.
+func (s *Selector) MatchEntry(entry *Entry) bool {
+	if entry.ID != "" && s.ids[entry.ID] {
+		for _, pattern := range s.exclude {
+			if matchGlob(pattern, entry.Name) {
+				return false
+			}
+		}
+		return true
+	}
+	return s.Match(entry.Name)
+}
+
+// Missing returns the sorted Names and IDs of the selection that are not
+// in found.
+func (s *Selector) Missing(found []Entry) []string {
+	seen := make(map[string]bool, len(found))
+	seenIDs := make(map[string]bool, len(found))
+	for _, entry := range found {
+		seen[entry.Name] = true
+		seenIDs[entry.ID] = true
+	}
+	missing := []string{}
+	for name := range s.names {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	for id := range s.ids {
+		if !seenIDs[id] {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// Rename returns the name of a selected entry in the output. An empty name
+// means the entry is the stripped prefix itself and is left out.
+func (s *Selector) Rename(name string) string {
+	name = strings.TrimPrefix(name, s.strip)
+	if name == "" {
+		return ""
+	}
+	for _, rule := range s.rewrite {
+		if strings.HasPrefix(name, rule.From) {
+			return rule.To + strings.TrimPrefix(name, rule.From)
+		}
+	}
+	return name
+}
+
+// matchGlob matches a glob against an entry name element by element, a "**"
+// element matches zero or more elements. The trailing "/" of directories is
+// ignored.
+func matchGlob(pattern string, name string) bool {
+	patterns := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	elems := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	return matchElems(patterns, elems)
+}
+
+func matchElems(patterns []string, elems []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(patterns[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], elems[0]); !ok {
+			return false
+		}
+		patterns, elems = patterns[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
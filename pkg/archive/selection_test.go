@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelection(t *testing.T) {
+	names := []string{"a.txt", "dir/", "dir/b.txt", "dir/sub/c.go", "dir/sub/deep/d.go", "e.go"}
+	for _, test := range []struct {
+		name      string
+		selection Selection
+		want      string
+	}{
+		{"everything", Selection{}, "a.txt dir/ dir/b.txt dir/sub/c.go dir/sub/deep/d.go e.go"},
+		{"names", Selection{Names: []string{"a.txt", "dir/"}}, "a.txt dir/"},
+		{"prefix", Selection{Prefixes: []string{"dir/sub/"}}, "dir/sub/c.go dir/sub/deep/d.go"},
+		// * does not cross a directory, ** does
+		{"glob", Selection{Include: []string{"*.go"}}, "e.go"},
+		{"glob of a directory", Selection{Include: []string{"dir/*"}}, "dir/b.txt"},
+		{"recursive glob", Selection{Include: []string{"**/*.go"}}, "dir/sub/c.go dir/sub/deep/d.go e.go"},
+		{"recursive glob in a directory", Selection{Include: []string{"dir/**"}}, "dir/ dir/b.txt dir/sub/c.go dir/sub/deep/d.go"},
+		{"exclude", Selection{Exclude: []string{"**/deep/**", "a.txt"}}, "dir/ dir/b.txt dir/sub/c.go e.go"},
+		{"exclude a name", Selection{Names: []string{"a.txt", "e.go"}, Exclude: []string{"*.go"}}, "a.txt"},
+		{"regex", Selection{Regex: []string{`^dir/.*\.go$`}}, "dir/sub/c.go dir/sub/deep/d.go"},
+		{"union", Selection{Names: []string{"a.txt"}, Include: []string{"*.go"}, Regex: []string{`b\.txt$`}}, "a.txt dir/b.txt e.go"},
+	} {
+		sel, err := test.selection.Compile()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var got []string
+		for _, name := range names {
+			if sel.Match(name) {
+				got = append(got, name)
+			}
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	for _, selection := range []Selection{{Include: []string{"["}}, {Exclude: []string{"a\\"}}, {Regex: []string{"("}}} {
+		if _, err := selection.Compile(); err == nil {
+			t.Errorf("%+v is valid", selection)
+		}
+	}
+}
+
+func TestSelectorEntries(t *testing.T) {
+	entries := []Entry{{Name: "a.txt", ID: "o0"}, {Name: "b.txt", ID: "o512"}, {Name: "c.go", ID: "o1024"}}
+	sel, err := (&Selection{Names: []string{"a.txt", "x.txt"}, IDs: []string{"o512", "o1024", "o9"}, Exclude: []string{"*.go"}}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []Entry
+	for _, entry := range entries {
+		if sel.MatchEntry(&entry) {
+			found = append(found, entry)
+		}
+	}
+	if len(found) != 2 || found[0].Name != "a.txt" || found[1].Name != "b.txt" {
+		t.Errorf("got %+v", found)
+	}
+	// c.go is excluded, not missing
+	if got := strings.Join(sel.Missing(found), " "); got != "o1024 o9 x.txt" {
+		t.Errorf("got missing %q", got)
+	}
+
+	sel, err = (&Selection{StripPrefix: "dir/", Rewrite: []PrefixRewrite{{From: "sub/", To: "lib/"}, {From: "sub/x", To: "y"}}}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"dir/":          "",
+		"dir/a.txt":     "a.txt",
+		"dir/sub/x.txt": "lib/x.txt",
+		"other/sub/b":   "other/sub/b",
+	} {
+		if got := sel.Rename(name); got != want {
+			t.Errorf("renamed %q as %q, want %q", name, got, want)
+		}
+	}
+}
@@ -1,13 +1,13 @@
 package archive
 
 import (
-	"archive/zip"
 	"io"
-	"sort"
+	"io/fs"
 	"strings"
 
 	"github.com/Heng-Bian/httpreader"
 	"github.com/saracen/go7z"
+	"github.com/saracen/go7z/headers"
 )
 
 func List7zFiles(r *httpreader.Reader) (files []string, err error) {
@@ -28,7 +28,7 @@ func List7zFiles(r *httpreader.Reader) (files []string, err error) {
 			}
 		}
 		//dir
-		if header.Attrib == 16 && !strings.HasSuffix(header.Name, "/") {
+		if header.Attrib&sevenZDirAttrib != 0 && !strings.HasSuffix(header.Name, "/") {
 			fileNames = append(fileNames, header.Name+"/")
 		} else {
 			fileNames = append(fileNames, header.Name)
@@ -37,9 +37,7 @@ func List7zFiles(r *httpreader.Reader) (files []string, err error) {
 }
 
 func Un7zByFileName(r *httpreader.Reader, name string) (io.Reader, error) {
-	length := r.Length
-
-	reader, err := go7z.NewReader(r, length)
+	reader, err := open7z(r)
 	if err != nil {
 		return nil, err
 	}
@@ -53,48 +51,148 @@ func Un7zByFileName(r *httpreader.Reader, name string) (io.Reader, error) {
 				return nil, err
 			}
 		}
-		if header.Name == name {
-			return reader, nil
+		isDir := header.Attrib&sevenZDirAttrib != 0
+		if header.Name == name || isDir && header.Name+"/" == name {
+			return sevenZContent(reader, header)
 		}
 	}
 }
 
 func Un7zByFileIndex(r *httpreader.Reader, index int) (io.Reader, error) {
-	length := r.Length
-
-	reader, err := go7z.NewReader(r, length)
+	reader, err := open7z(r)
 	if err != nil {
 		return nil, err
 	}
 	var count int
 	for {
-		_, err := reader.Next()
+		header, err := reader.Next()
 		if err != nil {
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil, ErrFileNotFound
+				return nil, ErrOutOfBoundary
 			} else {
 				return nil, err
 			}
 		}
 		if count == index {
-			return reader, nil
+			return sevenZContent(reader, header)
 		}
 		count++
 	}
 }
 
+// sevenZReader reads the entries of a 7z archive like go7z.Reader, working
+// around go7z returning the content of the wrong entry when the first entry
+// read in a folder, a solid block, is not the first one of the folder: the
+// first entry of every folder is opened without reading anything from it.
+// Without a decoded header, every entry is read to its end instead.
+type sevenZReader struct {
+	*go7z.Reader
+	// sizes are the unpacked sizes of the entries and starts tells the
+	// entries starting a folder, both nil without a decoded header
+	sizes  []int64
+	starts map[int]bool
+	index  int
+}
+
+func open7z(r *httpreader.Reader) (*sevenZReader, error) {
+	reader, err := go7z.NewReader(r, r.Length)
+	if err != nil {
+		return nil, err
+	}
+	sz := &sevenZReader{Reader: reader, index: -1}
+	if header, err := readSevenZHeader(r, &ArchiveInfo{}); err == nil && header != nil {
+		sz.sizes = sevenZSizes(header)
+		sz.starts = sevenZFolderStarts(header)
+	}
+	return sz, nil
+}
+
+func (sz *sevenZReader) Next() (*headers.FileInfo, error) {
+	if sz.starts == nil && sz.index >= 0 {
+		if _, err := io.Copy(io.Discard, sz.Reader); err != nil {
+			return nil, err
+		}
+	}
+	header, err := sz.Reader.Next()
+	if err != nil {
+		return nil, err
+	}
+	sz.index++
+	if sz.starts[sz.index] && sz.size() > 0 {
+		if _, err := sz.Reader.Read(nil); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// size returns the unpacked size of the current entry, -1 if unknown.
+func (sz *sevenZReader) size() int64 {
+	if sz.index < len(sz.sizes) {
+		return sz.sizes[sz.index]
+	}
+	return -1
+}
+
+// sevenZFolderStarts returns the indexes of the files of a 7z header which
+// start a folder.
+func sevenZFolderStarts(header *headers.Header) map[int]bool {
+	// the number of files of every folder with content
+	var counts []int
+	if info := header.MainStreamsInfo; info != nil && info.UnpackInfo != nil {
+		for i := range info.UnpackInfo.Folders {
+			count := 1
+			if info.SubStreamsInfo != nil && i < len(info.SubStreamsInfo.NumUnpackStreamsInFolders) {
+				count = info.SubStreamsInfo.NumUnpackStreamsInFolders[i]
+			}
+			if count > 0 {
+				counts = append(counts, count)
+			}
+		}
+	}
+	starts := make(map[int]bool, len(counts))
+	// left is the number of files left in the current folder
+	left := 0
+	for i, file := range header.FilesInfo {
+		if file.IsEmptyStream {
+			continue
+		}
+		if left == 0 {
+			if len(counts) == 0 {
+				break
+			}
+			starts[i] = true
+			left, counts = counts[0], counts[1:]
+		}
+		left--
+	}
+	return starts
+}
+
+func sevenZContent(reader *sevenZReader, header *headers.FileInfo) (io.Reader, error) {
+	if header.Attrib&sevenZDirAttrib != 0 {
+		return nil, ErrIsDir
+	}
+	return reader, nil
+}
+
 func SevenZToZip(w io.Writer, r *httpreader.Reader, names []string) error {
-	sevenZ, err := go7z.NewReader(r, r.Length)
+	_, err := pack(SEVEN_Z_TYPE, w, r, NamesSelector(names), "", ZIP_TYPE, DefaultCompression, "")
+	return err
+}
+
+// Walk7z walks a 7z archive. Size is the unpacked size recorded in the
+// header, -1 if the header is encrypted or compressed in a way
+// decodeSevenZHeader does not decode.
+func Walk7z(r *httpreader.Reader, fn WalkFunc) error {
+	reader, err := open7z(r)
 	if err != nil {
 		return err
 	}
-	zipWriter := zip.NewWriter(w)
-	sort.Strings(names)
-	for {
-		header, err := sevenZ.Next()
+	for index := int64(0); ; index++ {
+		header, err := reader.Next()
 		if err != nil {
-			zipWriter.Close()
 			//io.EOF is not a error
 			if err == io.EOF {
 				return nil
@@ -103,14 +201,49 @@ func SevenZToZip(w io.Writer, r *httpreader.Reader, names []string) error {
 			}
 		}
 		name := header.Name
-		if header.Attrib == 16 && !strings.HasSuffix(header.Name, "/") {
+		isDir := header.Attrib&sevenZDirAttrib != 0
+		if isDir && !strings.HasSuffix(header.Name, "/") {
 			name = name + "/"
 		}
-		if Exists(names, name) {
-			z, err := zipWriter.Create(name)
-			if err == nil && !strings.HasSuffix(header.Name, "/") {
-				io.Copy(z, sevenZ)
-			}
+		entry := &Entry{
+			Name:    name,
+			Size:    reader.size(),
+			Mode:    sevenZMode(header.Attrib, isDir),
+			ModTime: header.ModifiedAt,
+			IsDir:   isDir,
+			ID:      entryID(indexID, index),
 		}
+		if err := fn(entry, reader); err != nil {
+			return err
+		}
+	}
+}
+
+const (
+	sevenZDirAttrib      = 0x10
+	sevenZUnixExtension  = 0x8000
+	sevenZReadOnlyAttrib = 0x01
+)
+
+// sevenZMode converts the windows attributes of a 7z entry to a FileMode,
+// using the unix mode stored in the high 16 bits when present.
+func sevenZMode(attrib uint32, isDir bool) fs.FileMode {
+	if attrib&sevenZUnixExtension != 0 {
+		unix := attrib >> 16
+		mode := fs.FileMode(unix & 0777)
+		switch unix & 0170000 {
+		case 0040000:
+			mode |= fs.ModeDir
+		case 0120000:
+			mode |= fs.ModeSymlink
+		}
+		return mode
+	}
+	if isDir {
+		return fs.ModeDir | 0755
+	}
+	if attrib&sevenZReadOnlyAttrib != 0 {
+		return 0444
 	}
+	return 0644
 }
@@ -2,10 +2,9 @@ package archive
 
 import (
 	"archive/tar"
-	"archive/zip"
-	"errors"
 	"io"
-	"sort"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/Heng-Bian/httpreader"
 )
@@ -13,6 +12,7 @@ import (
 func ListTarFiles(r *httpreader.Reader, charset string) (files []string, err error) {
 	fileNames := make([]string, 0, 10)
 	tarReader := tar.NewReader(r)
+	decoder := newNameDecoder(charset, detectTarCharset(r))
 	for {
 		header, err := tarReader.Next()
 		if err != nil {
@@ -23,38 +23,26 @@ func ListTarFiles(r *httpreader.Reader, charset string) (files []string, err err
 				return fileNames, err
 			}
 		}
-		entryName := header.Name
-		if charset != "" {
-			str, err := DecodeString(entryName, charset)
-			if err == nil {
-				entryName = str
-			}
-		}
-		fileNames = append(fileNames, entryName)
+		fileNames = append(fileNames, decoder.decode(header.Name))
 	}
 }
 
 func UnTarByFileName(r *httpreader.Reader, name string, charset string) (io.Reader, error) {
 	tarReader := tar.NewReader(r)
+	decoder := newNameDecoder(charset, detectTarCharset(r))
 	for {
 		header, err := tarReader.Next()
 		if err != nil {
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil, errors.New("file not found")
+				return nil, ErrFileNotFound
 			} else {
 				return nil, err
 			}
 		}
-		entryName := header.Name
-		if charset != "" {
-			str, err := DecodeString(entryName, charset)
-			if err == nil {
-				entryName = str
-			}
-		}
+		entryName := decoder.decode(header.Name)
 		if name == entryName {
-			return tarReader, nil
+			return tarContent(tarReader, header)
 		}
 	}
 }
@@ -63,42 +51,179 @@ func UnTarByFileIndex(r *httpreader.Reader, index int) (io.Reader, error) {
 	tarReader := tar.NewReader(r)
 	var count int
 	for {
-		_, err := tarReader.Next()
+		header, err := tarReader.Next()
 		if err != nil {
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil, errors.New("file not found")
+				return nil, ErrOutOfBoundary
 			} else {
 				return nil, err
 			}
 		}
 		if count == index {
-			return tarReader, nil
+			return tarContent(tarReader, header)
 		}
 		count++
 	}
 }
 
+// unTarByOffset opens the entry whose header starts at off.
+func unTarByOffset(r *httpreader.Reader, off int64) (io.Reader, error) {
+	if off%tarBlockSize != 0 {
+		return nil, ErrFileNotFound
+	}
+	return openTarAt(r, off)
+}
+
+// openTarAt opens the entry of a tar archive whose header starts at off, the
+// archive itself may start anywhere before, like a member of a deb.
+func openTarAt(r *httpreader.Reader, off int64) (io.Reader, error) {
+	if off < 0 || off >= r.Length {
+		return nil, ErrFileNotFound
+	}
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(r)
+	header, err := tarReader.Next()
+	if err != nil {
+		// off is not the start of a header
+		return nil, ErrFileNotFound
+	}
+	return tarContent(tarReader, header)
+}
+
+func tarContent(tarReader *tar.Reader, header *tar.Header) (io.Reader, error) {
+	if header.Typeflag == tar.TypeDir {
+		return nil, ErrIsDir
+	}
+	return tarReader, nil
+}
+
 func TarToZip(w io.Writer, r *httpreader.Reader, names []string) error {
+	_, err := pack(TAR_TYPE, w, r, NamesSelector(names), "", ZIP_TYPE, DefaultCompression, "")
+	return err
+}
+
+func WalkTar(r *httpreader.Reader, charset string, fn WalkFunc) error {
+	_, err := walkTar(r, charset, fn)
+	return err
+}
+
+// walkTar is WalkTar also returning the charset of the names.
+func walkTar(r *httpreader.Reader, charset string, fn WalkFunc) (string, error) {
 	tarReader := tar.NewReader(r)
-	zipWriter := zip.NewWriter(w)
-	sort.Strings(names)
-	for {
+	decoder := newNameDecoder(charset, detectTarCharset(r))
+	// next is the offset of the next header, -1 once it is unknown
+	next, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		next = -1
+	}
+	for index := int64(0); ; index++ {
 		header, err := tarReader.Next()
 		if err != nil {
-			zipWriter.Close()
 			//io.EOF is not a error
 			if err == io.EOF {
-				return nil
+				return decoder.Charset(), nil
 			} else {
-				return err
+				return decoder.Charset(), err
 			}
 		}
-		if Exists(names, header.Name) {
-			z, err := zipWriter.Create(header.Name)
-			if err == nil {
-				io.Copy(z, tarReader)
+		id := entryID(indexID, index)
+		if next >= 0 {
+			id = entryID(offsetID, next)
+			next = tarNextHeader(r, header)
+		}
+		entry := tarEntry(header, decoder.decode(header.Name), id)
+		if header.Typeflag == tar.TypeLink {
+			// the target of a hard link is the name of another entry
+			entry.Linkname = decoder.decode(header.Linkname)
+		}
+		if err := fn(entry, tarReader); err != nil {
+			return decoder.Charset(), err
+		}
+	}
+}
+
+func tarEntry(header *tar.Header, name string, id string) *Entry {
+	return &Entry{
+		Name:     name,
+		Size:     header.Size,
+		Mode:     header.FileInfo().Mode(),
+		ModTime:  header.ModTime,
+		IsDir:    header.Typeflag == tar.TypeDir,
+		Uid:      header.Uid,
+		Gid:      header.Gid,
+		Linkname: header.Linkname,
+		ID:       id,
+	}
+}
+
+const tarBlockSize = 512
+
+// tarNextHeader returns the offset of the header following the one just
+// read, -1 if it cannot be told from the header, as for sparse files whose
+// Size is not the size stored.
+func tarNextHeader(r *httpreader.Reader, header *tar.Header) int64 {
+	content, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return tarHeaderAfter(header, content)
+}
+
+// tarHeaderAfter is tarNextHeader for a header whose content starts at the
+// offset content.
+func tarHeaderAfter(header *tar.Header, content int64) int64 {
+	if header.Typeflag == tar.TypeGNUSparse {
+		return -1
+	}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return -1
+		}
+	}
+	switch header.Typeflag {
+	case tar.TypeLink, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeDir, tar.TypeFifo:
+		// archive/tar reads no content for these types
+		return content
+	}
+	return content + (header.Size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
+
+const (
+	// tarCharsetHeaders is the number of headers read from the start of a
+	// tar archive to detect the charset of its names.
+	tarCharsetHeaders = 1024
+	// tarCharsetBytes stops the detection once that many bytes of names
+	// which are not valid UTF-8 are collected.
+	tarCharsetBytes = 64 << 10
+)
+
+// detectTarCharset returns the detect function of the nameDecoder of a tar
+// archive being read from r. It reads the first tarCharsetHeaders headers of
+// the archive, or fewer once tarCharsetBytes of invalid names are collected,
+// then seeks back to where the reading stopped.
+func detectTarCharset(r *httpreader.Reader) func() string {
+	return func() string {
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return ""
+		}
+		defer r.Seek(pos, io.SeekStart)
+		var invalid []string
+		size := 0
+		tarReader := tar.NewReader(io.NewSectionReader(r, 0, r.Length))
+		for i := 0; i < tarCharsetHeaders && size < tarCharsetBytes; i++ {
+			header, err := tarReader.Next()
+			if err != nil {
+				break
+			}
+			if !utf8.ValidString(header.Name) {
+				invalid = append(invalid, header.Name)
+				size += len(header.Name)
 			}
 		}
+		return DetectCharset(invalid, "")
 	}
 }
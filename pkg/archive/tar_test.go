@@ -0,0 +1,63 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestTarLegacyNames(t *testing.T) {
+	gbk := simplifiedchinese.GBK.NewEncoder()
+	var legacy []fixture
+	for _, name := range []string{"文档/说明书.txt", "文档/中文名称.txt", "图片/照片目录.txt"} {
+		encoded, err := gbk.String(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the content is the name the entry must be listed with
+		legacy = append(legacy, fileFixture(encoded, name))
+	}
+	// legacy names past the headers read to detect the charset
+	var padded []fixture
+	for i := 0; i < tarCharsetHeaders; i++ {
+		padded = append(padded, fileFixture(fmt.Sprintf("pad/%d.txt", i), ""))
+	}
+	padded = append(padded, legacy...)
+	for _, test := range []struct {
+		name     string
+		fixtures []fixture
+		charset  string
+		// decoded tells whether the legacy names are listed decoded
+		decoded bool
+	}{
+		{"detected", legacy, "", true},
+		{"given", legacy, "GBK", true},
+		{"past detection", padded, "", false},
+		{"given past detection", padded, "GBK", true},
+	} {
+		r := openFixture(t, "a.tar", tarFixture(t, test.fixtures...))
+		names, err := List(TAR_TYPE, r, test.charset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != len(test.fixtures) {
+			t.Fatalf("%s: got %d names, want %d", test.name, len(names), len(test.fixtures))
+		}
+		for _, name := range names[len(names)-len(legacy):] {
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			content, err := OpenByName(TAR_TYPE, r, name, test.charset)
+			if err != nil {
+				t.Errorf("%s: listed name %q does not open,err:%s", test.name, name, err)
+				continue
+			}
+			data, _ := io.ReadAll(content)
+			if test.decoded != (string(data) == name) {
+				t.Errorf("%s: got name %q for %q", test.name, name, data)
+			}
+		}
+	}
+}
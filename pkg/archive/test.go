@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+// tarTrailerSize is the size of the two zero blocks ending a tar archive.
+const tarTrailerSize = 2 * 512
+
+// errTruncatedTar is reported when a tar archive ends without its trailer,
+// after the last complete entry.
+var errTruncatedTar = errors.New("tar archive is truncated, the end of archive marker is missing")
+
+// EntryTest is the outcome of testing a single entry.
+type EntryTest struct {
+	Name string
+	// Size is the size stored in the archive, -1 if unknown
+	Size int64
+	// Read is the number of bytes decompressed
+	Read int64
+	OK   bool
+	// Error tells why the entry failed
+	Error string `json:",omitempty"`
+}
+
+// TestResult is the outcome of Test.
+type TestResult struct {
+	OK      bool
+	Entries []EntryTest
+	Passed  int
+	Failed  int
+	// Bytes is the number of bytes decompressed
+	Bytes int64
+	// Error is the problem that stopped reading the archive, eg. a truncated
+	// tar stream
+	Error string `json:",omitempty"`
+}
+
+// Test decompresses every entry of the archive without keeping the content.
+// An entry fails if it cannot be read entirely, if its size differs from
+// the stored one or if its content does not match the checksum stored by
+// the format. Entries of zip are read independently, so testing goes on
+// after a broken entry. An error is only returned if the archive cannot be
+// opened at all.
+func Test(format string, r *httpreader.Reader, charset string) (*TestResult, error) {
+	res := &TestResult{Entries: make([]EntryTest, 0, 10)}
+	err := Walk(format, r, charset, func(entry *Entry, er io.Reader) error {
+		size := entry.Size
+		if entry.IsDir {
+			size = 0
+		}
+		test := EntryTest{Name: entry.Name, Size: size}
+		n, err := io.Copy(io.Discard, er)
+		test.Read = n
+		res.Bytes += n
+		if err == nil && size >= 0 && n != size {
+			err = fmt.Errorf("size mismatch, %d bytes stored and %d bytes read", size, n)
+		}
+		if err != nil {
+			test.Error = err.Error()
+			res.Failed++
+		} else {
+			test.OK = true
+			res.Passed++
+		}
+		res.Entries = append(res.Entries, test)
+		return nil
+	})
+	if err == nil && format == TAR_TYPE {
+		err = checkTarTrailer(r)
+	}
+	if err != nil {
+		if len(res.Entries) == 0 && format != TAR_TYPE {
+			return nil, err
+		}
+		res.Error = err.Error()
+	}
+	res.OK = res.Failed == 0 && res.Error == ""
+	return res, nil
+}
+
+// checkTarTrailer reports a tar archive not ending with zero blocks, which
+// archive/tar accepts silently when the archive is cut between two entries.
+func checkTarTrailer(r *httpreader.Reader) error {
+	if r.Length < tarTrailerSize {
+		return errTruncatedTar
+	}
+	trailer := make([]byte, tarTrailerSize)
+	n, err := r.ReadAt(trailer, r.Length-tarTrailerSize)
+	if err != nil && (err != io.EOF || n != tarTrailerSize) {
+		return err
+	}
+	if !bytes.Equal(trailer, make([]byte, tarTrailerSize)) {
+		return errTruncatedTar
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTest(t *testing.T) {
+	files := []fixture{fileFixture("a.txt", "hello\n"), fileFixture("b.txt", strings.Repeat("b", 1000))}
+	tarData := tarFixture(t, files...)
+	zipData := zipFixture(t, files...)
+	for _, test := range []struct {
+		name   string
+		format string
+		data   []byte
+		// failed are the entries expected to fail, archiveError the error
+		// expected to stop reading the archive
+		failed       []string
+		archiveError string
+	}{
+		{"tar", TAR_TYPE, tarData, nil, ""},
+		{"tar without its trailer", TAR_TYPE, tarData[:len(tarData)-tarTrailerSize], nil, errTruncatedTar.Error()},
+		{"tar cut in an entry", TAR_TYPE, tarData[:len(tarData)-tarTrailerSize-100], []string{"b.txt"}, "unexpected EOF"},
+		{"zip", ZIP_TYPE, zipData, nil, ""},
+		{"zip with a wrong checksum", ZIP_TYPE, corruptZipCRC(t, zipData, "a.txt"), []string{"a.txt"}, ""},
+		{"rar", RAR_TYPE, rarFixture(t, 0, files...), nil, ""},
+		{"rar with wrong checksums", RAR_TYPE, rarFixture(t, 1, files...), []string{"a.txt", "b.txt"}, ""},
+	} {
+		res, err := Test(test.format, openFixture(t, "a."+test.format, test.data), "")
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var failed []string
+		for _, entry := range res.Entries {
+			if !entry.OK {
+				failed = append(failed, entry.Name)
+			}
+		}
+		if strings.Join(failed, ",") != strings.Join(test.failed, ",") || res.Failed != len(test.failed) || res.Passed+res.Failed != len(res.Entries) {
+			t.Errorf("%s: got failed entries %q, want %q: %+v", test.name, failed, test.failed, res)
+		}
+		if !strings.Contains(res.Error, test.archiveError) || (test.archiveError == "") != (res.Error == "") {
+			t.Errorf("%s: got error %q, want %q", test.name, res.Error, test.archiveError)
+		}
+		if res.OK != (len(test.failed) == 0 && test.archiveError == "") {
+			t.Errorf("%s: got OK %v", test.name, res.OK)
+		}
+	}
+
+	if _, err := Test(ZIP_TYPE, openFixture(t, "a.zip", []byte("not a zip")), ""); err == nil {
+		t.Error("tested an archive that cannot be opened")
+	}
+}
@@ -0,0 +1,154 @@
+package archive
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TreeNode is a directory or file of the tree built by BuildTree.
+type TreeNode struct {
+	// Name is the last element of the path
+	Name string
+	// Path is the full entry name, directories end with "/". Implicit
+	// directories get a path synthesized from their parents.
+	Path  string
+	IsDir bool
+	// ID is the ID of the entry of the node, empty for implicit directories
+	ID string `json:",omitempty"`
+	// Size of a directory is the total size of the files below it, -1 if
+	// the size of one of them is unknown
+	Size    int64
+	ModTime time.Time
+	// Files and Dirs count the files and directories below a directory at
+	// any depth
+	Files    int         `json:",omitempty"`
+	Dirs     int         `json:",omitempty"`
+	Children []*TreeNode `json:",omitempty"`
+}
+
+// BuildTree arranges entries in a directory tree. Directories that have no
+// entry of their own in the archive are created implicitly. The children of
+// every directory are sorted by name and the sizes and counts of the
+// directories are aggregated.
+func BuildTree(entries []Entry) *TreeNode {
+	root := &TreeNode{IsDir: true}
+	dirs := map[string]*TreeNode{"": root}
+	for i := range entries {
+		entry := &entries[i]
+		elems := splitPath(entry.Name)
+		if len(elems) == 0 {
+			continue
+		}
+		parent := root
+		dirPath := ""
+		for _, elem := range elems[:len(elems)-1] {
+			dirPath = dirPath + elem + "/"
+			parent = childDir(dirs, parent, elem, dirPath)
+		}
+		last := elems[len(elems)-1]
+		if entry.IsDir {
+			node := childDir(dirs, parent, last, dirPath+last+"/")
+			node.Path = entry.Name
+			node.ModTime = entry.ModTime
+			node.ID = entry.ID
+			continue
+		}
+		parent.Children = append(parent.Children, &TreeNode{
+			Name:    last,
+			Path:    entry.Name,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			ID:      entry.ID,
+		})
+	}
+	sortTree(root)
+	aggregate(root)
+	return root
+}
+
+// Find returns the node of the directory or file at path below n, nil if
+// there is none. An empty path is n itself.
+func (n *TreeNode) Find(path string) *TreeNode {
+	node := n
+	for _, elem := range splitPath(path) {
+		var next *TreeNode
+		for _, child := range node.Children {
+			if child.Name == elem {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		node = next
+	}
+	return node
+}
+
+// Prune removes the nodes more than depth levels below n, the aggregated
+// sizes and counts of the directories are kept.
+func (n *TreeNode) Prune(depth int) {
+	if depth <= 0 {
+		n.Children = nil
+		return
+	}
+	for _, child := range n.Children {
+		child.Prune(depth - 1)
+	}
+}
+
+// aggregate sums the sizes and counts the files and directories below
+// every directory.
+func aggregate(node *TreeNode) {
+	if !node.IsDir {
+		return
+	}
+	node.Size, node.Files, node.Dirs = 0, 0, 0
+	for _, child := range node.Children {
+		aggregate(child)
+		if child.IsDir {
+			node.Dirs += child.Dirs + 1
+			node.Files += child.Files
+		} else {
+			node.Files++
+		}
+		if child.Size < 0 || node.Size < 0 {
+			node.Size = -1
+		} else {
+			node.Size += child.Size
+		}
+	}
+}
+
+func childDir(dirs map[string]*TreeNode, parent *TreeNode, name string, path string) *TreeNode {
+	node, ok := dirs[path]
+	if !ok {
+		node = &TreeNode{Name: name, Path: path, IsDir: true}
+		dirs[path] = node
+		parent.Children = append(parent.Children, node)
+	}
+	return node
+}
+
+func sortTree(node *TreeNode) {
+	sort.SliceStable(node.Children, func(i, j int) bool {
+		return node.Children[i].Name < node.Children[j].Name
+	})
+	for _, child := range node.Children {
+		sortTree(child)
+	}
+}
+
+// splitPath splits an entry name into its elements, ignoring empty and "."
+// elements.
+func splitPath(name string) []string {
+	elems := make([]string, 0, 4)
+	for _, elem := range strings.Split(name, "/") {
+		if elem != "" && elem != "." {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
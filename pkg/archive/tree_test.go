@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// treeLines renders the path, size and counts of every node below n, and
+// whether it has an ID.
+func treeLines(n *TreeNode) string {
+	var lines []string
+	var walk func(*TreeNode)
+	walk = func(n *TreeNode) {
+		for _, child := range n.Children {
+			lines = append(lines, fmt.Sprintf("%s %d %d %d %v", child.Path, child.Size, child.Files, child.Dirs, child.ID != ""))
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(lines, "\n")
+}
+
+func TestBuildTree(t *testing.T) {
+	r := openFixture(t, "a.tar", tarFixture(t,
+		fileFixture("b/", ""),
+		fileFixture("b/y.txt", "yy"),
+		fileFixture("a/x/1.txt", "1"),
+		fileFixture("./a/2.txt", "222"),
+	))
+	entries, err := ListEntries(TAR_TYPE, r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := BuildTree(entries)
+	if root.Size != 6 || root.Files != 3 || root.Dirs != 3 {
+		t.Errorf("got root %+v", root)
+	}
+	// a/ and a/x/ have no entry of their own
+	want := strings.Join([]string{
+		"a/ 4 2 1 false",
+		"./a/2.txt 3 0 0 true",
+		"a/x/ 1 1 0 false",
+		"a/x/1.txt 1 0 0 true",
+		"b/ 2 1 0 true",
+		"b/y.txt 2 0 0 true",
+	}, "\n")
+	if got := treeLines(root); got != want {
+		t.Errorf("got tree\n%s\nwant\n%s", got, want)
+	}
+
+	if node := root.Find("a/x"); node == nil || node.Path != "a/x/" {
+		t.Errorf("found %+v for a/x", node)
+	}
+	if node := root.Find("a/missing"); node != nil {
+		t.Errorf("found %+v for a/missing", node)
+	}
+	root.Prune(1)
+	if got := treeLines(root); got != "a/ 4 2 1 false\nb/ 2 1 0 true" {
+		t.Errorf("got pruned tree\n%s", got)
+	}
+
+	entries[1].Size = -1
+	if root := BuildTree(entries); root.Size != -1 || root.Find("b").Size != -1 || root.Find("a").Size != 4 {
+		t.Errorf("unknown size of b/y.txt aggregated as %d and %d", root.Size, root.Find("b").Size)
+	}
+}
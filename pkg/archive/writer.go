@@ -0,0 +1,396 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Heng-Bian/httpreader"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	TAR_GZ_TYPE  = "tar.gz"
+	TAR_XZ_TYPE  = "tar.xz"
+	TAR_ZST_TYPE = "tar.zst"
+)
+
+const (
+	// DefaultCompression selects the default level of the output format
+	DefaultCompression = -1
+	// NoCompression stores entries where the format allows it and uses the
+	// fastest setting otherwise
+	NoCompression = 0
+	// BestCompression is the highest level accepted by NewArchiveWriter
+	BestCompression = 9
+)
+
+// maxLinknameSize limits the size of a symbolic link target stored as
+// content of an entry.
+const maxLinknameSize = 4096
+
+func ListSupportedOutputFormat() []string {
+	return []string{ZIP_TYPE, TAR_TYPE, TAR_GZ_TYPE, TAR_XZ_TYPE, TAR_ZST_TYPE}
+}
+
+// CheckOutput returns ErrUnknownSize if the entries of an archive cannot be
+// written in the output format for lack of their sizes, before anything is
+// written. That is tar output of a 7z archive whose header is encrypted or
+// compressed with another codec than LZMA and LZMA2. A rar archive may still
+// lack the size of a single file, which fails when it is written.
+func CheckOutput(format string, r *httpreader.Reader, output string) error {
+	if format != SEVEN_Z_TYPE || output == ZIP_TYPE {
+		return nil
+	}
+	header, err := readSevenZHeader(r, &ArchiveInfo{})
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return fmt.Errorf("%w, the 7z header is encrypted or compressed with an unsupported codec", ErrUnknownSize)
+	}
+	for i, size := range sevenZSizes(header) {
+		if size < 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownSize, header.FilesInfo[i].Name)
+		}
+	}
+	return nil
+}
+
+// OutputContentType returns the MIME type of an output format.
+func OutputContentType(format string) string {
+	switch format {
+	case ZIP_TYPE:
+		return ZIP_MIME_TYPE
+	case TAR_TYPE:
+		return TAR_MIME_TYPE
+	case TAR_GZ_TYPE:
+		return GZIP_MIME_TYPE
+	case TAR_XZ_TYPE:
+		return XZ_MIME_TYPE
+	case TAR_ZST_TYPE:
+		return "application/zstd"
+	}
+	return DEFALUT_MIME
+}
+
+// ArchiveWriter writes entries into a new archive.
+type ArchiveWriter interface {
+	// WriteEntry adds entry with the content read from r
+	WriteEntry(entry *Entry, r io.Reader) error
+	// Close finishes the archive without closing the underlying writer
+	Close() error
+}
+
+// NewArchiveWriter returns an ArchiveWriter writing an archive in the given
+// output format to w. level ranges from NoCompression to BestCompression,
+// or is DefaultCompression, and is mapped to the levels of each compressor.
+func NewArchiveWriter(w io.Writer, format string, level int) (ArchiveWriter, error) {
+	if level < DefaultCompression || level > BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d, must be between %d and %d", level, NoCompression, BestCompression)
+	}
+	switch format {
+	case ZIP_TYPE:
+		return newZipArchiveWriter(w, level), nil
+	case TAR_TYPE:
+		return newTarArchiveWriter(w, nil), nil
+	case TAR_GZ_TYPE:
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(gz, gz), nil
+	case TAR_XZ_TYPE:
+		config := xz.WriterConfig{}
+		if level != DefaultCompression {
+			// the dictionary sizes of the xz presets
+			config.DictCap = 1 << xzDictCapShift[level]
+		}
+		xw, err := config.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(xw, xw), nil
+	case TAR_ZST_TYPE:
+		options := []zstd.EOption{}
+		if level != DefaultCompression {
+			// zstd levels range from 1 to 22, 9 is already slow
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level*2)))
+		}
+		zw, err := zstd.NewWriter(w, options...)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(zw, zw), nil
+	case SEVEN_Z_TYPE, RAR_TYPE:
+		// there is no encoder of these formats, they are only read
+		return nil, errors.New("do not support output format " + format + ", it can only be read")
+	}
+	return nil, errors.New("do not support output format " + format)
+}
+
+var xzDictCapShift = [BestCompression + 1]int{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+	// store entries without compression
+	store bool
+}
+
+func newZipArchiveWriter(w io.Writer, level int) *zipArchiveWriter {
+	zw := zip.NewWriter(w)
+	if level > NoCompression {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return &zipArchiveWriter{w: zw, store: level == NoCompression}
+}
+
+func (z *zipArchiveWriter) WriteEntry(entry *Entry, r io.Reader) error {
+	header, err := zip.FileInfoHeader(entryInfo{entry})
+	if err != nil {
+		return err
+	}
+	header.Name = entry.Name
+	header.Method = zip.Deflate
+	if z.store {
+		header.Method = zip.Store
+	} else if !entry.IsDir && entry.Mode&fs.ModeSymlink == 0 {
+		// deflating already compressed content only wastes time
+		br := bufio.NewReaderSize(r, sniffLen)
+		head, _ := br.Peek(sniffLen)
+		if incompressible(head) {
+			header.Method = zip.Store
+		}
+		r = br
+	}
+	if entry.IsDir {
+		if !strings.HasSuffix(header.Name, "/") {
+			header.Name = header.Name + "/"
+		}
+		header.Method = zip.Store
+	}
+	w, err := z.w.CreateHeader(header)
+	if err != nil || entry.IsDir {
+		return err
+	}
+	// zip stores the target of a symbolic link as content
+	if entry.Mode&fs.ModeSymlink != 0 && entry.Linkname != "" {
+		_, err = io.WriteString(w, entry.Linkname)
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.w.Close()
+}
+
+type tarArchiveWriter struct {
+	w *tar.Writer
+	// compressor is closed after w if not nil
+	compressor io.WriteCloser
+	// files are the names of the files written, which hard links can target
+	files map[string]bool
+}
+
+func newTarArchiveWriter(w io.Writer, compressor io.WriteCloser) *tarArchiveWriter {
+	return &tarArchiveWriter{w: tar.NewWriter(w), compressor: compressor, files: make(map[string]bool)}
+}
+
+// WriteEntry writes a hard link whose target is already in the output as a
+// hard link, and as a file with the content read from r otherwise, which is
+// empty for a hard link of a tar source. The content that cannot be read is
+// replaced with zeros.
+func (t *tarArchiveWriter) WriteEntry(entry *Entry, r io.Reader) error {
+	if isHardLink(entry) && t.files[entry.Linkname] {
+		header, err := tar.FileInfoHeader(entryInfo{entry}, "")
+		if err != nil {
+			return err
+		}
+		header.Typeflag = tar.TypeLink
+		header.Name = entry.Name
+		header.Linkname = entry.Linkname
+		header.Size = 0
+		header.Uid = entry.Uid
+		header.Gid = entry.Gid
+		t.files[header.Name] = true
+		return t.w.WriteHeader(header)
+	}
+	var linkname string
+	isLink := entry.Mode&fs.ModeSymlink != 0
+	if isLink {
+		var err error
+		if linkname, err = linkTarget(entry, r); err != nil {
+			return err
+		}
+	}
+	size := entry.Size
+	if !entry.IsDir && !isLink && size < 0 {
+		// tar needs the size before the content, which is streamed
+		return fmt.Errorf("%w: %s", ErrUnknownSize, entry.Name)
+	}
+	info := entryInfo{entry}
+	header, err := tar.FileInfoHeader(info, linkname)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.Name
+	if entry.IsDir && !strings.HasSuffix(header.Name, "/") {
+		header.Name = header.Name + "/"
+	}
+	header.Uid = entry.Uid
+	header.Gid = entry.Gid
+	if header.Typeflag == tar.TypeReg {
+		header.Size = size
+	}
+	if err := t.w.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	t.files[header.Name] = true
+	n, err := io.CopyN(t.w, r, size)
+	if err != nil && n < size {
+		// fill the rest of an entry that cannot be read with zeros, so the
+		// output stays readable and can still end with a manifest
+		io.CopyN(t.w, zeroReader{}, size-n)
+	}
+	return err
+}
+
+// zeroReader reads zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// isHardLink reports whether entry is a hard link, stored by tar with the
+// name of its target in Linkname and no content.
+func isHardLink(entry *Entry) bool {
+	return entry.Linkname != "" && !entry.IsDir && entry.Mode&fs.ModeSymlink == 0
+}
+
+// linkTarget returns the target of the symbolic link entry whose content
+// is r, read from the content for the formats storing it there.
+func linkTarget(entry *Entry, r io.Reader) (string, error) {
+	if entry.Linkname != "" {
+		return entry.Linkname, nil
+	}
+	target, err := io.ReadAll(io.LimitReader(r, maxLinknameSize))
+	return string(target), err
+}
+
+func (t *tarArchiveWriter) Close() error {
+	err := t.w.Close()
+	if t.compressor != nil {
+		if closeErr := t.compressor.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// sniffLen is the number of bytes used to detect the type of content.
+const sniffLen = 512
+
+// compressedMimeTypes are types whose content is already compressed.
+var compressedMimeTypes = map[string]bool{
+	"image/jpeg":                        true,
+	"image/png":                         true,
+	"image/gif":                         true,
+	"image/webp":                        true,
+	"audio/mpeg":                        true,
+	"audio/ogg":                         true,
+	"audio/aac":                         true,
+	"audio/flac":                        true,
+	"video/mp4":                         true,
+	"video/webm":                        true,
+	"video/quicktime":                   true,
+	"video/x-matroska":                  true,
+	ZIP_MIME_TYPE:                       true,
+	GZIP_MIME_TYPE:                      true,
+	BZIP2_MIME_TYPE:                     true,
+	XZ_MIME_TYPE:                        true,
+	RAR_MIME_TYPE:                       true,
+	SEVEN_Z_MIME_TYPE:                   true,
+	"application/zstd":                  true,
+	"application/x-compress":            true,
+	"application/vnd.ms-cab-compressed": true,
+}
+
+// incompressible reports whether head is the start of already compressed
+// content, including formats based on one, eg. docx is a zip.
+func incompressible(head []byte) bool {
+	for mime := mimetype.Detect(head); mime != nil; mime = mime.Parent() {
+		if compressedMimeTypes[mime.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// entryInfo adapts an Entry to fs.FileInfo for zip.FileInfoHeader and
+// tar.FileInfoHeader.
+type entryInfo struct {
+	entry *Entry
+}
+
+func (i entryInfo) Name() string {
+	return path.Base(strings.TrimSuffix(i.entry.Name, "/"))
+}
+
+func (i entryInfo) Size() int64 {
+	if i.entry.Size < 0 {
+		return 0
+	}
+	return i.entry.Size
+}
+
+func (i entryInfo) Mode() fs.FileMode {
+	mode := i.entry.Mode
+	if i.entry.IsDir {
+		mode |= fs.ModeDir
+	}
+	if mode.Perm() == 0 {
+		if mode.IsDir() {
+			mode |= 0755
+		} else {
+			mode |= 0644
+		}
+	}
+	return mode
+}
+
+func (i entryInfo) ModTime() time.Time {
+	if i.entry.ModTime.IsZero() {
+		return time.Now()
+	}
+	return i.entry.ModTime
+}
+
+func (i entryInfo) IsDir() bool {
+	return i.entry.IsDir
+}
+
+func (i entryInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,151 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackHardLinks(t *testing.T) {
+	r := openFixture(t, "a.tar", tarFixture(t,
+		fileFixture("dir/a.txt", "hello\n"),
+		fixture{name: "dir/b.txt", typeflag: tar.TypeLink, linkname: "dir/a.txt"},
+		fixture{name: "c.txt", typeflag: tar.TypeLink, linkname: "dir/a.txt"},
+	))
+
+	for _, test := range []struct {
+		name      string
+		selection Selection
+		// the type and link name of every output entry by name
+		want map[string][2]string
+	}{
+		{
+			name:      "target in the output",
+			selection: Selection{StripPrefix: "dir/", Rewrite: []PrefixRewrite{{From: "a", To: "x"}}},
+			want: map[string][2]string{
+				"x.txt": {string(tar.TypeReg), ""},
+				"b.txt": {string(tar.TypeLink), "x.txt"},
+				"c.txt": {string(tar.TypeLink), "x.txt"},
+			},
+		},
+		{
+			name:      "target not in the output",
+			selection: Selection{Names: []string{"c.txt"}},
+			want: map[string][2]string{
+				"c.txt": {string(tar.TypeReg), ""},
+			},
+		},
+	} {
+		sel, err := test.selection.Compile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if _, err := Pack(TAR_TYPE, &buf, r, sel, "", TAR_TYPE, DefaultCompression, ""); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		got := map[string][2]string{}
+		tr := tar.NewReader(&buf)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %s", test.name, err)
+			}
+			got[header.Name] = [2]string{string(header.Typeflag), header.Linkname}
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+		for name, want := range test.want {
+			if got[name] != want {
+				t.Errorf("%s: %s got %q, want %q", test.name, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestPackResult(t *testing.T) {
+	files := []fixture{fileFixture("a.txt", "hello\n"), fileFixture("b.txt", strings.Repeat("b", 1000))}
+	tarData := tarFixture(t, files...)
+	zipData := zipFixture(t, files...)
+	sel := NamesSelector([]string{"a.txt", "b.txt", "missing.txt"})
+	for _, test := range []struct {
+		name   string
+		format string
+		data   []byte
+		level  int
+		want   PackResult
+		// packed are the entries in the output besides the manifest, a cut
+		// entry included
+		packed string
+	}{
+		{"tar", TAR_TYPE, tarData, DefaultCompression, PackResult{Packed: 2, Missing: []string{"missing.txt"}}, "a.txt b.txt"},
+		{"tar cut in an entry", TAR_TYPE, tarData[:len(tarData)-tarTrailerSize-100], DefaultCompression,
+			PackResult{Packed: 1, Missing: []string{"missing.txt"}, Failed: "b.txt", Error: io.ErrUnexpectedEOF.Error()}, "a.txt b.txt"},
+		{"zip", ZIP_TYPE, zipData, DefaultCompression, PackResult{Packed: 2, Missing: []string{"missing.txt"}}, "a.txt b.txt"},
+		// entries copied without decompressing are not checked, the others
+		// are
+		{"zip with a wrong checksum copied", ZIP_TYPE, corruptZipCRC(t, zipData, "a.txt"), DefaultCompression,
+			PackResult{Packed: 2, Missing: []string{"missing.txt"}}, "a.txt b.txt"},
+		{"zip with a wrong checksum", ZIP_TYPE, corruptZipCRC(t, zipData, "a.txt"), 1,
+			PackResult{Missing: []string{"missing.txt"}, Failed: "a.txt", Error: "zip: checksum error"}, "a.txt"},
+	} {
+		var buf bytes.Buffer
+		res, err := Pack(test.format, &buf, openFixture(t, "a."+test.format, test.data), sel, "", test.format, test.level, "manifest.json")
+		if (err != nil) != (test.want.Error != "") {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+		if res == nil || !reflect.DeepEqual(*res, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, res, test.want)
+			continue
+		}
+
+		// the output ends with the manifest, also when packing failed
+		var packed []string
+		var manifest PackResult
+		err = Walk(test.format, openFixture(t, "out."+test.format, buf.Bytes()), "", func(entry *Entry, r io.Reader) error {
+			if entry.Name != "manifest.json" {
+				packed = append(packed, entry.Name)
+				return nil
+			}
+			return json.NewDecoder(r).Decode(&manifest)
+		})
+		if err != nil {
+			t.Errorf("%s: invalid output,err:%s", test.name, err)
+		}
+		if strings.Join(packed, " ") != test.packed || !reflect.DeepEqual(manifest, test.want) {
+			t.Errorf("%s: got %q and manifest %+v", test.name, packed, manifest)
+		}
+	}
+}
+
+func TestTarUnknownSize(t *testing.T) {
+	for _, output := range []string{TAR_TYPE, TAR_GZ_TYPE, ZIP_TYPE} {
+		var buf bytes.Buffer
+		aw, err := NewArchiveWriter(&buf, output, DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = aw.WriteEntry(&Entry{Name: "a.txt", Size: -1, Mode: 0644}, bytes.NewReader([]byte("hello\n")))
+		if output == ZIP_TYPE {
+			if err != nil {
+				t.Errorf("zip of an entry of unknown size: %s", err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrUnknownSize) {
+			t.Errorf("%s of an entry of unknown size: got %v, want ErrUnknownSize", output, err)
+		}
+	}
+}
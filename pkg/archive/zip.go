@@ -2,10 +2,10 @@ package archive
 
 import (
 	"archive/zip"
-	"errors"
+	"encoding/binary"
 	"github.com/Heng-Bian/httpreader"
 	"io"
-	"sort"
+	"strings"
 )
 
 func ListZipFiles(r *httpreader.Reader, charset string) (files []string, err error) {
@@ -15,19 +15,7 @@ func ListZipFiles(r *httpreader.Reader, charset string) (files []string, err err
 	if err != nil {
 		return fileNames, err
 	}
-	for _, file := range zipReader.File {
-		if charset == "" {
-			fileNames = append(fileNames, file.Name)
-		} else {
-			str, err := DecodeString(file.Name, charset)
-			if err == nil {
-				fileNames = append(fileNames, str)
-			} else {
-				fileNames = append(fileNames, file.Name)
-			}
-		}
-	}
-
+	fileNames, _ = zipNames(zipReader.File, charset)
 	return fileNames, nil
 }
 
@@ -37,19 +25,13 @@ func UnzipByFileName(r *httpreader.Reader, name string, charset string) (io.Read
 	if err != nil {
 		return nil, err
 	}
-	for _, file := range zipReader.File {
-		fileName := file.Name
-		if charset != "" {
-			decodeStr, err := DecodeString(fileName, charset)
-			if err == nil {
-				fileName = decodeStr
-			}
-		}
+	fileNames, _ := zipNames(zipReader.File, charset)
+	for i, fileName := range fileNames {
 		if fileName == name {
-			return file.Open()
+			return openZipFile(zipReader.File[i])
 		}
 	}
-	return nil, errors.New("file not foud in the zip archive")
+	return nil, ErrFileNotFound
 
 }
 
@@ -59,40 +41,162 @@ func UnzipByFileIndex(r *httpreader.Reader, index int) (io.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	if index < 0 || index >= len(zipReader.File) {
+		return nil, ErrOutOfBoundary
+	}
+	return openZipFile(zipReader.File[index])
+}
+
+// unzipByOffset opens the entry whose local header is at off.
+func unzipByOffset(r *httpreader.Reader, off int64) (io.Reader, error) {
+	zipReader, dir, err := openZip(r)
 	if err != nil {
 		return nil, err
 	}
-	if index > len(zipReader.File) {
-		return nil, ErrOutOfBoundary
+	i := dir.index(off)
+	if i < 0 {
+		return nil, ErrFileNotFound
 	}
-	return zipReader.File[index].Open()
+	return openZipFile(zipReader.File[i])
+}
+
+func openZipFile(file *zip.File) (io.Reader, error) {
+	if strings.HasSuffix(file.Name, "/") {
+		return nil, ErrIsDir
+	}
+	return file.Open()
 }
 
 func ZipToZip(w io.Writer, r *httpreader.Reader, names []string, charset string) error {
-	zipReader, err := zip.NewReader(r, r.Length)
+	_, err := zipToZip(w, r, NamesSelector(names), charset, DefaultCompression, "")
+	return err
+}
+
+// zipToZip copies the selected entries with their original headers. With
+// DefaultCompression the compressed data is copied as is, otherwise the
+// entries are recompressed at level.
+func zipToZip(w io.Writer, r *httpreader.Reader, sel *Selector, charset string, level int, manifest string) (*PackResult, error) {
+	zipReader, dir, err := openZip(r)
+	if err != nil {
+		return nil, err
+	}
+	zw := newZipArchiveWriter(w, level)
+	zw.w.SetComment(zipReader.Comment)
+	res := &PackResult{}
+	var found []Entry
+	fileNames, _ := zipNames(zipReader.File, charset)
+	for i, file := range zipReader.File {
+		fileName := fileNames[i]
+		entry := zipEntry(file, fileName, dir, i)
+		if !sel.MatchEntry(entry) {
+			continue
+		}
+		found = append(found, *entry)
+		outName := sel.Rename(fileName)
+		if outName == "" || res.Failed != "" {
+			// after a failure the remaining entries are only looked for, err
+			// keeps the reason
+			continue
+		}
+		if level == DefaultCompression {
+			err = copyZipFile(zw.w, file, outName)
+		} else {
+			zr := &lazyReader{open: file.Open}
+			renamed := *entry
+			renamed.Name = outName
+			err = zw.WriteEntry(&renamed, zr)
+			zr.Close()
+		}
+		if err != nil {
+			res.Failed = fileName
+			continue
+		}
+		res.Packed++
+	}
+	return finishPack(zw, res, sel.Missing(found), manifest, err)
+}
+
+// copyZipFile copies file to zipWriter under name without decompressing it.
+func copyZipFile(zipWriter *zip.Writer, file *zip.File, name string) error {
+	header := file.FileHeader
+	if name != file.Name {
+		// the decoded name is utf-8
+		header.Name = name
+		header.Flags |= zipUTF8Flag
+		header.NonUTF8 = false
+		// readers honoring the Unicode Path would show the old name
+		header.Extra = withoutZipExtra(header.Extra, zipUnicodePathExtra)
+	}
+	raw, err := file.OpenRaw()
 	if err != nil {
 		return err
 	}
-	zipWriter := zip.NewWriter(w)
-	sort.Strings(names)
-	for _, file := range zipReader.File {
-		fileName := file.Name
-		if charset != "" {
-			decodeStr, err := DecodeString(fileName, charset)
-			if err == nil {
-				fileName = decodeStr
-			}
+	zw, err := zipWriter.CreateRaw(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zw, raw)
+	return err
+}
+
+// withoutZipExtra returns the extra fields of extra but those of the given
+// tag. A truncated field and what follows it are kept as is.
+func withoutZipExtra(extra []byte, tag uint16) []byte {
+	kept := make([]byte, 0, len(extra))
+	for len(extra) >= 4 {
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
 		}
-		if Exists(names, fileName) {
-			zr, err := file.Open()
-			if err == nil {
-				zw, err := zipWriter.Create(fileName)
-				if err == nil {
-					io.Copy(zw, zr)
-				}
-			}
+		if binary.LittleEndian.Uint16(extra) != tag {
+			kept = append(kept, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+	return append(kept, extra...)
+}
+
+// zipUTF8Flag is the general purpose flag bit telling that the name and
+// comment of a zip entry are utf-8.
+const zipUTF8Flag = 0x800
+
+// zipEntry describes the entry at index, its ID is the offset of its local
+// header if known.
+func zipEntry(file *zip.File, name string, dir *zipDirectory, index int) *Entry {
+	id := entryID(indexID, int64(index))
+	if off := dir.offset(index); off >= 0 {
+		id = entryID(offsetID, off)
+	}
+	return &Entry{
+		Name:    name,
+		Size:    int64(file.UncompressedSize64),
+		Mode:    file.Mode(),
+		ModTime: file.Modified,
+		IsDir:   strings.HasSuffix(name, "/"),
+		CRC32:   file.CRC32,
+		ID:      id,
+	}
+}
+
+func WalkZip(r *httpreader.Reader, charset string, fn WalkFunc) error {
+	_, err := walkZip(r, charset, fn)
+	return err
+}
+
+// walkZip is WalkZip also returning the charset of the names.
+func walkZip(r *httpreader.Reader, charset string, fn WalkFunc) (string, error) {
+	zipReader, dir, err := openZip(r)
+	if err != nil {
+		return "", err
+	}
+	fileNames, charset := zipNames(zipReader.File, charset)
+	for i, file := range zipReader.File {
+		zr := &lazyReader{open: file.Open}
+		err := fn(zipEntry(file, fileNames[i], dir, i), zr)
+		zr.Close()
+		if err != nil {
+			return charset, err
 		}
 	}
-	zipWriter.Close()
-	return nil
+	return charset, nil
 }
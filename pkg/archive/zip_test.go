@@ -0,0 +1,51 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// unicodePathExtra returns an Info-ZIP Unicode Path extra field giving name
+// to the entry named header.
+func unicodePathExtra(header string, name string) []byte {
+	field := make([]byte, 9, 9+len(name))
+	binary.LittleEndian.PutUint16(field, zipUnicodePathExtra)
+	binary.LittleEndian.PutUint16(field[2:], uint16(5+len(name)))
+	field[4] = 1
+	binary.LittleEndian.PutUint32(field[5:], crc32.ChecksumIEEE([]byte(header)))
+	return append(field, name...)
+}
+
+func TestPackZipUnicodePath(t *testing.T) {
+	// another extra field, kept as is
+	other := []byte{0xfe, 0xca, 2, 0, 'h', 'i'}
+	r := openFixture(t, "a.zip", zipFixture(t,
+		fixture{name: "dir/a.txt", content: "hello\n", extra: append(unicodePathExtra("dir/a.txt", "dir/a.txt"), other...)},
+		fixture{name: "dir/b.txt", content: "bye\n", extra: unicodePathExtra("dir/b.txt", "dir/b.txt")},
+	))
+	sel, err := (&Selection{StripPrefix: "dir/", Rewrite: []PrefixRewrite{{From: "a", To: "c"}}}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := Pack(ZIP_TYPE, &buf, r, sel, "", ZIP_TYPE, DefaultCompression, ""); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, _ := zipNames(zr.File, "")
+	if len(names) != 2 || names[0] != "c.txt" || names[1] != "b.txt" {
+		t.Fatalf("got names %q", names)
+	}
+	if !bytes.Equal(zr.File[0].Extra, other) {
+		t.Errorf("c.txt: got extra %x, want %x", zr.File[0].Extra, other)
+	}
+	if len(zr.File[1].Extra) != 0 {
+		t.Errorf("b.txt: got extra %x", zr.File[1].Extra)
+	}
+}
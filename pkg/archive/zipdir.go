@@ -0,0 +1,198 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/Heng-Bian/httpreader"
+)
+
+const (
+	zipDirSignature              = "PK\x01\x02"
+	zip64LocatorSignature        = "PK\x06\x07"
+	zip64EndSignature            = "PK\x06\x06"
+	zip64LocatorLen              = 20
+	zip64EndLen                  = 56
+	zipDirHeaderLen              = 46
+	zip64Extra                   = 0x0001
+	zipMax32              uint32 = 0xffffffff
+)
+
+// zipDirectory keeps the end of a zip archive from its central directory
+// on, so the directory is fetched once to open the archive and to find the
+// local header offsets archive/zip does not export.
+type zipDirectory struct {
+	r *httpreader.Reader
+	// buf holds the bytes of r from off to the end
+	off int64
+	buf []byte
+	// offsets are the local header offsets of the entries in the order of
+	// the directory, nil if the directory could not be parsed
+	offsets []int64
+}
+
+// openZip opens the zip archive and reads the local header offsets of its
+// entries. The offsets are nil rather than an error if they cannot be read,
+// eg. for an archive with data prepended.
+func openZip(r *httpreader.Reader) (*zip.Reader, *zipDirectory, error) {
+	dir := &zipDirectory{r: r}
+	if err := dir.read(); err != nil {
+		dir.buf = nil
+	}
+	zipReader, err := zip.NewReader(dir, r.Length)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dir.offsets) != len(zipReader.File) {
+		dir.offsets = nil
+	}
+	return zipReader, dir, nil
+}
+
+func (d *zipDirectory) ReadAt(p []byte, off int64) (int, error) {
+	if off >= d.off && off+int64(len(p)) <= d.off+int64(len(d.buf)) {
+		n := copy(p, d.buf[off-d.off:])
+		if off+int64(n) == d.r.Length {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	return d.r.ReadAt(p, off)
+}
+
+// offset returns the local header offset of the entry at index, -1 if
+// unknown.
+func (d *zipDirectory) offset(index int) int64 {
+	if index < 0 || index >= len(d.offsets) {
+		return -1
+	}
+	return d.offsets[index]
+}
+
+// index returns the index of the entry whose local header is at off, -1 if
+// there is none.
+func (d *zipDirectory) index(off int64) int {
+	for i, offset := range d.offsets {
+		if offset == off {
+			return i
+		}
+	}
+	return -1
+}
+
+// read fetches the central directory and parses the offsets of its
+// records.
+func (d *zipDirectory) read() error {
+	tail, tailOff, end, err := readZipEnd(d.r)
+	if err != nil {
+		return err
+	}
+	d.off, d.buf = tailOff, tail
+	size := int64(binary.LittleEndian.Uint32(tail[end+12:]))
+	dirOff := int64(binary.LittleEndian.Uint32(tail[end+16:]))
+	locator := end - zip64LocatorLen
+	if locator >= 0 && string(tail[locator:locator+4]) == zip64LocatorSignature {
+		recordOff := int64(binary.LittleEndian.Uint64(tail[locator+8:]))
+		record := make([]byte, zip64EndLen)
+		if _, err := d.ReadAt(record, recordOff); err != nil && err != io.EOF {
+			return err
+		}
+		if string(record[:4]) != zip64EndSignature {
+			return errors.New("zip: invalid zip64 end of central directory")
+		}
+		size = int64(binary.LittleEndian.Uint64(record[40:]))
+		dirOff = int64(binary.LittleEndian.Uint64(record[48:]))
+	}
+	if dirOff < 0 || size < 0 || dirOff+size > d.r.Length {
+		return errors.New("zip: invalid central directory")
+	}
+	if dirOff < d.off {
+		buf := make([]byte, d.off-dirOff)
+		if _, err := d.r.ReadAt(buf, dirOff); err != nil && err != io.EOF {
+			return err
+		}
+		d.off, d.buf = dirOff, append(buf, d.buf...)
+	}
+	d.offsets, err = zipOffsets(d.buf[dirOff-d.off : dirOff-d.off+size])
+	return err
+}
+
+// readZipEnd reads the end of a zip archive long enough to hold the end of
+// central directory record with the longest comment. It returns the bytes
+// read, their offset and the index of the record in them.
+func readZipEnd(r *httpreader.Reader) ([]byte, int64, int, error) {
+	tailLen := int64(zipEndLen + 0xffff)
+	if tailLen > r.Length {
+		tailLen = r.Length
+	}
+	tail := make([]byte, tailLen)
+	n, err := r.ReadAt(tail, r.Length-tailLen)
+	if err != nil && (err != io.EOF || int64(n) != tailLen) {
+		return nil, 0, 0, err
+	}
+	i := bytes.LastIndex(tail, []byte(zipEndSignature))
+	if i < 0 || len(tail)-i < zipEndLen {
+		return nil, 0, 0, errors.New("zip: not a valid zip file")
+	}
+	return tail, r.Length - tailLen, i, nil
+}
+
+// zipOffsets parses the local header offsets of the records of a central
+// directory.
+func zipOffsets(dir []byte) ([]int64, error) {
+	offsets := make([]int64, 0, 10)
+	for len(dir) >= 4 && string(dir[:4]) == zipDirSignature {
+		if len(dir) < zipDirHeaderLen {
+			return nil, errors.New("zip: truncated central directory")
+		}
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		recordLen := zipDirHeaderLen + nameLen + extraLen + commentLen
+		if len(dir) < recordLen {
+			return nil, errors.New("zip: truncated central directory")
+		}
+		offset := int64(binary.LittleEndian.Uint32(dir[42:]))
+		if uint32(offset) == zipMax32 {
+			extra := dir[zipDirHeaderLen+nameLen : zipDirHeaderLen+nameLen+extraLen]
+			// the zip64 extra field holds the values that overflowed, in
+			// the order uncompressed size, compressed size, offset
+			skip := 0
+			if binary.LittleEndian.Uint32(dir[24:]) == zipMax32 {
+				skip += 8
+			}
+			if binary.LittleEndian.Uint32(dir[20:]) == zipMax32 {
+				skip += 8
+			}
+			offset = zip64Offset(extra, skip)
+			if offset < 0 {
+				return nil, errors.New("zip: invalid zip64 extra field")
+			}
+		}
+		offsets = append(offsets, offset)
+		dir = dir[recordLen:]
+	}
+	return offsets, nil
+}
+
+// zip64Offset returns the offset stored in the zip64 extra field after skip
+// bytes of sizes, -1 if there is none.
+func zip64Offset(extra []byte, skip int) int64 {
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		if tag == zip64Extra && len(field) >= skip+8 {
+			return int64(binary.LittleEndian.Uint64(field[skip:]))
+		}
+	}
+	return -1
+}
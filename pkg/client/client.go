@@ -0,0 +1,514 @@
+// Package client talks to a running archive-server over HTTP.
+//
+//	c := client.New("http://localhost:8080")
+//	res, err := c.List(ctx, "https://example.com/big.zip", &client.ListOptions{Prefix: "go/", Depth: 1})
+//	body, err := c.Stream(ctx, "https://example.com/big.zip", "go/README.md", nil)
+//	var apiErr *client.Error
+//	if errors.As(err, &apiErr) { ... }
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound matches, by errors.Is, an *Error reporting that the requested
+// entry does not exist in the archive.
+var ErrNotFound = errors.New("entry not found in archive")
+
+// Error is returned when the archive-server answers with a non 2xx status.
+type Error struct {
+	// Method and Path of the failed request, eg. GET /list
+	Method string
+	Path   string
+
+	StatusCode int
+	// Message is the error message sent by the server
+	Message string
+	// Missing are the requested entries not in the archive of a strict Pack
+	Missing []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether the error matches target, so that
+// errors.Is(err, ErrNotFound) works.
+func (e *Error) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// PackError is returned by the body of Pack, and of Stream of a directory,
+// at the end of an archive that is incomplete. The status is only known
+// after the archive is sent, from the X-Pack trailers.
+type PackError struct {
+	// Status is failed if packing stopped early, incomplete if requested
+	// entries are not in the archive
+	Status string
+	// Entries is the number of entries written
+	Entries int
+	// Missing is the number of requested entries not in the archive
+	Missing int
+	// Message is the reason packing stopped
+	Message string
+}
+
+func (e *PackError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("pack %s after %d entries: %s", e.Status, e.Entries, e.Message)
+	}
+	return fmt.Sprintf("pack %s: %d entries written, %d missing", e.Status, e.Entries, e.Missing)
+}
+
+// Client calls the /list, /stream, /pack, /test and /info API of an
+// archive-server.
+type Client struct {
+	// Server is the base URL of the archive-server, eg. http://localhost:8080
+	Server string
+
+	// HTTPClient is used to send requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// ArchiveStruct mirrors the response of /list.
+type ArchiveStruct struct {
+	FileType string
+	Files    []string
+	// Charset the names were decoded with
+	Charset string
+	// Entries describe Files
+	Entries []Entry
+	// Total is the number of entries of all pages
+	Total int
+	// NextCursor is the Cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// Entry mirrors an entry of the response of /list.
+type Entry struct {
+	Name string
+	// Size is -1 if unknown
+	Size int64
+	// Mode is a Unix st_mode, see FileMode
+	Mode     uint32
+	ModTime  time.Time
+	IsDir    bool
+	Uid      int
+	Gid      int
+	Linkname string
+	CRC32    uint32
+	// ID selects the entry in StreamID and PackRequest.IDs
+	ID string
+}
+
+// FileMode converts the Unix st_mode of the entry to an fs.FileMode.
+func (e *Entry) FileMode() fs.FileMode {
+	m := fs.FileMode(e.Mode).Perm()
+	if e.Mode&04000 != 0 {
+		m |= fs.ModeSetuid
+	}
+	if e.Mode&02000 != 0 {
+		m |= fs.ModeSetgid
+	}
+	if e.Mode&01000 != 0 {
+		m |= fs.ModeSticky
+	}
+	switch e.Mode & 0170000 {
+	case 0010000:
+		m |= fs.ModeNamedPipe
+	case 0020000:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case 0040000:
+		m |= fs.ModeDir
+	case 0060000:
+		m |= fs.ModeDevice
+	case 0120000:
+		m |= fs.ModeSymlink
+	case 0140000:
+		m |= fs.ModeSocket
+	}
+	return m
+}
+
+// TestStruct mirrors the response of /test.
+type TestStruct struct {
+	FileType string
+	OK       bool
+	Entries  []EntryTest
+	Passed   int
+	Failed   int
+	Bytes    int64
+	Error    string
+}
+
+// EntryTest is the outcome of testing a single entry.
+type EntryTest struct {
+	Name  string
+	Size  int64
+	Read  int64
+	OK    bool
+	Error string
+}
+
+// InfoStruct mirrors the response of /info. The archive is only described
+// if the upstream accepts ranges.
+type InfoStruct struct {
+	FileType string
+	Upstream UpstreamStruct
+	Entries  int
+	Files    int
+	Dirs     int
+	// Size is the total uncompressed size of the entries, -1 if unknown
+	Size int64
+	// CompressedSize is the total size of the compressed entries, -1 if
+	// unknown
+	CompressedSize int64
+	Ratio          float64
+	Solid          bool
+	Encrypted      bool
+	MultiVolume    bool
+	Comment        string
+	Charset        string
+}
+
+// UpstreamStruct describes the archive as served by the upstream.
+type UpstreamStruct struct {
+	// Size is the size of the archive, -1 if unknown
+	Size         int64
+	ETag         string
+	LastModified string
+	ContentType  string
+	AcceptRanges bool
+}
+
+// Options are the query parameters shared by all requests. A nil *Options
+// is valid and means the server defaults.
+type Options struct {
+	// Charset of the entry names, eg. GBK, detected by default
+	Charset string
+	// Format of the archive (zip, tar, rar, 7z, ar, deb, cpio, rpm, iso, gzip,
+	// xz, bzip2), autodetected by default. oci reads a container image
+	// tarball as the merged filesystem of its layers.
+	Format string
+}
+
+// ListOptions are the query parameters of /list. A nil *ListOptions lists
+// every entry.
+type ListOptions struct {
+	Options
+	// Prefix only lists the entries under it, eg. go/src/
+	Prefix string
+	// Depth only lists the entries at most that many levels below Prefix,
+	// any depth if 0
+	Depth int
+	// Sort is name, size or date, the order of the archive by default
+	Sort string
+	// Desc sorts in descending order
+	Desc bool
+	// Limit is the number of entries of a page, all of them if 0
+	Limit int
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+}
+
+// StreamOptions are the query parameters of /stream.
+type StreamOptions struct {
+	Options
+	// Offset skips the first bytes of the entry to resume a download
+	Offset int64
+}
+
+// New returns a Client of the archive-server at server.
+func New(server string) *Client {
+	return &Client{Server: strings.TrimSuffix(server, "/")}
+}
+
+// List lists the entries of the archive at archiveURL, or one page of
+// them, with their metadata.
+func (c *Client) List(ctx context.Context, archiveURL string, opts *ListOptions) (*ArchiveStruct, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	q := query(archiveURL, &opts.Options)
+	// limit asks for the Entries even if it is 0
+	q.Set("limit", strconv.Itoa(opts.Limit))
+	if opts.Prefix != "" {
+		q.Set("prefix", opts.Prefix)
+	}
+	if opts.Depth > 0 {
+		q.Set("depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.Desc {
+		q.Set("order", "desc")
+	}
+	if opts.Cursor != "" {
+		q.Set("cursor", opts.Cursor)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/list", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := new(ArchiveStruct)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("fail to decode list response,err:%s", err)
+	}
+	return res, nil
+}
+
+// Stream returns the content of the named entry, or a zip of the subtree of
+// a directory which ends with a *PackError like Pack if it is incomplete.
+// The caller must close the returned body.
+func (c *Client) Stream(ctx context.Context, archiveURL string, name string, opts *StreamOptions) (io.ReadCloser, error) {
+	return c.stream(ctx, "/stream/"+escapePath(name), archiveURL, opts, nil)
+}
+
+// StreamIndex returns the content of the entry at index in the list of
+// entries. It is also the way to read single file formats such as gzip,
+// whose only entry has index 0. The caller must close the returned body.
+func (c *Client) StreamIndex(ctx context.Context, archiveURL string, index int, opts *StreamOptions) (io.ReadCloser, error) {
+	return c.stream(ctx, "/stream", archiveURL, opts, func(q url.Values) {
+		q.Set("index", strconv.Itoa(index))
+	})
+}
+
+// StreamID returns the content of the entry with the given ID, as listed
+// with listing parameters. IDs do not depend on the charset of the names.
+// The caller must close the returned body.
+func (c *Client) StreamID(ctx context.Context, archiveURL string, id string, opts *StreamOptions) (io.ReadCloser, error) {
+	return c.stream(ctx, "/stream", archiveURL, opts, func(q url.Values) {
+		q.Set("id", id)
+	})
+}
+
+func (c *Client) stream(ctx context.Context, path string, archiveURL string, opts *StreamOptions, set func(url.Values)) (io.ReadCloser, error) {
+	var q url.Values
+	if opts != nil {
+		q = query(archiveURL, &opts.Options)
+		if opts.Offset > 0 {
+			q.Set("offset", strconv.FormatInt(opts.Offset, 10))
+		}
+	} else {
+		q = query(archiveURL, nil)
+	}
+	if set != nil {
+		set(q)
+	}
+	resp, err := c.do(ctx, http.MethodGet, path, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &packBody{resp}, nil
+}
+
+// PackRequest chooses the entries of Pack and the archive they are written
+// to. An entry is selected when it is one of Names or IDs, matches one of
+// the Include globs or Regex expressions, or is under one of the Prefixes,
+// and matches none of the Exclude globs. Without any of them every entry is
+// selected.
+type PackRequest struct {
+	Options
+	Names    []string
+	IDs      []string
+	Include  []string
+	Exclude  []string
+	Regex    []string
+	Prefixes []string
+	// StripPrefix is removed from the start of the selected names
+	StripPrefix string
+	// Rewrite replaces the first matching prefix after StripPrefix
+	Rewrite []PrefixRewrite
+
+	// Strict fails with an *Error listing the Missing names and IDs before
+	// anything is sent
+	Strict bool
+	// Output is the format of the archive, zip, tar, tar.gz, tar.xz or
+	// tar.zst, zip by default
+	Output string
+	// Level is the compression level from 0 to 9, the default of Output if
+	// nil
+	Level *int
+	// Manifest is the name of an entry appended to the archive listing the
+	// result, none if empty
+	Manifest string
+}
+
+// PrefixRewrite replaces the prefix From of a name with To.
+type PrefixRewrite struct {
+	From string
+	To   string
+}
+
+// Pack returns an archive of the entries selected by req. Reading the body
+// to its end returns a *PackError instead of io.EOF if the archive is
+// incomplete. The caller must close the returned body.
+func (c *Client) Pack(ctx context.Context, archiveURL string, req *PackRequest) (io.ReadCloser, error) {
+	body, err := json.Marshal(struct {
+		Names       []string        `json:",omitempty"`
+		IDs         []string        `json:",omitempty"`
+		Include     []string        `json:",omitempty"`
+		Exclude     []string        `json:",omitempty"`
+		Regex       []string        `json:",omitempty"`
+		Prefixes    []string        `json:",omitempty"`
+		StripPrefix string          `json:",omitempty"`
+		Rewrite     []PrefixRewrite `json:",omitempty"`
+	}{req.Names, req.IDs, req.Include, req.Exclude, req.Regex, req.Prefixes, req.StripPrefix, req.Rewrite})
+	if err != nil {
+		return nil, err
+	}
+	q := query(archiveURL, &req.Options)
+	if req.Strict {
+		q.Set("strict", "true")
+	}
+	if req.Output != "" {
+		q.Set("output", req.Output)
+	}
+	if req.Level != nil {
+		q.Set("level", strconv.Itoa(*req.Level))
+	}
+	if req.Manifest != "" {
+		q.Set("manifest", req.Manifest)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/pack", q, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return &packBody{resp}, nil
+}
+
+// packBody reads the body of /pack or /stream and checks the X-Pack
+// trailers of an archive at its end.
+type packBody struct {
+	resp *http.Response
+}
+
+func (b *packBody) Read(p []byte) (int, error) {
+	n, err := b.resp.Body.Read(p)
+	if err == io.EOF {
+		// trailers are only sent with archives
+		switch status := b.resp.Trailer.Get("X-Pack-Status"); status {
+		case "", "complete":
+		default:
+			entries, _ := strconv.Atoi(b.resp.Trailer.Get("X-Pack-Entries"))
+			missing, _ := strconv.Atoi(b.resp.Trailer.Get("X-Pack-Missing"))
+			err = &PackError{
+				Status:  status,
+				Entries: entries,
+				Missing: missing,
+				Message: b.resp.Trailer.Get("X-Pack-Error"),
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *packBody) Close() error {
+	return b.resp.Body.Close()
+}
+
+// Test decompresses every entry of the archive on the server and reports
+// the broken ones. A corrupt archive is not an error, see TestStruct.OK.
+func (c *Client) Test(ctx context.Context, archiveURL string, opts *Options) (*TestStruct, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/test", query(archiveURL, opts), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := new(TestStruct)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("fail to decode test response,err:%s", err)
+	}
+	return res, nil
+}
+
+// Info describes the archive at archiveURL and its upstream.
+func (c *Client) Info(ctx context.Context, archiveURL string, opts *Options) (*InfoStruct, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/info", query(archiveURL, opts), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := new(InfoStruct)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, fmt.Errorf("fail to decode info response,err:%s", err)
+	}
+	return res, nil
+}
+
+func query(archiveURL string, opts *Options) url.Values {
+	q := url.Values{}
+	q.Set("url", archiveURL)
+	if opts != nil {
+		if opts.Charset != "" {
+			q.Set("charset", opts.Charset)
+		}
+		if opts.Format != "" {
+			q.Set("format", opts.Format)
+		}
+	}
+	return q
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, q url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.Server+path+"?"+q.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		apiErr := &Error{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+		// a strict /pack lists the missing entries as JSON
+		var missing struct {
+			Error   string
+			Missing []string
+		}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(msg, &missing) == nil {
+			apiErr.Message = missing.Error
+			apiErr.Missing = missing.Missing
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// escapePath escapes every element of an entry name, keeping the "/"
+// separators.
+func escapePath(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
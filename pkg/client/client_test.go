@@ -0,0 +1,173 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// reader returns a function reading the whole body returned by Stream or
+// Pack, failing t on any error.
+func reader(t *testing.T) func(io.ReadCloser, error) []byte {
+	return func(body io.ReadCloser, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func TestList(t *testing.T) {
+	c, archiveURL := newServer(t)
+	ctx := context.Background()
+	res, err := c.List(ctx, archiveURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.FileType != "zip" || res.Total != 3 || len(res.Files) != 3 || len(res.Entries) != 3 || res.NextCursor != "" {
+		t.Fatalf("unexpected listing %+v", res)
+	}
+	for _, entry := range res.Entries {
+		if entry.ID == "" {
+			t.Errorf("%s has no ID", entry.Name)
+		}
+		if entry.IsDir != entry.FileMode().IsDir() {
+			t.Errorf("%s: mode %o does not match IsDir", entry.Name, entry.Mode)
+		}
+	}
+
+	res, err = c.List(ctx, archiveURL, &ListOptions{Sort: "name", Desc: true, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 2 || res.Entries[0].Name != "dir/b.txt" || res.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", res)
+	}
+	res, err = c.List(ctx, archiveURL, &ListOptions{Sort: "name", Desc: true, Limit: 2, Cursor: res.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].Name != "a.txt" || res.NextCursor != "" {
+		t.Fatalf("unexpected last page %+v", res)
+	}
+
+	res, err = c.List(ctx, archiveURL, &ListOptions{Prefix: "dir/", Depth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].Name != "dir/b.txt" || res.Entries[0].Size != 3 {
+		t.Fatalf("unexpected directory listing %+v", res)
+	}
+}
+
+func TestStream(t *testing.T) {
+	c, archiveURL := newServer(t)
+	readAll := reader(t)
+	ctx := context.Background()
+	if data := readAll(c.Stream(ctx, archiveURL, "dir/b.txt", nil)); string(data) != "bb\n" {
+		t.Errorf("Stream: got %q", data)
+	}
+	if data := readAll(c.Stream(ctx, archiveURL, "a.txt", &StreamOptions{Offset: 2})); string(data) != "llo\n" {
+		t.Errorf("Stream with offset: got %q", data)
+	}
+	if data := readAll(c.StreamIndex(ctx, archiveURL, 0, nil)); string(data) != "hello\n" {
+		t.Errorf("StreamIndex: got %q", data)
+	}
+	res, err := c.List(ctx, archiveURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readAll(c.StreamID(ctx, archiveURL, res.Entries[2].ID, nil)); string(data) != "bb\n" {
+		t.Errorf("StreamID: got %q", data)
+	}
+	data := readAll(c.Stream(ctx, archiveURL, "dir/", nil))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil || len(zr.File) != 2 {
+		t.Errorf("Stream of a directory: got %d bytes,err:%v", len(data), err)
+	}
+
+	_, err = c.Stream(ctx, archiveURL, "missing.txt", nil)
+	var apiErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Stream of a missing entry: got %v", err)
+	}
+	_, err = c.StreamIndex(ctx, archiveURL, 9, nil)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("StreamIndex out of range: got %v", err)
+	}
+}
+
+func TestError(t *testing.T) {
+	c, archiveURL := newServer(t)
+	_, err := c.List(context.Background(), archiveURL, &ListOptions{Sort: "color"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Method != http.MethodGet || apiErr.Path != "/list" || apiErr.Message == "" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("400 matches ErrNotFound")
+	}
+}
+
+func TestPack(t *testing.T) {
+	c, archiveURL := newServer(t)
+	readAll := reader(t)
+	ctx := context.Background()
+	data := readAll(c.Pack(ctx, archiveURL, &PackRequest{
+		Include:     []string{"dir/*"},
+		StripPrefix: "dir/",
+		Rewrite:     []PrefixRewrite{{From: "b", To: "c"}},
+	}))
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil || len(zr.File) != 1 || zr.File[0].Name != "c.txt" {
+		t.Fatalf("Pack: unexpected archive,err:%v", err)
+	}
+
+	level := 0
+	data = readAll(c.Pack(ctx, archiveURL, &PackRequest{Names: []string{"a.txt"}, Output: "tar", Level: &level, Manifest: "m.json"}))
+	if len(data) == 0 || len(data)%512 != 0 {
+		t.Errorf("Pack to tar: got %d bytes", len(data))
+	}
+
+	// a missing name is only known at the end of the archive
+	body, err := c.Pack(ctx, archiveURL, &PackRequest{Names: []string{"a.txt", "missing.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(body)
+	body.Close()
+	var packErr *PackError
+	if !errors.As(err, &packErr) || packErr.Status != "incomplete" || packErr.Entries != 1 || packErr.Missing != 1 {
+		t.Errorf("Pack of a missing name: got %v", err)
+	}
+
+	_, err = c.Pack(ctx, archiveURL, &PackRequest{Names: []string{"missing.txt"}, Strict: true})
+	var apiErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || len(apiErr.Missing) != 1 || apiErr.Missing[0] != "missing.txt" {
+		t.Errorf("strict Pack of a missing name: got %v", err)
+	}
+}
+
+func TestTest(t *testing.T) {
+	c, archiveURL := newServer(t)
+	res, err := c.Test(context.Background(), archiveURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK || res.FileType != "zip" || res.Passed != 3 || res.Failed != 0 || res.Bytes != 9 {
+		t.Errorf("unexpected test result %+v", res)
+	}
+}
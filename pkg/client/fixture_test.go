@@ -0,0 +1,48 @@
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Heng-Bian/archive-proxy/internal/archiveproxy"
+)
+
+// newServer starts an archive-server over a file server of a.zip, which
+// holds a.txt and dir/b.txt, and returns a client of it and the URL of the
+// archive.
+func newServer(t *testing.T) (*Client, string) {
+	t.Helper()
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range []struct{ name, content string }{
+		{"a.txt", "hello\n"},
+		{"dir/", ""},
+		{"dir/b.txt", "bb\n"},
+	} {
+		w, err := zw.Create(file.name)
+		if err == nil {
+			_, err = io.WriteString(w, file.content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.zip"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(files.Close)
+	server := httptest.NewServer(&archiveproxy.Proxy{})
+	t.Cleanup(server.Close)
+	return New(server.URL), files.URL + "/a.zip"
+}